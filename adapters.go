@@ -0,0 +1,66 @@
+package zipwalk
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// NewLineWalkFunc returns a WalkFunc that calls fn for every line of every file, including the
+// files inside zip files.  Zip files themselves and directories are not scanned.  An error
+// returned by fn stops the scanning of that file only, and the walk moves on to the next file.
+func NewLineWalkFunc(fn func(path, line string) error) WalkFunc {
+	return func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		if err != nil {
+			return err
+		}
+		if reader == nil || info.IsDir() || isZip(path) {
+			return nil
+		}
+		scanner := bufio.NewScanner(reader)
+		for scanner.Scan() {
+			if fn(path, scanner.Text()) != nil {
+				return nil
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("Error scanning %s - %w", path, err)
+		}
+		return nil
+	}
+}
+
+// WalkCompat is Walk for a filepath.WalkFunc, so that calls to filepath.Walk can be switched to
+// zipwalk by changing the function name alone.  fn is called for the files inside zip files
+// too.  Like filepath.Walk, the real files are walked serially in lexical order, so fn is never
+// called concurrently.
+func WalkCompat(root string, fn filepath.WalkFunc, opts ...Option) error {
+	o := newOptions(opts)
+	o.serial = true
+	return o.run(func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		return fn(path, info, err)
+	}, func(walkFn WalkFunc) error {
+		return walk(root, walkFn, o)
+	})
+}
+
+// WalkFuncAdaptor returns a WalkFunc that calls fn, an fs.WalkDirFunc, with an fs.DirEntry built
+// from the os.FileInfo of each path, so that code written for fs.WalkDir can walk into zip
+// files.  The content of files is not handed to fn.  fn can return fs.SkipDir for a zip file to
+// skip its entries, as it would for a directory.
+func WalkFuncAdaptor(fn fs.WalkDirFunc) WalkFunc {
+	return func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		var d fs.DirEntry
+		if info != nil {
+			d = fs.FileInfoToDirEntry(info)
+		}
+		ret := fn(path, d, err)
+		if ret == fs.SkipDir && info != nil && !info.IsDir() && isZip(path) {
+			return SkipZip
+		}
+		return ret
+	}
+}
@@ -0,0 +1,152 @@
+package zipwalk
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/sha1"
+	"encoding/binary"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+)
+
+// methodAES is the zip compression method of entries encrypted with WinZip AES encryption.  The
+// actual compression method is stored in the AES extra field.
+const methodAES = 99
+
+// aesExtraID is the ID of the WinZip AES extra field.
+const aesExtraID = 0x9901
+
+// aesAuthLen is the length of the authentication code that follows the encrypted data.
+const aesAuthLen = 10
+
+// aesExtra returns the fields of the WinZip AES extra field of fh: the vendor version, 1 for
+// AE-1 or 2 for AE-2, the key length in bytes and the actual compression method.
+func aesExtra(fh *zip.FileHeader) (version uint16, keyLen int, method uint16, ok bool) {
+	for extra := fh.Extra; len(extra) >= 4; {
+		id := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		if len(extra) < 4+size {
+			break
+		}
+		field := extra[4 : 4+size]
+		extra = extra[4+size:]
+		if id != aesExtraID || size < 7 || string(field[2:4]) != "AE" {
+			continue
+		}
+		strength := field[4]
+		if strength < 1 || strength > 3 {
+			return 0, 0, 0, false
+		}
+		return binary.LittleEndian.Uint16(field), 8 * (int(strength) + 1), binary.LittleEndian.Uint16(field[5:]), true
+	}
+	return 0, 0, 0, false
+}
+
+// newAESEntryReader checks password against the password verifier at the start of raw, the
+// compressed bytes of f, and returns a reader of the decrypted and decompressed content of f.
+// The authentication code at the end of raw is checked once the content has been read, and so
+// is the CRC-32 of AE-1 entries; AE-2 entries do not record it.
+func newAESEntryReader(raw io.Reader, f *zip.File, password string) (io.ReadCloser, error) {
+	version, keyLen, method, ok := aesExtra(&f.FileHeader)
+	if !ok {
+		return nil, zip.ErrFormat
+	}
+	saltLen := keyLen / 2
+	dataLen := int64(f.CompressedSize64) - int64(saltLen) - 2 - aesAuthLen
+	if dataLen < 0 {
+		return nil, zip.ErrFormat
+	}
+	header := make([]byte, saltLen+2)
+	if _, err := io.ReadFull(raw, header); err != nil {
+		return nil, err
+	}
+	keys, err := pbkdf2.Key(sha1.New, password, header[:saltLen], 1000, 2*keyLen+2)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(keys[2*keyLen:], header[saltLen:]) {
+		return nil, ErrWrongPassword
+	}
+	block, err := aes.NewCipher(keys[:keyLen])
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha1.New, keys[keyLen:2*keyLen])
+	decrypted := &aesCTRReader{r: io.TeeReader(io.LimitReader(raw, dataLen), mac), raw: raw, block: block, mac: mac}
+	rc, err := decompressor(method, decrypted, int64(f.UncompressedSize64))
+	if err != nil {
+		return nil, err
+	}
+	if version == 1 {
+		rc = &checksumReader{ReadCloser: rc, hash: crc32.NewIEEE(), want: f.CRC32}
+	}
+	return &aesEntryReader{ReadCloser: rc, decrypted: decrypted}, nil
+}
+
+// aesEntryReader reads what is left of the encrypted data once the decompressed content has been
+// read, so that the authentication code is checked even when the decompressor stops early.
+type aesEntryReader struct {
+	io.ReadCloser
+	decrypted *aesCTRReader
+}
+
+func (ar *aesEntryReader) Read(p []byte) (int, error) {
+	n, err := ar.ReadCloser.Read(p)
+	if err == io.EOF {
+		if _, err := io.Copy(ioutil.Discard, ar.decrypted); err != nil {
+			return n, err
+		}
+	}
+	return n, err
+}
+
+// aesCTRReader decrypts data encrypted with AES in the counter mode of WinZip, whose counter is
+// little-endian and starts at 1, and checks the authentication code that follows it in raw.
+type aesCTRReader struct {
+	r         io.Reader // the encrypted data, which is also fed to mac
+	raw       io.Reader // the rest of the entry, starting with the authentication code
+	block     cipher.Block
+	mac       hash.Hash
+	counter   [aes.BlockSize]byte
+	keystream [aes.BlockSize]byte
+	used      int // bytes of keystream used
+	done      bool
+}
+
+func (cr *aesCTRReader) Read(p []byte) (int, error) {
+	if cr.done {
+		return 0, io.EOF
+	}
+	n, err := cr.r.Read(p)
+	for i := 0; i < n; i++ {
+		if cr.used == 0 || cr.used == aes.BlockSize {
+			for j := range cr.counter {
+				cr.counter[j]++
+				if cr.counter[j] != 0 {
+					break
+				}
+			}
+			cr.block.Encrypt(cr.keystream[:], cr.counter[:])
+			cr.used = 0
+		}
+		p[i] ^= cr.keystream[cr.used]
+		cr.used++
+	}
+	if err == io.EOF {
+		cr.done = true
+		auth := make([]byte, aesAuthLen)
+		if _, err := io.ReadFull(cr.raw, auth); err != nil {
+			return n, err
+		}
+		if !hmac.Equal(auth, cr.mac.Sum(nil)[:aesAuthLen]) {
+			return n, zip.ErrChecksum
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,42 @@
+package zipwalk
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// auditRecord is a line of the audit log written by WithAuditLog.
+type auditRecord struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	IsDir   bool      `json:"is_dir"`
+}
+
+// WithAuditLog writes a line of JSON to w for every path handed to walkFn, e.g.,
+// {"path":"a.zip/a.txt","size":8,"mod_time":"2021-01-02T15:04:05Z","is_dir":false}, whatever
+// walkFn returns for it.  Writes to w are serialised, so w need not be safe for concurrent use.
+func WithAuditLog(w io.Writer) Option {
+	return func(o *options) {
+		o.auditLog = &auditLog{enc: json.NewEncoder(w)}
+	}
+}
+
+// auditLog serialises the writes of WithAuditLog.
+type auditLog struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func (al *auditLog) record(path string, info os.FileInfo) {
+	rec := auditRecord{Path: path}
+	if info != nil {
+		rec.Size, rec.ModTime, rec.IsDir = info.Size(), info.ModTime(), info.IsDir()
+	}
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	al.enc.Encode(rec)
+}
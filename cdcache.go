@@ -0,0 +1,169 @@
+package zipwalk
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// ZipCDCache stores the central directories of zip files on the filesystem between walks, so
+// that walking the same zip file again does not read its central directory from disk again.
+// The bytes of the central directory are stored rather than the entries parsed from them, so
+// the central directory is still parsed on every walk and only reading it is saved.  Entries
+// are keyed by the path, modification time and size of the zip file, so a zip file that has
+// been modified since its central directory was stored misses the cache, even when it is
+// rewritten within the resolution of its modification time.  Implementations backed by
+// Redis or by files on disk only need to store the bytes they are given.
+type ZipCDCache interface {
+	// Get returns the central directory stored for the zip file at path with the given
+	// modification time and size, if any.
+	Get(path string, modTime time.Time, size int64) ([]byte, bool)
+	// Put stores the central directory of the zip file at path with the given modification
+	// time and size, from the start of its first record to the end of the zip file.
+	Put(path string, modTime time.Time, size int64, dir []byte)
+}
+
+// WithCentralDirectoryCache reads the central directories of the zip files on the filesystem
+// through cache, storing them in it when they are missing.  Nested zip files are read into
+// memory anyway and are not cached.
+func WithCentralDirectoryCache(cache ZipCDCache) Option {
+	return func(o *options) {
+		o.cdCache = cache
+	}
+}
+
+// NewMemoryCDCache returns a ZipCDCache that keeps central directories in memory, one per path.
+// Storing the central directory of a zip file replaces the one stored for an older
+// modification time or another size.  It is safe for concurrent use.
+func NewMemoryCDCache() ZipCDCache {
+	return &memoryCDCache{dirs: map[string]cachedDir{}}
+}
+
+type cachedDir struct {
+	modTime time.Time
+	size    int64
+	dir     []byte
+}
+
+type memoryCDCache struct {
+	mu   sync.Mutex
+	dirs map[string]cachedDir
+}
+
+func (c *memoryCDCache) Get(path string, modTime time.Time, size int64) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cached, ok := c.dirs[path]
+	if !ok || !cached.modTime.Equal(modTime) || cached.size != size {
+		return nil, false
+	}
+	return cached.dir, true
+}
+
+func (c *memoryCDCache) Put(path string, modTime time.Time, size int64, dir []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dirs[path] = cachedDir{modTime: modTime, size: size, dir: dir}
+}
+
+// newCachedZipReader is newZipReader for the zip file at path, reading its central directory
+// through the cache of o.  The rest of the zip file is still read from r.
+func newCachedZipReader(o *options, path string, info os.FileInfo, r io.ReaderAt, size int64) (*zip.Reader, error) {
+	dir, ok := o.cdCache.Get(path, info.ModTime(), size)
+	if !ok || int64(len(dir)) > size {
+		start, ok := directoryStart(r, size)
+		if !ok {
+			return newZipReader(r, size)
+		}
+		dir = make([]byte, size-start)
+		if _, err := r.ReadAt(dir, start); err != nil && err != io.EOF {
+			return nil, err
+		}
+		o.cdCache.Put(path, info.ModTime(), size, dir)
+	}
+	start := size - int64(len(dir))
+	return newZipReader(newMultiReaderAt([]io.ReaderAt{r, bytes.NewReader(dir)}, []int64{start, int64(len(dir))}), size)
+}
+
+const (
+	zip64LocatorSignature      = 0x07064b50
+	zip64DirectoryEndSignature = 0x06064b50
+	zip64LocatorLen            = 20
+	zip64DirectoryEndLen       = 56
+)
+
+// directoryStart returns the offset of the central directory of the zip data in r, which is
+// followed by the end of central directory records up to the end of the data, or false if it
+// cannot be found.
+func directoryStart(r io.ReaderAt, size int64) (int64, bool) {
+	end, endOffset, err := readDirectoryEnd(r, size)
+	if err != nil {
+		return 0, false
+	}
+	loc := make([]byte, zip64LocatorLen)
+	if endOffset < zip64LocatorLen {
+		return 0, false
+	}
+	if _, err := r.ReadAt(loc, endOffset-zip64LocatorLen); err != nil || binary.LittleEndian.Uint32(loc) != zip64LocatorSignature {
+		start := endOffset - int64(end.dirSize)
+		return start, start >= 0
+	}
+	// a Zip64 file, whose central directory is in front of the zip64 end of central directory
+	// record that the locator points to
+	recOffset := int64(binary.LittleEndian.Uint64(loc[8:]))
+	if recOffset < 0 || recOffset > endOffset-zip64LocatorLen-zip64DirectoryEndLen {
+		return 0, false
+	}
+	rec := make([]byte, zip64DirectoryEndLen)
+	if _, err := r.ReadAt(rec, recOffset); err != nil || binary.LittleEndian.Uint32(rec) != zip64DirectoryEndSignature {
+		return 0, false
+	}
+	start := recOffset - int64(binary.LittleEndian.Uint64(rec[40:]))
+	return start, start >= 0 && start <= recOffset
+}
+
+// WarmupStats summarises the central directories stored by CacheWarmup.
+type WarmupStats struct {
+	Zips    int // zip files whose central directory is in the cache
+	Entries int // entries of those zip files
+}
+
+// CacheWarmup stores the central directories of the zip files on the filesystem under root in
+// cache, ahead of walks using WithCentralDirectoryCache with the same cache and root.  Zip files
+// are not descended into, and zip files that cannot be cached, see WithCentralDirectoryCache,
+// are passed over.  opts can adjust the walk, such as with WithSkipHiddenFiles.
+func CacheWarmup(root string, cache ZipCDCache, opts ...Option) (WarmupStats, error) {
+	o := newOptions(opts)
+	o.cdCache = cache
+	var mu sync.Mutex
+	var stats WarmupStats
+	err := o.run(func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		if err != nil {
+			return err
+		}
+		f, real := reader.(*os.File)
+		if !real || !isZip(path) {
+			return nil
+		}
+		zr, err := newCachedZipReader(o, path, info, f, info.Size())
+		if err != nil {
+			log.Printf("File %s is not a valid zip file - %v", path, err)
+			return SkipZip
+		}
+		if _, ok := cache.Get(path, info.ModTime(), info.Size()); ok {
+			mu.Lock()
+			stats.Zips++
+			stats.Entries += len(zr.File)
+			mu.Unlock()
+		}
+		return SkipZip
+	}, func(walkFn WalkFunc) error {
+		return walk(root, walkFn, o)
+	})
+	return stats, err
+}
@@ -0,0 +1,80 @@
+package zipwalk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+)
+
+// SetEntryComment sets the comment of the entry named entryName in the zip file at zipPath, or
+// clears it when comment is empty.  Only the central directory at the end of the zip file is
+// rewritten, in place, so the compressed data of the entries is neither read nor copied.  Zip64
+// files and zip files inside other zip files are not supported.
+func SetEntryComment(zipPath, entryName, comment string) error {
+	if len(comment) > 0xffff {
+		return fmt.Errorf("zipwalk: comment of %d bytes is too long for zip entry %s", len(comment), entryName)
+	}
+	f, err := os.OpenFile(zipPath, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("error opening zip file - %s - %w", zipPath, err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	end, endOffset, err := readDirectoryEnd(f, info.Size())
+	if err != nil {
+		return fmt.Errorf("Error reading zip file - %s - %w", zipPath, err)
+	}
+	if end.dirSize == 0xffffffff || end.dirOffset == 0xffffffff || end.dirRecords == 0xffff {
+		return fmt.Errorf("zipwalk: zip64 files are not supported - %s", zipPath)
+	}
+	start := endOffset - int64(end.dirSize)
+	if start < 0 {
+		return fmt.Errorf("Error reading zip file - %s - %w", zipPath, errNoDirectoryEnd)
+	}
+	tail := make([]byte, info.Size()-start)
+	if _, err := f.ReadAt(tail, start); err != nil {
+		return fmt.Errorf("Error reading zip file - %s - %w", zipPath, err)
+	}
+	dir, eocd := tail[:end.dirSize], tail[end.dirSize:]
+	var out bytes.Buffer
+	found := false
+	for len(dir) > 0 {
+		if len(dir) < directoryHeaderLen || binary.LittleEndian.Uint32(dir) != directoryHeaderSignature {
+			return fmt.Errorf("Error reading zip file - %s - malformed central directory", zipPath)
+		}
+		nameLen := int(binary.LittleEndian.Uint16(dir[28:]))
+		extraLen := int(binary.LittleEndian.Uint16(dir[30:]))
+		commentLen := int(binary.LittleEndian.Uint16(dir[32:]))
+		recordLen := directoryHeaderLen + nameLen + extraLen + commentLen
+		if len(dir) < recordLen {
+			return fmt.Errorf("Error reading zip file - %s - malformed central directory", zipPath)
+		}
+		record := dir[:recordLen]
+		dir = dir[recordLen:]
+		if string(record[directoryHeaderLen:directoryHeaderLen+nameLen]) != entryName {
+			out.Write(record)
+			continue
+		}
+		found = true
+		header := append([]byte(nil), record[:directoryHeaderLen+nameLen+extraLen]...)
+		binary.LittleEndian.PutUint16(header[32:], uint16(len(comment)))
+		out.Write(header)
+		out.WriteString(comment)
+	}
+	if !found {
+		return fmt.Errorf("%w - %s in %s", os.ErrNotExist, entryName, zipPath)
+	}
+	binary.LittleEndian.PutUint32(eocd[12:], uint32(out.Len()))
+	out.Write(eocd)
+	if _, err := f.WriteAt(out.Bytes(), start); err != nil {
+		return err
+	}
+	if err := f.Truncate(start + int64(out.Len())); err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,65 @@
+package zipwalk
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ContentHash writes a fingerprint of the tree rooted at root, including the contents of the
+// zip files in it, to h.  Every file contributes its path relative to root and the SHA-256 of
+// its content, and every directory, including the directories inside zip files, only its path.
+// Zip and tar files contribute only their path as well, since their bytes depend on the tool
+// that wrote them.  The paths are written in lexical order, so the result does not depend on
+// the platform, the walk order or the order of the entries within zip files.
+func ContentHash(root string, h hash.Hash) error {
+	var mu sync.Mutex
+	digests := map[string][]byte{} // nil for directories and archives
+	err := Walk(root, func(filePath string, info os.FileInfo, reader io.Reader, err error) error {
+		if err != nil {
+			return err
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(filepath.ToSlash(filePath), filepath.ToSlash(root)), "/")
+		if rel == "" {
+			rel = "."
+		}
+		var digest []byte
+		if !info.IsDir() && !isZip(filePath) && !isTar(filePath) {
+			sum := sha256.New()
+			if _, err := io.Copy(sum, reader); err != nil {
+				return fmt.Errorf("Error reading file - %s - %w", filePath, err)
+			}
+			digest = sum.Sum(nil)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		digests[rel] = digest
+		// zip files need not hold entries for their directories, so they are implied
+		for dir := path.Dir(rel); dir != "."; dir = path.Dir(dir) {
+			if _, ok := digests[dir]; ok {
+				break
+			}
+			digests[dir] = nil
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	paths := make([]string, 0, len(digests))
+	for p := range digests {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		fmt.Fprintf(h, "%s\x00%x\n", p, digests[p])
+	}
+	return nil
+}
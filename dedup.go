@@ -0,0 +1,49 @@
+package zipwalk
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// WithContentDedup calls walkFn only for the first file with a given content, by SHA-256,
+// including the files inside zip files.  For every later file with the same content, fn is
+// called with its path and the path of the first one instead.  Zip files themselves are not
+// compared, as their entries are.  Files are read into memory to be hashed before walkFn is
+// called for them.  When the walk is not serial, which of several files with the same content
+// counts as the first depends on the order they are read in.
+func WithContentDedup(fn func(newPath, originalPath string, info os.FileInfo)) Option {
+	return func(o *options) {
+		o.dedup = &contentDedup{fn: fn, seen: map[[sha256.Size]byte]string{}}
+	}
+}
+
+// contentDedup maps the hashes of the files seen by a walk to the paths they were seen at.
+type contentDedup struct {
+	fn   func(newPath, originalPath string, info os.FileInfo)
+	mu   sync.Mutex
+	seen map[[sha256.Size]byte]string
+}
+
+// check reads the content of the file at path, returning whether a file with the same content
+// has been seen before, in which case fn has been called for it, and a reader of the content.
+func (cd *contentDedup) check(path string, info os.FileInfo, reader io.Reader) (io.Reader, bool, error) {
+	content, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, false, err
+	}
+	sum := sha256.Sum256(content)
+	cd.mu.Lock()
+	original, dup := cd.seen[sum]
+	if !dup {
+		cd.seen[sum] = path
+	}
+	cd.mu.Unlock()
+	if dup {
+		cd.fn(path, original, info)
+	}
+	return bytes.NewReader(content), dup, nil
+}
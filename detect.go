@@ -0,0 +1,72 @@
+package zipwalk
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// archiveFormat is a format recognised by its magic bytes.
+type archiveFormat int
+
+const (
+	formatUnknown archiveFormat = iota
+	formatZip
+	formatGzip
+	formatTar
+)
+
+// detectFormat recognises the format of the data in r by its first few bytes.
+func detectFormat(r io.ReaderAt) archiveFormat {
+	magic := make([]byte, 4)
+	n, _ := r.ReadAt(magic, 0)
+	magic = magic[:n]
+	ustar := make([]byte, 5)
+	r.ReadAt(ustar, 257)
+	switch {
+	case string(ustar) == "ustar":
+		return formatTar
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")), bytes.HasPrefix(magic, []byte("PK\x05\x06")):
+		return formatZip
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		return formatGzip
+	}
+	return formatUnknown
+}
+
+// WalkIO walks the data of the given size read from r, recognising its format by its magic
+// bytes rather than by the extension of name, which is only used to build the paths handed to
+// walkFn.  Zip files are walked like WalkAt does, and tar archives like Walk does.
+// Gzip-compressed data is decompressed into memory and recognised again under name without its
+// .gz suffix.  Anything else is handed to walkFn as a regular file.
+func WalkIO(r io.ReaderAt, size int64, name string, walkFn WalkFunc, opts ...Option) error {
+	o := newOptions(opts)
+	return o.run(walkFn, func(walkFn WalkFunc) error {
+		return walkIO(o, r, size, name, walkFn)
+	})
+}
+
+func walkIO(o *options, r io.ReaderAt, size int64, name string, walkFn WalkFunc) error {
+	info := objectInfo{name: filepath.Base(name), size: size}
+	switch detectFormat(r) {
+	case formatZip:
+		return walkFuncRecursive(o, 1, name, info, io.NewSectionReader(r, 0, size), walkFn, nil)
+	case formatTar:
+		return walkTar(o, name, info, io.NewSectionReader(r, 0, size), walkFn)
+	case formatGzip:
+		gr, err := gzip.NewReader(io.NewSectionReader(r, 0, size))
+		if err != nil {
+			return fmt.Errorf("Error reading file - %s - %w", name, err)
+		}
+		data, err := ioutil.ReadAll(gr)
+		if err != nil {
+			return fmt.Errorf("Error reading file - %s - %w", name, err)
+		}
+		return walkIO(o, bytes.NewReader(data), int64(len(data)), strings.TrimSuffix(name, ".gz"), walkFn)
+	}
+	return walkFn(name, info, io.NewSectionReader(r, 0, size), nil)
+}
@@ -0,0 +1,159 @@
+package zipwalk
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ChangeType is the kind of difference WalkDiff found for a path.
+type ChangeType int
+
+// The kinds of differences reported by WalkDiff.
+const (
+	Unchanged ChangeType = iota
+	Added                // only in pathB
+	Removed              // only in pathA
+	Modified             // in both, with a different size, modification time or content
+)
+
+func (ct ChangeType) String() string {
+	switch ct {
+	case Unchanged:
+		return "Unchanged"
+	case Added:
+		return "Added"
+	case Removed:
+		return "Removed"
+	case Modified:
+		return "Modified"
+	}
+	return fmt.Sprintf("ChangeType(%d)", int(ct))
+}
+
+// WithDiffContent makes WalkDiff compare the content of files rather than their modification
+// times, so that rebuilt but identical files are reported as Unchanged.
+func WithDiffContent() Option {
+	return func(o *options) {
+		o.diffContent = true
+	}
+}
+
+// WalkDiff compares the trees at pathA and pathB, each of which may be a directory, a zip file
+// or a path inside zip files, including the contents of the zip files in them.  fn is called
+// once for every path found in either tree, relative to its root and in the order of
+// WithCanonicalOrder, with the os.FileInfo from each tree that has it.  Zip entries are
+// compared by the modification times in their headers.  An error returned by fn stops the
+// comparison.
+func WalkDiff(pathA, pathB string, fn func(change ChangeType, path string, infoA, infoB os.FileInfo) error, opts ...Option) error {
+	o := newOptions(opts)
+	a, err := diffTree(pathA, o)
+	if err != nil {
+		return err
+	}
+	b, err := diffTree(pathB, o)
+	if err != nil {
+		return err
+	}
+	paths := make([]string, 0, len(a)+len(b))
+	for p := range a {
+		paths = append(paths, p)
+	}
+	for p := range b {
+		if _, ok := a[p]; !ok {
+			paths = append(paths, p)
+		}
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		return canonicalLess(paths[i], paths[j])
+	})
+	for _, p := range paths {
+		entryA, inA := a[p]
+		entryB, inB := b[p]
+		change := Unchanged
+		switch {
+		case !inA:
+			change = Added
+		case !inB:
+			change = Removed
+		case entryA.info.IsDir() != entryB.info.IsDir():
+			change = Modified
+		case entryA.info.IsDir():
+		case o.diffContent:
+			if !bytes.Equal(entryA.digest, entryB.digest) {
+				change = Modified
+			}
+		case entryA.info.Size() != entryB.info.Size() || !entryModTime(entryA.info).Equal(entryModTime(entryB.info)):
+			change = Modified
+		}
+		if err := fn(change, p, entryA.info, entryB.info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// diffEntry is a path found by diffTree.
+type diffEntry struct {
+	info   os.FileInfo
+	digest []byte // SHA-256 of the content of files, with WithDiffContent
+}
+
+// diffTree walks the tree at root, returning its entries by their slash separated paths
+// relative to root.
+func diffTree(root string, o *options) (map[string]diffEntry, error) {
+	var mu sync.Mutex
+	entries := map[string]diffEntry{}
+	prefix := filepath.ToSlash(filepath.Clean(root))
+	walkFn := func(filePath string, info os.FileInfo, reader io.Reader, err error) error {
+		if err != nil {
+			return err
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(filepath.ToSlash(filePath), prefix), "/")
+		if rel == "" {
+			return nil
+		}
+		entry := diffEntry{info: info}
+		if o.diffContent && reader != nil && !info.IsDir() {
+			h := sha256.New()
+			if _, err := io.Copy(h, reader); err != nil {
+				return fmt.Errorf("Error reading file - %s - %w", filePath, err)
+			}
+			entry.digest = h.Sum(nil)
+		}
+		mu.Lock()
+		entries[rel] = entry
+		mu.Unlock()
+		return nil
+	}
+	return entries, o.run(walkFn, func(walkFn WalkFunc) error {
+		if zipPath, _ := splitZipPath(root); zipPath == "" {
+			return walk(root, walkFn, o)
+		}
+		zr, info, closer, err := openZip(root)
+		if err != nil {
+			return err
+		}
+		defer closer.Close()
+		return walkZipEntries(o, 1, prefix, info, zr, walkFn)
+	})
+}
+
+// entryModTime returns the modification time recorded for a zip entry, rather than the time of
+// the zip file that ZipFileInfo reports, or the modification time of anything else.
+func entryModTime(info os.FileInfo) time.Time {
+	if h, ok := info.(Headered); ok && h.ZipHeader() != nil {
+		if t, ok := ntfsModTime(h.ZipHeader()); ok {
+			return t
+		}
+		return h.ZipHeader().Modified
+	}
+	return info.ModTime()
+}
@@ -0,0 +1,158 @@
+package zipwalk
+
+import (
+	"archive/zip"
+	"compress/bzip2"
+	"compress/flate"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+)
+
+// ErrEncrypted is passed to walkFn for encrypted zip entries when no password is known for
+// them, see WithPassword and WithPasswordFunc.
+var ErrEncrypted = fmt.Errorf("zipwalk: zip entry is encrypted")
+
+// ErrWrongPassword is passed to walkFn for encrypted zip entries that the password given for
+// them does not decrypt.
+var ErrWrongPassword = fmt.Errorf("zipwalk: wrong password for zip entry")
+
+// WithPassword decrypts encrypted zip entries with password, whether they use the traditional
+// PKWARE encryption known as ZipCrypto or WinZip AES encryption.
+func WithPassword(password string) Option {
+	return WithPasswordFunc(func(path string) string {
+		return password
+	})
+}
+
+// WithPasswordFunc decrypts each encrypted zip entry with the password that fn returns for its
+// path, like WithPassword does.  An empty password leaves the entry encrypted.
+func WithPasswordFunc(fn func(path string) string) Option {
+	return func(o *options) {
+		o.password = fn
+	}
+}
+
+// openEntry opens the zip entry f at path for reading, decrypting it if need be.
+func openEntry(o *options, path string, f *zip.File) (io.ReadCloser, error) {
+	if f.Flags&0x1 == 0 {
+		if f.Method == methodLZMA {
+			// unlike the decompressor registered by newZipReader, this one knows the size of
+			// the entry, so it also reads entries written without an end marker
+			raw, err := f.OpenRaw()
+			if err != nil {
+				return nil, err
+			}
+			return &checksumReader{ReadCloser: newLZMAReader(raw, int64(f.UncompressedSize64)), hash: crc32.NewIEEE(), want: f.CRC32}, nil
+		}
+		return f.Open()
+	}
+	password := ""
+	if o.password != nil {
+		password = o.password(path)
+	}
+	if password == "" {
+		return nil, ErrEncrypted
+	}
+	raw, err := f.OpenRaw()
+	if err != nil {
+		return nil, err
+	}
+	if f.Method == methodAES {
+		return newAESEntryReader(raw, f, password)
+	}
+	decrypted, err := newZipCryptoReader(raw, f, password)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := decompressor(f.Method, decrypted, int64(f.UncompressedSize64))
+	if err != nil {
+		return nil, err
+	}
+	return &checksumReader{ReadCloser: rc, hash: crc32.NewIEEE(), want: f.CRC32}, nil
+}
+
+// decompressor returns a reader of the size bytes decompressed from r with the zip compression
+// method.
+func decompressor(method uint16, r io.Reader, size int64) (io.ReadCloser, error) {
+	switch method {
+	case zip.Store:
+		return ioutil.NopCloser(r), nil
+	case zip.Deflate:
+		return flate.NewReader(r), nil
+	case methodBzip2:
+		return ioutil.NopCloser(bzip2.NewReader(r)), nil
+	case methodLZMA:
+		return newLZMAReader(r, size), nil
+	}
+	return nil, zip.ErrAlgorithm
+}
+
+// zipCryptoReader decrypts data encrypted with the traditional PKWARE encryption.
+type zipCryptoReader struct {
+	r    io.Reader
+	keys [3]uint32
+}
+
+// newZipCryptoReader checks password against the encryption header at the start of r and
+// returns a reader of the rest of r, decrypted.
+func newZipCryptoReader(r io.Reader, f *zip.File, password string) (*zipCryptoReader, error) {
+	z := &zipCryptoReader{r: r, keys: [3]uint32{0x12345678, 0x23456789, 0x34567890}}
+	for i := 0; i < len(password); i++ {
+		z.update(password[i])
+	}
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	z.decrypt(header)
+	// the last byte of the header repeats the high byte of the CRC, or of the modification time
+	// when the CRC was not known before the data was written
+	check := byte(f.CRC32 >> 24)
+	if f.Flags&0x8 != 0 {
+		check = byte(f.ModifiedTime >> 8)
+	}
+	if header[11] != check {
+		return nil, ErrWrongPassword
+	}
+	return z, nil
+}
+
+func (z *zipCryptoReader) Read(p []byte) (int, error) {
+	n, err := z.r.Read(p)
+	z.decrypt(p[:n])
+	return n, err
+}
+
+func (z *zipCryptoReader) decrypt(p []byte) {
+	for i, c := range p {
+		temp := uint16(z.keys[2] | 2)
+		p[i] = c ^ byte((temp*(temp^1))>>8)
+		z.update(p[i])
+	}
+}
+
+func (z *zipCryptoReader) update(c byte) {
+	z.keys[0] = crc32.IEEETable[byte(z.keys[0])^c] ^ (z.keys[0] >> 8)
+	z.keys[1] = (z.keys[1]+(z.keys[0]&0xff))*134775813 + 1
+	z.keys[2] = crc32.IEEETable[byte(z.keys[2])^byte(z.keys[1]>>24)] ^ (z.keys[2] >> 8)
+}
+
+// checksumReader returns zip.ErrChecksum at the end of the data if its CRC-32 is not want.
+// archive/zip does the same for the entries it decompresses itself.
+type checksumReader struct {
+	io.ReadCloser
+	hash hash.Hash32
+	want uint32
+}
+
+func (cr *checksumReader) Read(p []byte) (int, error) {
+	n, err := cr.ReadCloser.Read(p)
+	cr.hash.Write(p[:n])
+	if err == io.EOF && cr.hash.Sum32() != cr.want {
+		return n, zip.ErrChecksum
+	}
+	return n, err
+}
@@ -0,0 +1,82 @@
+package zipwalk
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// copyBufferPool holds the 32KB buffers used by EntryWriterTo.
+var copyBufferPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, 32*1024)
+		return &buf
+	},
+}
+
+// EntryWriterTo writes the decompressed content of the file at Path, which may be inside zip
+// files, e.g., file1.zip/file2.zip/a.txt, without reading it into memory first.
+type EntryWriterTo struct {
+	Path string
+}
+
+// WriteTo implements io.WriterTo.
+func (e EntryWriterTo) WriteTo(w io.Writer) (int64, error) {
+	rc, err := openFile(e.Path)
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+	buf := copyBufferPool.Get().(*[]byte)
+	defer copyBufferPool.Put(buf)
+	return io.CopyBuffer(w, rc, *buf)
+}
+
+// Encode returns the content of the file at path, which may be inside zip files, e.g.,
+// file1.zip/a.txt, encoded with encoding, such as base64.StdEncoding, for embedding in JSON.
+func Encode(path string, encoding *base64.Encoding) (string, error) {
+	buf := new(strings.Builder)
+	enc := base64.NewEncoder(encoding, buf)
+	if _, err := (EntryWriterTo{Path: path}).WriteTo(enc); err != nil {
+		return "", err
+	}
+	if err := enc.Close(); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// openFile opens the file at path for reading, which may be a real file or an entry inside zip
+// files.
+func openFile(path string) (io.ReadCloser, error) {
+	if zipPath, _ := splitZipPath(path); zipPath == "" {
+		return os.Open(path)
+	}
+	f, _, closer, err := findEntry(path)
+	if err != nil {
+		return nil, err
+	}
+	rdr, err := f.Open()
+	if err != nil {
+		closer.Close()
+		return nil, fmt.Errorf("Error opening file %s - %w", path, err)
+	}
+	return entryReadCloser{rdr, closer}, nil
+}
+
+// entryReadCloser closes the zip file an entry was read from along with the entry.
+type entryReadCloser struct {
+	io.ReadCloser
+	zipCloser io.Closer
+}
+
+func (erc entryReadCloser) Close() error {
+	err := erc.ReadCloser.Close()
+	if zerr := erc.zipCloser.Close(); err == nil {
+		err = zerr
+	}
+	return err
+}
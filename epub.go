@@ -0,0 +1,71 @@
+package zipwalk
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// defaultEPUBPackage is where most EPUB files keep their package document.
+const defaultEPUBPackage = "OEBPS/content.opf"
+
+// EPUBItem is an item of the manifest in an EPUB package document.
+type EPUBItem struct {
+	ID        string `xml:"id,attr"`
+	Href      string `xml:"href,attr"` // relative to the package document
+	MediaType string `xml:"media-type,attr"`
+}
+
+// ParseEPUBManifest returns the manifest items of the EPUB file at path, which may be inside
+// zip files, e.g., books.zip/book.epub.  The package document is located through
+// META-INF/container.xml, falling back to OEBPS/content.opf.
+func ParseEPUBManifest(path string) ([]EPUBItem, error) {
+	zr, _, closer, err := openZip(path)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+	opfPath := defaultEPUBPackage
+	if buf, err := readZipEntry(zr, "META-INF/container.xml"); err == nil {
+		var container struct {
+			Rootfiles []struct {
+				FullPath string `xml:"full-path,attr"`
+			} `xml:"rootfiles>rootfile"`
+		}
+		if err := xml.Unmarshal(buf, &container); err != nil {
+			return nil, fmt.Errorf("Error parsing container.xml in %s - %w", path, err)
+		}
+		if len(container.Rootfiles) > 0 && container.Rootfiles[0].FullPath != "" {
+			opfPath = container.Rootfiles[0].FullPath
+		}
+	}
+	buf, err := readZipEntry(zr, opfPath)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading %s in %s - %w", opfPath, path, err)
+	}
+	var pkg struct {
+		Items []EPUBItem `xml:"manifest>item"`
+	}
+	if err := xml.Unmarshal(buf, &pkg); err != nil {
+		return nil, fmt.Errorf("Error parsing %s in %s - %w", opfPath, path, err)
+	}
+	return pkg.Items, nil
+}
+
+// readZipEntry returns the contents of the entry called name in zr.
+func readZipEntry(zr *zip.Reader, name string) ([]byte, error) {
+	for _, f := range zr.File {
+		if f.Name != name {
+			continue
+		}
+		rdr, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rdr.Close()
+		return ioutil.ReadAll(rdr)
+	}
+	return nil, os.ErrNotExist
+}
@@ -0,0 +1,46 @@
+package zipwalk
+
+import (
+	"bytes"
+	"io/fs"
+)
+
+// WalkFS walks the file tree rooted at root in fsys, such as an embed.FS, calling walkFn for
+// each file or directory and descending into zip files like Walk does.  Zip files are read into
+// memory, since fs.FS files do not support random access in general.
+func WalkFS(fsys fs.FS, root string, walkFn WalkFunc, opts ...Option) error {
+	o := newOptions(opts)
+	return o.run(walkFn, func(walkFn WalkFunc) error {
+		return fs.WalkDir(fsys, root, func(filePath string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return walkFn(filePath, nil, nil, err)
+			}
+			if filePath != root && o.skipName(d.Name()) {
+				if d.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil || info.IsDir() {
+				return walkFn(filePath, info, nil, err)
+			}
+			if isZip(filePath) {
+				buf, err := fs.ReadFile(fsys, filePath)
+				if err != nil {
+					return walkFn(filePath, info, nil, err)
+				}
+				return walkFuncRecursive(o, 1, filePath, info, bytes.NewReader(buf), walkFn, nil)
+			}
+			f, err := fsys.Open(filePath)
+			if err != nil {
+				return walkFn(filePath, info, nil, err)
+			}
+			defer f.Close()
+			if isTar(filePath) {
+				return walkTar(o, filePath, info, f, walkFn)
+			}
+			return walkFn(filePath, info, f, nil)
+		})
+	})
+}
@@ -0,0 +1,97 @@
+package zipwalk
+
+import (
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// WalkGlob calls walkFn for every path matching pattern, including the paths inside zip files,
+// e.g., testdata/**/*.zip/**/*.json.  Each slash separated element of pattern uses the syntax
+// of path.Match, and an element of "**" matches any number of elements.  Directories and zip
+// files that no match can lie beneath are not read at all.
+func WalkGlob(pattern string, walkFn WalkFunc, opts ...Option) error {
+	patternElems := strings.Split(pattern, "/")
+	static := 0
+	for static < len(patternElems) && !hasMeta(patternElems[static]) {
+		static++
+	}
+	root := path.Join(patternElems[:static]...)
+	if strings.HasPrefix(pattern, "/") {
+		// path.Join passes over the empty element in front of the root
+		root = "/" + root
+	}
+	if zipPath, _ := splitZipPath(root); zipPath != "" {
+		root = zipPath
+	}
+	if root == "" {
+		root = "."
+	}
+	return Walk(root, func(filePath string, info os.FileInfo, reader io.Reader, err error) error {
+		elems := splitPath(filePath)
+		if globMatch(patternElems, elems, false) {
+			return walkFn(filePath, info, reader, err)
+		}
+		if filePath == root || globMatch(patternElems, elems, true) {
+			if err != nil {
+				return walkFn(filePath, info, nil, err)
+			}
+			return nil
+		}
+		// nothing beneath this path can match
+		switch {
+		case info == nil:
+			return nil
+		case isZip(filePath) && !info.IsDir():
+			return SkipZip
+		case info.IsDir() && !isZipEntry(info):
+			return filepath.SkipDir
+		}
+		return nil
+	}, opts...)
+}
+
+// hasMeta reports whether elem holds any of the special characters of path.Match.
+func hasMeta(elem string) bool {
+	return strings.ContainsAny(elem, `*?[\`)
+}
+
+// splitPath splits filePath into its slash separated elements.
+func splitPath(filePath string) []string {
+	return strings.Split(path.Clean(filepath.ToSlash(filePath)), "/")
+}
+
+// isZipEntry reports whether info describes an entry inside a zip file.  Directories inside zip
+// files are not walked as a tree, so SkipDir cannot be used to skip them.
+func isZipEntry(info os.FileInfo) bool {
+	_, ok := info.(ZipFileInfo)
+	return ok
+}
+
+// globMatch reports whether the elements of name match the elements of pattern, where an
+// element of "**" matches any number of elements.  With prefix set, it reports whether name
+// matches the beginning of pattern, so that paths beneath name could match all of it.
+func globMatch(pattern, name []string, prefix bool) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			if globMatch(pattern[1:], name, prefix) {
+				return true
+			}
+			if len(name) == 0 {
+				return false
+			}
+			name = name[1:]
+			continue
+		}
+		if len(name) == 0 {
+			return prefix
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
@@ -0,0 +1,80 @@
+package zipwalk
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// jsonRecord is a line written by WalkToJSON.
+type jsonRecord struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	CRC32   uint32    `json:"crc32"`
+	Content *string   `json:"content_base64,omitempty"`
+}
+
+// WithoutContent leaves the content of files out of the lines written by WalkToJSON.
+func WithoutContent() Option {
+	return func(o *options) {
+		o.withoutContent = true
+	}
+}
+
+// WalkToJSON walks the file tree rooted at root like Walk and writes a line of JSON to dst for
+// every file, including those inside zip files, e.g.,
+// {"path":"a.zip/a.txt","size":2,"mod_time":"2021-01-02T15:04:05Z","crc32":3904355907,"content_base64":"aGk="}.
+// The content of zip files is left out, as it is written in the lines of their entries, and
+// WithoutContent leaves it out for every file.  Zip files on the filesystem are not read for
+// their CRC-32, which is 0 in their lines, and other files are only read into memory when their
+// content is written.  Writes to dst are serialised, so dst need not be
+// safe for concurrent use.
+func WalkToJSON(root string, dst io.Writer, opts ...Option) error {
+	o := newOptions(opts)
+	enc := json.NewEncoder(dst)
+	var mu sync.Mutex
+	return o.run(func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rec := jsonRecord{Path: path, Size: info.Size(), ModTime: info.ModTime()}
+		// the CRC-32 of zip entries is taken from their header, other files are read for it
+		zfi, fromHeader := info.(ZipFileInfo)
+		if fromHeader = fromHeader && zfi.ZipHeader() != nil; fromHeader {
+			rec.CRC32 = zfi.ZipHeader().CRC32
+		}
+		withContent := !o.withoutContent && !isZip(path)
+		switch {
+		case reader == nil:
+		case withContent:
+			content, err := ioutil.ReadAll(reader)
+			if err != nil {
+				return fmt.Errorf("Error reading file - %s - %w", path, err)
+			}
+			rec.CRC32 = crc32.ChecksumIEEE(content)
+			encoded := base64.StdEncoding.EncodeToString(content)
+			rec.Content = &encoded
+		case !fromHeader && !isZip(path):
+			h := crc32.NewIEEE()
+			if _, err := io.Copy(h, reader); err != nil {
+				return fmt.Errorf("Error reading file - %s - %w", path, err)
+			}
+			rec.CRC32 = h.Sum32()
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		return enc.Encode(rec)
+	}, func(walkFn WalkFunc) error {
+		return walk(root, walkFn, o)
+	})
+}
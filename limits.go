@@ -0,0 +1,163 @@
+package zipwalk
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync/atomic"
+)
+
+// ErrEntryTooLarge is passed to walkFn for zip entries larger than the WithMaxFileSize limit,
+// and returned from reads that go past it.
+var ErrEntryTooLarge = fmt.Errorf("zipwalk: zip entry exceeds the maximum file size")
+
+// ErrTotalBytesExceeded is returned once more than the WithMaxTotalBytes limit has been
+// decompressed from zip files.
+var ErrTotalBytesExceeded = fmt.Errorf("zipwalk: walk exceeds the maximum total bytes")
+
+// ErrMaxDepthExceeded is passed to walkFn for zip files nested deeper than the WithMaxDepth
+// limit, which are not descended into.
+var ErrMaxDepthExceeded = fmt.Errorf("zipwalk: zip file exceeds the maximum nesting depth")
+
+// ErrInsecurePath is passed to walkFn for zip entries whose names are absolute or climb out of
+// the zip file with "..", when WithZipSlipProtection is used.
+var ErrInsecurePath = fmt.Errorf("zipwalk: insecure zip entry path")
+
+// Limits used by SafeWalk.
+const (
+	SafeMaxDepth      = 10
+	SafeMaxFileSize   = 512 << 20
+	SafeMaxTotalBytes = 10 << 30
+)
+
+// SafeWalk is Walk with limits that protect against malicious archives such as zip bombs:
+//   - zip files nested more than SafeMaxDepth (10) levels deep are not descended into, see
+//     ErrMaxDepthExceeded
+//   - zip entries larger than SafeMaxFileSize (512 MB) are not read, see ErrEntryTooLarge
+//   - the walk stops after SafeMaxTotalBytes (10 GB) have been decompressed, see
+//     ErrTotalBytesExceeded
+//   - zip entries with absolute or ".." names are not read, see ErrInsecurePath
+//   - symbolic links are not followed
+//
+// Use Walk with WithMaxDepth, WithMaxFileSize, WithMaxTotalBytes and WithZipSlipProtection to
+// choose other limits.
+func SafeWalk(root string, walkFn WalkFunc) error {
+	return Walk(root, walkFn,
+		WithMaxDepth(SafeMaxDepth),
+		WithMaxFileSize(SafeMaxFileSize),
+		WithMaxTotalBytes(SafeMaxTotalBytes),
+		WithZipSlipProtection(),
+	)
+}
+
+// DetectZipBomb reports whether the entries of the zip file at path, which may itself be inside
+// other zip files, decompress to more than maxRatio times their compressed size, e.g., 100.0
+// for a hundredfold amplification.  Only the sizes recorded in the central directory are
+// summed and nothing is decompressed, so an archive that lies about its sizes is caught by
+// WithMaxFileSize and WithMaxTotalBytes during the walk instead.
+func DetectZipBomb(path string, maxRatio float64) (bool, error) {
+	zr, _, closer, err := openZip(path)
+	if err != nil {
+		return false, err
+	}
+	defer closer.Close()
+	// summed as float64, as the recorded sizes of a crafted archive can overflow a uint64
+	var compressed, uncompressed float64
+	for _, f := range zr.File {
+		compressed += float64(f.CompressedSize64)
+		uncompressed += float64(f.UncompressedSize64)
+	}
+	if compressed == 0 {
+		return uncompressed > 0, nil
+	}
+	return uncompressed/compressed > maxRatio, nil
+}
+
+// WithMaxDepth stops Walk from descending into zip files nested more than depth levels deep;
+// a depth of 1 only descends into the zip files found on the filesystem.  walkFn is called with
+// ErrMaxDepthExceeded for zip files beyond the limit instead.  A depth of 0 means no limit.
+func WithMaxDepth(depth int) Option {
+	return func(o *options) {
+		o.maxDepth = depth
+	}
+}
+
+// WithMaxFileSize stops Walk from reading zip entries that decompress to more than size bytes.
+// walkFn is called with ErrEntryTooLarge for such entries instead.
+func WithMaxFileSize(size int64) Option {
+	return func(o *options) {
+		o.maxFileSize = size
+	}
+}
+
+// WithMaxTotalBytes stops Walk once more than size bytes have been decompressed from zip files,
+// returning ErrTotalBytesExceeded.
+func WithMaxTotalBytes(size int64) Option {
+	return func(o *options) {
+		o.maxTotalBytes = size
+	}
+}
+
+// WithEntryValidator calls fn with the header of every zip entry before the entry is opened.
+// When fn returns an error, walkFn is called with that error instead and the entry is not
+// decompressed, which lets scanners reject entries by their names or sizes alone.
+func WithEntryValidator(fn func(path string, header *zip.FileHeader) error) Option {
+	return func(o *options) {
+		o.entryValidator = fn
+	}
+}
+
+// WithZipSlipProtection makes Walk call walkFn with ErrInsecurePath instead of reading zip
+// entries whose names could escape a directory they are extracted to.
+func WithZipSlipProtection() Option {
+	return func(o *options) {
+		o.zipSlip = true
+	}
+}
+
+func (o *options) totalBytesExceeded() bool {
+	return o.maxTotalBytes > 0 && atomic.LoadInt64(&o.totalBytes) > o.maxTotalBytes
+}
+
+// limitReader enforces the WithMaxFileSize and WithMaxTotalBytes limits on the content of a zip
+// entry, whose header may understate its size.
+func (o *options) limitReader(r io.Reader) io.Reader {
+	if o.maxFileSize <= 0 && o.maxTotalBytes <= 0 {
+		return r
+	}
+	return &limitedReader{r: r, o: o}
+}
+
+type limitedReader struct {
+	r    io.Reader
+	o    *options
+	read int64
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	n, err := lr.r.Read(p)
+	lr.read += int64(n)
+	if lr.o.maxFileSize > 0 && lr.read > lr.o.maxFileSize {
+		return n, ErrEntryTooLarge
+	}
+	if lr.o.maxTotalBytes > 0 && atomic.AddInt64(&lr.o.totalBytes, int64(n)) > lr.o.maxTotalBytes {
+		return n, ErrTotalBytesExceeded
+	}
+	return n, err
+}
+
+// isLocalName reports whether the zip entry name stays within the directory it is extracted to,
+// whichever separator the zip tool used.
+func isLocalName(name string) bool {
+	if name == "" || path.IsAbs(name) || strings.HasPrefix(name, `\`) || (len(name) > 1 && name[1] == ':') {
+		return false
+	}
+	for _, part := range strings.FieldsFunc(name, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if part == ".." {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,190 @@
+package zipwalk
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ListDirs returns the sorted paths of the directories inside the zip file at zipPath, which may
+// itself be inside other zip files.  Both explicit directory entries and the directories implied
+// by the names of other entries are listed.  Nested zip files are not descended into.  Only the
+// central directory of the zip file is read.
+func ListDirs(zipPath string) ([]string, error) {
+	zr, _, closer, err := openZip(zipPath)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+	root := filepath.ToSlash(filepath.Clean(zipPath))
+	dirs := map[string]bool{}
+	for _, f := range zr.File {
+		name := strings.TrimPrefix(path.Clean("/"+f.Name), "/")
+		if name == "" {
+			continue
+		}
+		if f.FileInfo().IsDir() {
+			dirs[root+"/"+name] = true
+		}
+		for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+			dirs[root+"/"+dir] = true
+		}
+	}
+	list := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		list = append(list, dir)
+	}
+	sort.Strings(list)
+	return list, nil
+}
+
+// StatAll returns the os.FileInfo of every entry of the zip file at zipPath, which may itself
+// be inside other zip files, descending into nested zip files depth first.  Every entry is
+// described by a ZipFileInfo.
+func StatAll(zipPath string) ([]os.FileInfo, error) {
+	zr, info, closer, err := openZip(zipPath)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+	var infos []os.FileInfo
+	err = walkZipEntries(newOptions(nil), 1, zipPath, info, zr, func(entryPath string, info os.FileInfo, reader io.Reader, err error) error {
+		if err != nil {
+			return err
+		}
+		infos = append(infos, info)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return infos, nil
+}
+
+// WalkDir returns the direct children of the directory dir inside the zip file at zipPath, which
+// may itself be inside other zip files, sorted by name like os.ReadDir.  An empty dir lists the
+// top of the zip file.  Directories that are only implied by the names of deeper entries are
+// listed as well.  Only the central directory of the zip file is read.
+func WalkDir(zipPath, dir string) ([]os.FileInfo, error) {
+	zr, info, closer, err := openZip(zipPath)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+	prefix := strings.Trim(path.Clean("/"+filepath.ToSlash(dir)), "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	children := map[string]os.FileInfo{}
+	found := prefix == ""
+	for _, f := range zr.File {
+		name := strings.TrimPrefix(path.Clean("/"+f.Name), "/")
+		if !strings.HasPrefix(name+"/", prefix) {
+			continue
+		}
+		found = true
+		rest := strings.TrimPrefix(name, prefix)
+		if rest == "" || name+"/" == prefix {
+			continue
+		}
+		if i := strings.Index(rest, "/"); i != -1 {
+			if _, ok := children[rest[:i]]; !ok {
+				children[rest[:i]] = impliedDirInfo{name: rest[:i], modTime: info.ModTime()}
+			}
+			continue
+		}
+		children[rest] = NewZipFileInfo(info.ModTime(), f.FileInfo())
+	}
+	if !found {
+		return nil, fmt.Errorf("%w - %s in %s", os.ErrNotExist, dir, zipPath)
+	}
+	infos := make([]os.FileInfo, 0, len(children))
+	for _, child := range children {
+		infos = append(infos, child)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name() < infos[j].Name()
+	})
+	return infos, nil
+}
+
+// FindZips returns the paths of all zip files under root, including the zip files nested inside
+// them at any depth, e.g., a.zip and a.zip/b.zip, in the format Walk reports them in.  Only the
+// nested zip files are read, and the other entries of the zip files are passed over without
+// being decompressed.  Files that are named like zip files but are not zip files are left out.
+func FindZips(root string) ([]string, error) {
+	o := newOptions(nil)
+	var zips []string
+	err := filepath.Walk(root, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !isZip(filePath) {
+			return nil
+		}
+		zr, _, closer, err := openRealZip(filePath)
+		if err != nil {
+			if errors.Is(err, zip.ErrFormat) || errors.Is(err, errNoDirectoryEnd) {
+				return nil
+			}
+			return fmt.Errorf("error opening zip file - %s - %w", filePath, err)
+		}
+		defer closer.Close()
+		zips = append(zips, filePath)
+		return findNestedZips(o, filePath, zr, &zips)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return zips, nil
+}
+
+// findNestedZips appends the paths of the zip files inside zr, the zip file at filePath, to zips.
+func findNestedZips(o *options, filePath string, zr *zip.Reader, zips *[]string) error {
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || !isZip(f.Name) {
+			continue
+		}
+		entryPath := joinEntry(o, filePath, f.Name)
+		rdr, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("Error opening file %s - %w", entryPath, err)
+		}
+		buf, err := ioutil.ReadAll(rdr)
+		rdr.Close()
+		if err != nil {
+			return fmt.Errorf("Error reading file - %s - %w", entryPath, err)
+		}
+		nested, err := newZipReader(bytes.NewReader(buf), int64(len(buf)))
+		if err != nil {
+			continue
+		}
+		*zips = append(*zips, entryPath)
+		if err := findNestedZips(o, entryPath, nested, zips); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// impliedDirInfo describes a directory inside a zip file that has no entry of its own.
+type impliedDirInfo struct {
+	name    string
+	modTime time.Time
+}
+
+func (di impliedDirInfo) Name() string       { return di.name }
+func (di impliedDirInfo) Size() int64        { return 0 }
+func (di impliedDirInfo) Mode() os.FileMode  { return os.ModeDir | 0755 }
+func (di impliedDirInfo) ModTime() time.Time { return di.modTime }
+func (di impliedDirInfo) IsDir() bool        { return true }
+func (di impliedDirInfo) Sys() interface{}   { return nil }
@@ -0,0 +1,423 @@
+package zipwalk
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// methodLZMA is the zip compression method of LZMA-compressed entries.
+const methodLZMA = 14
+
+// errLZMACorrupt is returned from reads of LZMA-compressed data that cannot be decoded.
+var errLZMACorrupt = fmt.Errorf("zipwalk: corrupt LZMA data")
+
+// lzmaReader decompresses an LZMA-compressed zip entry, which starts with a 4 byte version and
+// properties size, followed by the LZMA properties and the compressed stream itself.  The
+// decoder follows the reference decoder of the LZMA SDK.
+type lzmaReader struct {
+	r    io.ByteReader
+	size int64 // left to decompress, or -1 if the stream ends with an end marker instead
+	err  error
+	rc   lzmaRangeDecoder
+
+	lc, lp, pb uint
+	dictSize   uint32
+	window     []byte // grows up to dictSize, then wraps around at pos
+	pos        int
+	full       bool
+	totalPos   uint64
+
+	state                  uint32
+	rep0, rep1, rep2, rep3 uint32
+	remLen                 int // of the match being copied
+
+	literal     []uint16
+	isMatch     [12 << 4]uint16
+	isRep       [12]uint16
+	isRepG0     [12]uint16
+	isRepG1     [12]uint16
+	isRepG2     [12]uint16
+	isRep0Long  [12 << 4]uint16
+	posSlot     [4][1 << 6]uint16
+	posDecoders [1 + 128 - 14]uint16
+	align       [1 << 4]uint16
+	lenDec      lzmaLenDecoder
+	repLenDec   lzmaLenDecoder
+}
+
+// newLZMAReader returns a reader of the size bytes decompressed from the LZMA-compressed data
+// in r.  A size of -1 reads up to the end marker of the stream.  Nothing is read from r until
+// the first call to Read.
+func newLZMAReader(r io.Reader, size int64) *lzmaReader {
+	br, ok := r.(io.ByteReader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return &lzmaReader{r: br, size: size}
+}
+
+func (z *lzmaReader) init() error {
+	header := make([]byte, 4)
+	for i := range header {
+		b, err := z.r.ReadByte()
+		if err != nil {
+			return io.ErrUnexpectedEOF
+		}
+		header[i] = b
+	}
+	props := make([]byte, binary.LittleEndian.Uint16(header[2:]))
+	if len(props) < 5 {
+		return errLZMACorrupt
+	}
+	for i := range props {
+		b, err := z.r.ReadByte()
+		if err != nil {
+			return io.ErrUnexpectedEOF
+		}
+		props[i] = b
+	}
+	d := uint(props[0])
+	if d >= 9*5*5 {
+		return errLZMACorrupt
+	}
+	z.lc, z.lp, z.pb = d%9, d/9%5, d/45
+	z.dictSize = binary.LittleEndian.Uint32(props[1:])
+	if z.dictSize < 1<<12 {
+		z.dictSize = 1 << 12
+	}
+	z.literal = make([]uint16, 0x300<<(z.lc+z.lp))
+	for _, probs := range [][]uint16{z.literal, z.isMatch[:], z.isRep[:], z.isRepG0[:], z.isRepG1[:], z.isRepG2[:], z.isRep0Long[:], z.posDecoders[:], z.align[:]} {
+		initProbs(probs)
+	}
+	for i := range z.posSlot {
+		initProbs(z.posSlot[i][:])
+	}
+	z.lenDec.init()
+	z.repLenDec.init()
+	z.rc.r = z.r
+	return z.rc.init()
+}
+
+func (z *lzmaReader) Read(p []byte) (int, error) {
+	if z.literal == nil && z.err == nil {
+		z.err = z.init()
+	}
+	n := 0
+	for n < len(p) && z.err == nil {
+		if z.size == 0 {
+			z.err = io.EOF
+			break
+		}
+		if z.remLen > 0 {
+			b := z.getByte(z.rep0 + 1)
+			z.putByte(b)
+			z.remLen--
+			p[n] = b
+			n++
+			if z.size > 0 {
+				z.size--
+			}
+			continue
+		}
+		b, ok, err := z.decode()
+		if z.rc.err != nil {
+			err = z.rc.err
+		}
+		if err != nil {
+			z.err = err
+			break
+		}
+		if ok {
+			p[n] = b
+			n++
+			if z.size > 0 {
+				z.size--
+			}
+		}
+	}
+	if n > 0 && z.err == io.EOF {
+		return n, nil
+	}
+	return n, z.err
+}
+
+// decode decodes the next literal or match.  A literal is returned, while a match is left in
+// remLen to be copied.
+func (z *lzmaReader) decode() (byte, bool, error) {
+	rc := &z.rc
+	posState := uint32(z.totalPos) & (1<<z.pb - 1)
+	s2 := z.state<<4 + posState
+	if rc.decodeBit(&z.isMatch[s2]) == 0 {
+		b := z.decodeLiteral()
+		switch {
+		case z.state < 4:
+			z.state = 0
+		case z.state < 10:
+			z.state -= 3
+		default:
+			z.state -= 6
+		}
+		z.putByte(b)
+		return b, true, nil
+	}
+	var length uint32
+	if rc.decodeBit(&z.isRep[z.state]) != 0 {
+		if len(z.window) == 0 {
+			return 0, false, errLZMACorrupt
+		}
+		if rc.decodeBit(&z.isRepG0[z.state]) == 0 {
+			if rc.decodeBit(&z.isRep0Long[s2]) == 0 {
+				z.state = nextState(z.state, 9, 11)
+				b := z.getByte(z.rep0 + 1)
+				z.putByte(b)
+				return b, true, nil
+			}
+		} else {
+			var dist uint32
+			if rc.decodeBit(&z.isRepG1[z.state]) == 0 {
+				dist = z.rep1
+			} else {
+				if rc.decodeBit(&z.isRepG2[z.state]) == 0 {
+					dist = z.rep2
+				} else {
+					dist = z.rep3
+					z.rep3 = z.rep2
+				}
+				z.rep2 = z.rep1
+			}
+			z.rep1 = z.rep0
+			z.rep0 = dist
+		}
+		length = z.repLenDec.decode(rc, posState)
+		z.state = nextState(z.state, 8, 11)
+	} else {
+		z.rep3, z.rep2, z.rep1 = z.rep2, z.rep1, z.rep0
+		length = z.lenDec.decode(rc, posState)
+		z.state = nextState(z.state, 7, 10)
+		z.rep0 = z.decodeDistance(length)
+		if z.rep0 == 0xffffffff {
+			// end marker
+			return 0, false, io.EOF
+		}
+		if z.rep0 >= z.dictSize || !z.full && int(z.rep0) >= len(z.window) {
+			return 0, false, errLZMACorrupt
+		}
+	}
+	z.remLen = int(length) + 2
+	return 0, false, nil
+}
+
+// nextState returns the state after a match or repeated match, which depends on whether the
+// previous symbol was a literal.
+func nextState(state, afterLiteral, afterMatch uint32) uint32 {
+	if state < 7 {
+		return afterLiteral
+	}
+	return afterMatch
+}
+
+func (z *lzmaReader) decodeLiteral() byte {
+	rc := &z.rc
+	prevByte := uint32(0)
+	if len(z.window) > 0 {
+		prevByte = uint32(z.getByte(1))
+	}
+	litState := (uint32(z.totalPos)&(1<<z.lp-1))<<z.lc + prevByte>>(8-z.lc)
+	probs := z.literal[0x300*litState:]
+	symbol := uint32(1)
+	if z.state >= 7 {
+		matchByte := uint32(z.getByte(z.rep0 + 1))
+		for symbol < 0x100 {
+			matchBit := matchByte >> 7 & 1
+			matchByte <<= 1
+			bit := rc.decodeBit(&probs[(1+matchBit)<<8+symbol])
+			symbol = symbol<<1 | bit
+			if matchBit != bit {
+				break
+			}
+		}
+	}
+	for symbol < 0x100 {
+		symbol = symbol<<1 | rc.decodeBit(&probs[symbol])
+	}
+	return byte(symbol - 0x100)
+}
+
+func (z *lzmaReader) decodeDistance(length uint32) uint32 {
+	rc := &z.rc
+	lenState := length
+	if lenState > 3 {
+		lenState = 3
+	}
+	posSlot := bitTreeDecode(rc, z.posSlot[lenState][:], 6)
+	if posSlot < 4 {
+		return posSlot
+	}
+	numDirectBits := int(posSlot>>1) - 1
+	dist := (2 | posSlot&1) << numDirectBits
+	if posSlot < 14 {
+		return dist + bitTreeReverseDecode(rc, z.posDecoders[dist-posSlot:], numDirectBits)
+	}
+	dist += rc.decodeDirectBits(numDirectBits-4) << 4
+	return dist + bitTreeReverseDecode(rc, z.align[:], 4)
+}
+
+func (z *lzmaReader) putByte(b byte) {
+	z.totalPos++
+	if !z.full {
+		z.window = append(z.window, b)
+		if uint32(len(z.window)) == z.dictSize {
+			z.full = true
+			z.pos = 0
+		}
+		return
+	}
+	z.window[z.pos] = b
+	z.pos++
+	if z.pos == len(z.window) {
+		z.pos = 0
+	}
+}
+
+// getByte returns the byte written dist bytes ago.
+func (z *lzmaReader) getByte(dist uint32) byte {
+	if !z.full {
+		return z.window[len(z.window)-int(dist)]
+	}
+	i := z.pos - int(dist)
+	if i < 0 {
+		i += len(z.window)
+	}
+	return z.window[i]
+}
+
+func (z *lzmaReader) Close() error {
+	return nil
+}
+
+// lzmaRangeDecoder decodes the bits of an LZMA stream.
+type lzmaRangeDecoder struct {
+	r         io.ByteReader
+	rng, code uint32
+	err       error
+}
+
+func (rc *lzmaRangeDecoder) init() error {
+	if rc.readByte() != 0 {
+		return errLZMACorrupt
+	}
+	for i := 0; i < 4; i++ {
+		rc.code = rc.code<<8 | uint32(rc.readByte())
+	}
+	rc.rng = 0xffffffff
+	if rc.err != nil {
+		return rc.err
+	}
+	if rc.code == rc.rng {
+		return errLZMACorrupt
+	}
+	return nil
+}
+
+func (rc *lzmaRangeDecoder) readByte() byte {
+	b, err := rc.r.ReadByte()
+	if err != nil && rc.err == nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		rc.err = err
+	}
+	return b
+}
+
+func (rc *lzmaRangeDecoder) normalize() {
+	if rc.rng < 1<<24 {
+		rc.rng <<= 8
+		rc.code = rc.code<<8 | uint32(rc.readByte())
+	}
+}
+
+func (rc *lzmaRangeDecoder) decodeBit(prob *uint16) uint32 {
+	bound := (rc.rng >> 11) * uint32(*prob)
+	var bit uint32
+	if rc.code < bound {
+		*prob += (1<<11 - *prob) >> 5
+		rc.rng = bound
+	} else {
+		*prob -= *prob >> 5
+		rc.code -= bound
+		rc.rng -= bound
+		bit = 1
+	}
+	rc.normalize()
+	return bit
+}
+
+func (rc *lzmaRangeDecoder) decodeDirectBits(n int) uint32 {
+	var res uint32
+	for ; n > 0; n-- {
+		rc.rng >>= 1
+		rc.code -= rc.rng
+		t := 0 - rc.code>>31
+		rc.code += rc.rng & t
+		if rc.code == rc.rng && rc.err == nil {
+			rc.err = errLZMACorrupt
+		}
+		rc.normalize()
+		res = res<<1 + t + 1
+	}
+	return res
+}
+
+func initProbs(probs []uint16) {
+	for i := range probs {
+		probs[i] = 1 << 10
+	}
+}
+
+func bitTreeDecode(rc *lzmaRangeDecoder, probs []uint16, numBits int) uint32 {
+	m := uint32(1)
+	for i := 0; i < numBits; i++ {
+		m = m<<1 + rc.decodeBit(&probs[m])
+	}
+	return m - 1<<numBits
+}
+
+func bitTreeReverseDecode(rc *lzmaRangeDecoder, probs []uint16, numBits int) uint32 {
+	m := uint32(1)
+	var symbol uint32
+	for i := 0; i < numBits; i++ {
+		bit := rc.decodeBit(&probs[m])
+		m = m<<1 + bit
+		symbol |= bit << i
+	}
+	return symbol
+}
+
+// lzmaLenDecoder decodes the lengths of matches.
+type lzmaLenDecoder struct {
+	choice, choice2 uint16
+	low, mid        [16][1 << 3]uint16
+	high            [1 << 8]uint16
+}
+
+func (ld *lzmaLenDecoder) init() {
+	ld.choice, ld.choice2 = 1<<10, 1<<10
+	for i := range ld.low {
+		initProbs(ld.low[i][:])
+		initProbs(ld.mid[i][:])
+	}
+	initProbs(ld.high[:])
+}
+
+func (ld *lzmaLenDecoder) decode(rc *lzmaRangeDecoder, posState uint32) uint32 {
+	if rc.decodeBit(&ld.choice) == 0 {
+		return bitTreeDecode(rc, ld.low[posState][:], 3)
+	}
+	if rc.decodeBit(&ld.choice2) == 0 {
+		return 8 + bitTreeDecode(rc, ld.mid[posState][:], 3)
+	}
+	return 16 + bitTreeDecode(rc, ld.high[:], 8)
+}
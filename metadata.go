@@ -0,0 +1,40 @@
+package zipwalk
+
+import (
+	"os"
+	"time"
+)
+
+// EntryMetadata is a summary of the header of a single zip entry.
+type EntryMetadata struct {
+	Name              string
+	Size              uint64 // uncompressed size
+	CompressedSize    uint64
+	CompressionMethod uint16
+	CRC32             uint32
+	ModTime           time.Time
+	Mode              os.FileMode
+	Comment           string
+	ExtraFields       []byte // raw extra field data, left for the caller to parse
+}
+
+// GetMetadata returns the header information of the zip entry at path,
+// e.g., file1.zip/file2.zip/a.txt
+func GetMetadata(path string) (*EntryMetadata, error) {
+	f, _, closer, err := findEntry(path)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+	return &EntryMetadata{
+		Name:              f.Name,
+		Size:              f.UncompressedSize64,
+		CompressedSize:    f.CompressedSize64,
+		CompressionMethod: f.Method,
+		CRC32:             f.CRC32,
+		ModTime:           f.Modified,
+		Mode:              f.Mode(),
+		Comment:           f.Comment,
+		ExtraFields:       append([]byte(nil), f.Extra...),
+	}, nil
+}
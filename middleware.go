@@ -0,0 +1,69 @@
+package zipwalk
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// Middleware wraps a WalkFunc with behaviour of its own, such as logging or filtering, and
+// returns the wrapped WalkFunc.
+type Middleware func(WalkFunc) WalkFunc
+
+// Chain wraps walkFn with mw, so that the first Middleware sees every call first and the last
+// one calls walkFn itself.
+func Chain(walkFn WalkFunc, mw ...Middleware) WalkFunc {
+	for i := len(mw) - 1; i >= 0; i-- {
+		walkFn = mw[i](walkFn)
+	}
+	return walkFn
+}
+
+// LoggingMiddleware logs every path handed to the WalkFunc it wraps to logger, at the debug
+// level, and the errors the walk reports or the WalkFunc returns, at the error level.  SkipDir
+// and SkipZip are not errors and are not logged as such.
+func LoggingMiddleware(logger *slog.Logger) Middleware {
+	return func(next WalkFunc) WalkFunc {
+		return func(path string, info os.FileInfo, reader io.Reader, err error) error {
+			if err != nil {
+				logger.Error("zipwalk: walk error", "path", path, "error", err)
+			} else {
+				logger.Debug("zipwalk: walk", "path", path, "size", info.Size(), "dir", info.IsDir())
+			}
+			ret := next(path, info, reader, err)
+			if ret != nil && ret != SkipDir && ret != SkipZip {
+				logger.Error("zipwalk: walkFn error", "path", path, "error", ret)
+			}
+			return ret
+		}
+	}
+}
+
+// TimingMiddleware calls onComplete with the time the WalkFunc it wraps took for each path.
+// For zip files this is the time spent on the zip file itself, not on its entries.
+func TimingMiddleware(onComplete func(path string, d time.Duration)) Middleware {
+	return func(next WalkFunc) WalkFunc {
+		return func(path string, info os.FileInfo, reader io.Reader, err error) error {
+			start := time.Now()
+			defer func() {
+				onComplete(path, time.Since(start))
+			}()
+			return next(path, info, reader, err)
+		}
+	}
+}
+
+// MinSizeMiddleware leaves out files smaller than minBytes, including those inside zip files.
+// Directories, zip files and errors are always passed on, so that the walk still descends
+// into them.
+func MinSizeMiddleware(minBytes int64) Middleware {
+	return func(next WalkFunc) WalkFunc {
+		return func(path string, info os.FileInfo, reader io.Reader, err error) error {
+			if err == nil && !info.IsDir() && !isZip(path) && info.Size() < minBytes {
+				return nil
+			}
+			return next(path, info, reader, err)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package zipwalk
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ConflictAction tells Mirror how to resolve two entries that map to the same destination path.
+type ConflictAction int
+
+// The ways a name conflict can be resolved.
+const (
+	Overwrite ConflictAction = iota // the incoming entry replaces the existing one
+	Skip                            // the incoming entry is left out
+	Rename                          // the incoming entry is written under a name with a counter appended
+	Abort                           // Mirror stops and returns ErrNameConflict
+)
+
+// ErrNameConflict is returned by Mirror when a name conflict is resolved with Abort, or when a
+// file entry and a directory map to the same destination path, which cannot be resolved.
+var ErrNameConflict = fmt.Errorf("zipwalk: name conflict")
+
+// WithNameConflict makes Mirror call fn when the entry named incoming maps to the same
+// destination path as the entry named existing, which was processed before it.  Without this
+// option the incoming entry overwrites the existing one.
+func WithNameConflict(fn func(existing, incoming string) ConflictAction) Option {
+	return func(o *options) {
+		o.nameConflict = fn
+	}
+}
+
+// WithDryRun makes Mirror call report with every destination path it would write instead of
+// writing anything.
+func WithDryRun(report func(path string)) Option {
+	return func(o *options) {
+		o.dryRun = report
+	}
+}
+
+// Mirror extracts the zip file at src, which may itself be inside other zip files, into dstDir,
+// preserving the directory structure, modification times and file modes of the entries,
+// directories included.  Files in dstDir that already have the size and modification time of
+// their entry are left alone, so repeated calls only write what changed.  Nested zip files are
+// extracted as regular files.  Entries whose names would escape dstDir are rejected, and a file
+// entry that maps to the same path as a directory, e.g., a and a/b.txt, returns ErrNameConflict.
+func Mirror(src string, dstDir string, opts ...Option) error {
+	o := newOptions(opts)
+	zr, _, closer, err := openZip(src)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+	claimed := map[string]string{} // entry names by destination path
+	dirs := map[string]bool{}      // destination paths that are directories, named or implied
+	var madeDirs []*zip.File
+	for _, f := range zr.File {
+		if !filepath.IsLocal(f.Name) {
+			return fmt.Errorf("zip entry %s in %s would be extracted outside %s", f.Name, src, dstDir)
+		}
+		dst := filepath.Join(dstDir, filepath.FromSlash(o.shortenName(f.Name)))
+		if existing := kindConflict(dstDir, o.shortenName(f.Name), f.Name, f.FileInfo().IsDir(), claimed, dirs); existing != "" {
+			return fmt.Errorf("%w - %s and %s in %s are a file and a directory", ErrNameConflict, existing, f.Name, src)
+		}
+		if existing, ok := claimed[dst]; ok && o.nameConflict != nil && !f.FileInfo().IsDir() {
+			switch o.nameConflict(existing, f.Name) {
+			case Skip:
+				continue
+			case Rename:
+				dst = renameConflict(dst, claimed)
+			case Abort:
+				return fmt.Errorf("%w - %s and %s in %s", ErrNameConflict, existing, f.Name, src)
+			}
+		}
+		claimed[dst] = f.Name
+		if f.FileInfo().IsDir() {
+			if o.dryRun != nil {
+				continue
+			}
+			// created writable, so that entries can be extracted into it, and given its mode last
+			if err := os.MkdirAll(dst, 0755); err != nil {
+				return err
+			}
+			madeDirs = append(madeDirs, f)
+			continue
+		}
+		if existing, err := os.Stat(dst); err == nil && existing.Mode().IsRegular() &&
+			existing.Size() == int64(f.UncompressedSize64) && existing.ModTime().Equal(f.Modified) {
+			continue
+		}
+		if o.dryRun != nil {
+			o.dryRun(dst)
+			continue
+		}
+		if err := mirrorFile(f.Open, dst, f.Mode().Perm(), f.Modified); err != nil {
+			return fmt.Errorf("Error extracting %s from %s - %w", f.Name, src, err)
+		}
+	}
+	// once their entries are written, as writing them changes the modification time
+	for i := len(madeDirs) - 1; i >= 0; i-- {
+		f := madeDirs[i]
+		dst := filepath.Join(dstDir, filepath.FromSlash(o.shortenName(f.Name)))
+		perm := f.Mode().Perm()
+		if perm == 0 {
+			perm = 0755
+		}
+		if err := os.Chmod(dst, perm); err != nil {
+			return err
+		}
+		if err := os.Chtimes(dst, f.Modified, f.Modified); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mirrorFile writes the content returned by open to dst and sets its mode and modification time.
+func mirrorFile(open func() (io.ReadCloser, error), dst string, perm os.FileMode, modTime time.Time) error {
+	if perm == 0 {
+		perm = 0644
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	rdr, err := open()
+	if err != nil {
+		return err
+	}
+	defer rdr.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, rdr); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(dst, perm); err != nil {
+		return err
+	}
+	return os.Chtimes(dst, modTime, modTime)
+}
+
+// renameConflict returns the first of "name (1).ext", "name (2).ext", ... that has not been
+// claimed yet.
+func renameConflict(dst string, claimed map[string]string) string {
+	ext := filepath.Ext(dst)
+	base := strings.TrimSuffix(dst, ext)
+	for n := 1; ; n++ {
+		renamed := fmt.Sprintf("%s (%d)%s", base, n, ext)
+		if _, ok := claimed[renamed]; !ok {
+			return renamed
+		}
+	}
+}
+
+// kindConflict returns the name of the entry already claiming the destination of rel, a path
+// relative to dstDir, or one of its parent directories as a file, or the destination as a
+// directory when isDir is not set.  Otherwise it records the parent directories, and the
+// destination itself if isDir is set, as directories claimed by the entry called name.
+func kindConflict(dstDir, rel, name string, isDir bool, claimed map[string]string, dirs map[string]bool) string {
+	dst := filepath.Join(dstDir, filepath.FromSlash(rel))
+	if existing, ok := claimed[dst]; ok && dirs[dst] != isDir {
+		return existing
+	}
+	var parents []string
+	for p := path.Dir(path.Clean(rel)); p != "." && p != "/"; p = path.Dir(p) {
+		p := filepath.Join(dstDir, filepath.FromSlash(p))
+		if existing, ok := claimed[p]; ok && !dirs[p] {
+			return existing
+		}
+		parents = append(parents, p)
+	}
+	for _, p := range parents {
+		if _, ok := claimed[p]; !ok {
+			claimed[p] = name
+		}
+		dirs[p] = true
+	}
+	if isDir {
+		dirs[dst] = true
+	}
+	return ""
+}
@@ -0,0 +1,486 @@
+package zipwalk
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"context"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Option configures the optional behaviour of Walk and the functions built on top of it.
+type Option func(*options)
+
+type options struct {
+	ctx     context.Context
+	timeout time.Duration
+
+	serial    bool
+	reverse   bool
+	canonical bool
+	resume    *WalkToken
+	save      *WalkToken
+
+	entryComment func(path, comment string)
+	shallow      bool
+
+	objectStorage  S3Client
+	span           func(path string) SpanReader
+	tee            func(path string, compressedData []byte)
+	teeWriter      func(path string) io.Writer
+	pathResolver   PathResolver
+	onZipOpen      func(path string, entryCount int)
+	onZipClose     func(path string, duration time.Duration)
+	reportEmpty    func(path string)
+	reportSymlinks bool
+	reportInvalid  bool
+	skipMacOS      bool
+	skipHidden     bool
+	skipEmpty      bool
+	utc            bool
+	dosEpoch       time.Time // replaces entry times before dosEpoch, if set
+	onZipComplete  func(path string, stats ZipStats)
+	auditLog       *auditLog
+	diffContent    bool
+	cdCache        ZipCDCache
+	concurrentRead int
+	pathHasher     func(path string) string
+	pathMapper     func(path string, info os.FileInfo) string
+	rawPaths       bool // set by Walk2, which maps paths itself
+	mimeTypes      map[string]bool
+	readBufferSize int
+	withoutContent bool
+	maxNameLength  int
+	extensions     map[string]bool
+	excludes       []string
+	compressLevel  int
+	boundaryEvents bool
+	retryAttempts  int
+	retryBackoff   time.Duration
+	openFile       func(name string) (*os.File, error)
+	bytePool       BytePool
+	dedup          *contentDedup
+	snapshot       *Snapshot
+	rateLimit      *rateLimiter
+	watchInterval  time.Duration
+
+	dryRun       func(path string)
+	nameConflict func(existing, incoming string) ConflictAction
+
+	maxDepth      int
+	maxFileSize   int64
+	maxTotalBytes int64
+	totalBytes    int64 // decompressed so far, updated atomically
+	zipSlip       bool
+	password      func(path string) string
+
+	entryValidator func(path string, header *zip.FileHeader) error
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{ctx: context.Background(), pathResolver: ForwardSlashResolver{}, openFile: os.Open, compressLevel: flate.DefaultCompression}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// run calls fn with walkFn wrapped by wrap, within the deadline set by WithTimeout.  Errors
+// that fn returns once the context of the walk is done are replaced by the context's error.
+func (o *options) run(walkFn WalkFunc, fn func(walkFn WalkFunc) error) error {
+	if o.timeout > 0 {
+		var cancel context.CancelFunc
+		o.ctx, cancel = context.WithTimeout(o.ctx, o.timeout)
+		defer cancel()
+	}
+	err := fn(o.wrap(walkFn))
+	if err != nil && o.ctx.Err() != nil {
+		return o.ctx.Err()
+	}
+	return err
+}
+
+// wrap applies the options that act on every path handed to walkFn.
+func (o *options) wrap(walkFn WalkFunc) WalkFunc {
+	return func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		if err := o.ctx.Err(); err != nil {
+			return err
+		}
+		if isBoundaryEvent(info) {
+			// not a file of its own, so none of the filters apply
+			return walkFn(o.walkFnPath(path, info), info, nil, nil)
+		}
+		if o.auditLog != nil {
+			o.auditLog.record(path, info)
+		}
+		if o.extensions != nil && err == nil && info != nil && !info.IsDir() && !o.extensions[strings.ToLower(filepath.Ext(path))] {
+			return nil
+		}
+		if o.mimeTypes != nil && err == nil && reader != nil && !info.IsDir() {
+			var ok bool
+			if reader, ok, err = o.mimeAllowed(reader); err == nil && !ok {
+				return nil
+			}
+		}
+		if o.dedup != nil && err == nil && reader != nil && !info.IsDir() && !isZip(path) {
+			var dup bool
+			if reader, dup, err = o.dedup.check(path, info, reader); err == nil && dup {
+				return nil
+			}
+		}
+		return walkFn(o.walkFnPath(path, info), info, reader, err)
+	}
+}
+
+// WithContext stops the walk once ctx is done, returning ctx.Err().  Files that walkFn is
+// already reading are not interrupted.
+func WithContext(ctx context.Context) Option {
+	return func(o *options) {
+		o.ctx = ctx
+	}
+}
+
+// WithTimeout stops the walk once d has passed since it started, returning
+// context.DeadlineExceeded.  It can be combined with WithContext, in which case whichever ends
+// first stops the walk.
+func WithTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.timeout = d
+	}
+}
+
+// WithPathMapper hands walkFn the path that fn maps each path to, such as one without version
+// numbers, in place of the path itself.  Only walkFn sees the mapped paths: which files are
+// walked and how nested zip files are found does not change.  Paths are mapped before
+// WithPathHasher derives keys from them.  Walk2 hands over both, see Entry.OriginalPath.
+func WithPathMapper(fn func(path string, info os.FileInfo) string) Option {
+	return func(o *options) {
+		o.pathMapper = fn
+	}
+}
+
+// walkFnPath returns the path handed to walkFn for path.  Walk2 maps paths itself, as it hands
+// over the original path as well.
+func (o *options) walkFnPath(path string, info os.FileInfo) string {
+	if o.rawPaths {
+		return path
+	}
+	return o.mapPath(path, info)
+}
+
+// mapPath maps path as set by WithPathMapper and WithPathHasher.
+func (o *options) mapPath(path string, info os.FileInfo) string {
+	if o.pathMapper != nil {
+		path = o.pathMapper(path, info)
+	}
+	if o.pathHasher != nil {
+		path = o.pathHasher(path)
+	}
+	return path
+}
+
+// WithPathHasher hands walkFn the key that fn derives from each path, such as a hash of it,
+// in place of the path itself, for callers that store entries under keys that paths do not
+// fit.  Walk2 hands over both, see Entry.OriginalPath.
+func WithPathHasher(fn func(path string) string) Option {
+	return func(o *options) {
+		o.pathHasher = fn
+	}
+}
+
+// WithEntryComment calls fn with the comment of every zip entry that has one.  fn is called
+// before walkFn is called for the same entry.
+func WithEntryComment(fn func(path, comment string)) Option {
+	return func(o *options) {
+		o.entryComment = fn
+	}
+}
+
+// WithShallow stops Walk from descending into zip files that are stored inside other zip
+// files.  Such nested zip files are handed to walkFn as regular files.
+func WithShallow() Option {
+	return func(o *options) {
+		o.shallow = true
+	}
+}
+
+// WithTee calls fn with the compressed bytes of every zip entry, as stored in the zip file,
+// before the entry is decompressed for walkFn.  The compressed bytes of an entry are held in
+// memory for fn; WithTeeWriter streams them instead.
+func WithTee(fn func(path string, compressedData []byte)) Option {
+	return func(o *options) {
+		o.tee = fn
+	}
+}
+
+// WithTeeWriter copies the compressed bytes of every zip entry, as stored in the zip file, to
+// the writer fn returns for its path, before the entry is decompressed for walkFn.  Entries for
+// which fn returns nil are not copied.  An error writing to the writer is returned by Walk.
+func WithTeeWriter(fn func(path string) io.Writer) Option {
+	return func(o *options) {
+		o.teeWriter = fn
+	}
+}
+
+// WithZipBoundaryEvents calls walkFn twice more for every zip file that Walk descends into,
+// with the path of the zip file, a nil reader and a nil error: once before its entries with a
+// ZipFileInfo whose IsEntering is set, and once after them with one whose IsLeaving is set.
+// Callers that build trees can push and pop on them.  Returning SkipZip when entering skips the
+// entries and the leaving call.  The entries of a zip file are walked one at a time, but the
+// zip files on the filesystem are walked concurrently, so the calls for different ones
+// interleave unless the walk is serial, as that of WalkCompat is.
+func WithZipBoundaryEvents() Option {
+	return func(o *options) {
+		o.boundaryEvents = true
+	}
+}
+
+// WithOnZipOpen calls fn each time Walk has opened a zip file and read its central directory,
+// with the number of entries in the zip file.
+func WithOnZipOpen(fn func(path string, entryCount int)) Option {
+	return func(o *options) {
+		o.onZipOpen = fn
+	}
+}
+
+// WithOnZipClose calls fn each time Walk is done with a zip file, with the time spent since it
+// started to open the zip file, including all of its entries and nested zip files.
+func WithOnZipClose(fn func(path string, duration time.Duration)) Option {
+	return func(o *options) {
+		o.onZipClose = fn
+	}
+}
+
+// ZipStats summarises the entries of a zip file that Walk visited.
+type ZipStats struct {
+	Entries int   // entries of the zip file itself, not counting those of nested zip files
+	Bytes   int64 // uncompressed size of those entries
+	Empty   int   // empty files left out by WithSkipEmpty, not counted in Entries
+}
+
+// WithOnZipComplete calls fn each time Walk has visited all entries of a zip file, at any
+// nesting level, with a summary of them.  It is not called for zip files whose walk stopped
+// with an error.
+func WithOnZipComplete(fn func(path string, stats ZipStats)) Option {
+	return func(o *options) {
+		o.onZipComplete = fn
+	}
+}
+
+// WithReportEmptyZips calls fn for every zip file that Walk opens and finds to have no entries,
+// which often points at a broken build.
+func WithReportEmptyZips(fn func(path string)) Option {
+	return func(o *options) {
+		o.reportEmpty = fn
+	}
+}
+
+// WithReportInvalidZips hands zip files that have an end of central directory record but cannot
+// be read otherwise to walkFn with zip.ErrFormat, rather than logging them and carrying on.
+// Files without such a record are not zip files at all and are still only logged.
+func WithReportInvalidZips() Option {
+	return func(o *options) {
+		o.reportInvalid = true
+	}
+}
+
+// WithReportSymlinks hands the symbolic links stored in zip files to walkFn as links rather than
+// as regular files holding the link target.  walkFn is called without a reader for them, and
+// the target can be read from the os.FileInfo with SymlinkTarget.
+func WithReportSymlinks() Option {
+	return func(o *options) {
+		o.reportSymlinks = true
+	}
+}
+
+// SymlinkTarget returns the target of a symbolic link stored in a zip file, given the
+// os.FileInfo handed to walkFn for it by a walk using WithReportSymlinks.
+func SymlinkTarget(info os.FileInfo) (string, bool) {
+	zfi, ok := info.(ZipFileInfo)
+	if !ok || zfi.Mode()&os.ModeSymlink == 0 || zfi.LinkTarget == "" {
+		return "", false
+	}
+	return zfi.LinkTarget, true
+}
+
+// WithNormalizeTimesToUTC reports the modification times of zip entries in UTC rather than in
+// the local time zone, so that they compare and print the same on every system.
+func WithNormalizeTimesToUTC() Option {
+	return func(o *options) {
+		o.utc = true
+	}
+}
+
+// dosEpoch is the earliest time that the MS-DOS timestamps of zip entries can hold.
+var dosEpoch = time.Date(1980, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// WithNormalizeDOSEpochTimestamps replaces the modification times of zip entries that are
+// earlier than 1980-01-01 with sentinel, or with 1980-01-01 UTC if sentinel is the zero time.
+// This covers both the times returned by ModTime and those recorded in the headers, see
+// ZipFileInfo.ZipHeader.  Such times cannot be stored in the MS-DOS format of zip files,
+// and come from zeroed timestamps, which read as 1979-11-30, or from extended timestamps of
+// tools that wrote the Unix epoch.
+func WithNormalizeDOSEpochTimestamps(sentinel time.Time) Option {
+	return func(o *options) {
+		if sentinel.IsZero() {
+			sentinel = dosEpoch
+		}
+		o.dosEpoch = sentinel
+	}
+}
+
+// WithSkipMacOSArtifacts leaves out the files that macOS adds to the archives it creates:
+// __MACOSX directories, .DS_Store files and AppleDouble files whose names start with "._".
+// Neither walkFn nor any other callback is called for them, or for anything inside them.
+func WithSkipMacOSArtifacts() Option {
+	return func(o *options) {
+		o.skipMacOS = true
+	}
+}
+
+// WithSkipHiddenFiles leaves out files and directories whose names start with ".", both on the
+// filesystem and inside zip files, along with anything inside them.
+func WithSkipHiddenFiles() Option {
+	return func(o *options) {
+		o.skipHidden = true
+	}
+}
+
+// WithSkipEmpty leaves out the empty files stored in zip files and tar archives, without
+// opening them.  Directories are still handed to walkFn.
+func WithSkipEmpty() Option {
+	return func(o *options) {
+		o.skipEmpty = true
+	}
+}
+
+// WithExtensions only hands walkFn the files, including those inside zip files, whose
+// extension is one of exts, e.g., ".txt", compared without regard to case.  Directories are
+// still handed to walkFn, and zip files that are left out are still descended into.
+func WithExtensions(exts ...string) Option {
+	return func(o *options) {
+		o.extensions = map[string]bool{}
+		for _, ext := range exts {
+			o.extensions[strings.ToLower(ext)] = true
+		}
+	}
+}
+
+// WithExcludePattern leaves out files and directories whose names match pattern, using the
+// syntax of path.Match, e.g., "*.tmp", both on the filesystem and inside zip files, along with
+// anything inside them.  It can be given more than once to exclude several patterns.
+func WithExcludePattern(pattern string) Option {
+	return func(o *options) {
+		o.excludes = append(o.excludes, pattern)
+	}
+}
+
+// skipName reports whether the file or directory name is left out of the walk.
+func (o *options) skipName(name string) bool {
+	if o.skipHidden && strings.HasPrefix(name, ".") {
+		return true
+	}
+	for _, pattern := range o.excludes {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return o.skipMacOS && (name == "__MACOSX" || name == ".DS_Store" || strings.HasPrefix(name, "._"))
+}
+
+// skipPath reports whether any element of the slash separated name of an archive entry is left
+// out of the walk.
+func (o *options) skipPath(name string) bool {
+	if !o.skipHidden && !o.skipMacOS && len(o.excludes) == 0 {
+		return false
+	}
+	for _, elem := range strings.Split(name, "/") {
+		if elem != "." && elem != ".." && o.skipName(elem) {
+			return true
+		}
+	}
+	return false
+}
+
+// WithReadBufferSize reads the content of zip entries through buffers of n bytes, both for the
+// readers handed to walkFn and when reading nested zip files into memory.  Small buffers save
+// memory when walking many small entries, large ones speed up reading few large entries.
+// Without it, entries are read unbuffered, as the decompressors return them.
+func WithReadBufferSize(n int) Option {
+	return func(o *options) {
+		o.readBufferSize = n
+	}
+}
+
+// bufferReader buffers r as set by WithReadBufferSize.
+func (o *options) bufferReader(r io.Reader) io.Reader {
+	if o.readBufferSize <= 0 {
+		return r
+	}
+	return bufio.NewReaderSize(r, o.readBufferSize)
+}
+
+// readAll reads r to its end, into a buffer from the pool set by WithBytePool, or copying
+// through a buffer of the size set by WithReadBufferSize.  The result is handed to releaseBytes
+// once it is no longer used.
+func (o *options) readAll(r io.Reader) ([]byte, error) {
+	if o.bytePool != nil {
+		buf := o.bytePool.Get()[:0]
+		for {
+			if len(buf) == cap(buf) {
+				buf = append(buf, 0)[:len(buf)]
+			}
+			n, err := r.Read(buf[len(buf):cap(buf)])
+			buf = buf[:len(buf)+n]
+			if err == io.EOF {
+				return buf, nil
+			}
+			if err != nil {
+				return buf, err
+			}
+		}
+	}
+	if o.readBufferSize <= 0 {
+		return ioutil.ReadAll(r)
+	}
+	var buf bytes.Buffer
+	_, err := io.CopyBuffer(&buf, struct{ io.Reader }{r}, make([]byte, o.readBufferSize))
+	return buf.Bytes(), err
+}
+
+// WithMIMEFilter only hands walkFn the files, including those inside zip files, whose content
+// is one of types as detected by http.DetectContentType from its first 512 bytes, e.g.,
+// "text/plain" or "text/xml; charset=utf-8".  Types given without parameters match whatever
+// parameters are detected.  walkFn still reads the content from its start.  Zip files that are
+// left out are still descended into.
+func WithMIMEFilter(types ...string) Option {
+	return func(o *options) {
+		o.mimeTypes = map[string]bool{}
+		for _, t := range types {
+			o.mimeTypes[t] = true
+		}
+	}
+}
+
+// mimeAllowed detects the type of the content of reader, returning whether WithMIMEFilter
+// allows it and a reader of the whole content.
+func (o *options) mimeAllowed(reader io.Reader) (io.Reader, bool, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(reader, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, false, err
+	}
+	head = head[:n]
+	detected := http.DetectContentType(head)
+	mediaType, _, _ := mime.ParseMediaType(detected)
+	return io.MultiReader(bytes.NewReader(head), reader), o.mimeTypes[detected] || o.mimeTypes[mediaType], nil
+}
@@ -0,0 +1,107 @@
+package zipwalk
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// WithReverseOrder makes Walk visit everything in reverse lexical order, both the entries
+// inside zip files and the real files.  The real files are then walked serially.
+func WithReverseOrder() Option {
+	return func(o *options) {
+		o.reverse = true
+		o.serial = true
+	}
+}
+
+// WithCanonicalOrder makes Walk visit the entries of every zip file sorted by name, compared
+// without regard to case, rather than in the order they are stored in, so that zip files with
+// the same entries are walked the same way however they were written.  Names that differ in
+// case only are visited in lexical order.  Combined with WithReverseOrder, the order is
+// reversed.  WalkDiff always reports paths in this order.
+func WithCanonicalOrder() Option {
+	return func(o *options) {
+		o.canonical = true
+	}
+}
+
+// canonicalLess reports whether a comes before b in the order of WithCanonicalOrder.
+func canonicalLess(a, b string) bool {
+	if foldedA, foldedB := strings.ToLower(a), strings.ToLower(b); foldedA != foldedB {
+		return foldedA < foldedB
+	}
+	return a < b
+}
+
+// zipFiles returns the entries of zr in the order they are to be walked.
+func zipFiles(o *options, zr *zip.Reader) []*zip.File {
+	if !o.reverse && !o.canonical {
+		return zr.File
+	}
+	files := append([]*zip.File(nil), zr.File...)
+	sort.SliceStable(files, func(i, j int) bool {
+		a, b := files[i].Name, files[j].Name
+		if o.reverse {
+			a, b = b, a
+		}
+		if o.canonical {
+			return canonicalLess(a, b)
+		}
+		return a < b
+	})
+	return files
+}
+
+// walkReverse is filepath.Walk with the entries of every directory visited in reverse lexical
+// order.
+func walkReverse(root string, fn filepath.WalkFunc) error {
+	info, err := os.Lstat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = walkReverseDir(root, info, fn)
+	}
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+func walkReverseDir(path string, info os.FileInfo, fn filepath.WalkFunc) error {
+	if !info.IsDir() {
+		return fn(path, info, nil)
+	}
+	names, err := readDirNames(path)
+	err1 := fn(path, info, err)
+	if err != nil || err1 != nil {
+		return err1
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+	for _, name := range names {
+		filename := filepath.Join(path, name)
+		fileInfo, err := os.Lstat(filename)
+		if err != nil {
+			if err := fn(filename, fileInfo, err); err != nil && err != filepath.SkipDir {
+				return err
+			}
+			continue
+		}
+		err = walkReverseDir(filename, fileInfo, fn)
+		if err != nil && (!fileInfo.IsDir() || err != filepath.SkipDir) {
+			return err
+		}
+	}
+	return nil
+}
+
+func readDirNames(dirname string) ([]string, error) {
+	f, err := os.Open(dirname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Readdirnames(-1)
+}
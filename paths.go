@@ -0,0 +1,132 @@
+package zipwalk
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// PathResolver constructs the paths of entries inside zip files from the path of the zip file
+// and the name of the entry.
+type PathResolver interface {
+	Join(parts ...string) string
+}
+
+// ForwardSlashResolver joins paths with forward slashes on every platform.  It is the default
+// PathResolver.
+type ForwardSlashResolver struct{}
+
+// Join joins parts with forward slashes and cleans the result.
+func (ForwardSlashResolver) Join(parts ...string) string {
+	slashed := make([]string, len(parts))
+	for i := range parts {
+		slashed[i] = filepath.ToSlash(parts[i])
+	}
+	return path.Join(slashed...)
+}
+
+// NativeResolver joins paths with the separator of the operating system, e.g., backslashes on
+// Windows.
+type NativeResolver struct{}
+
+// Join joins parts with filepath.Join.
+func (NativeResolver) Join(parts ...string) string {
+	return filepath.Join(parts...)
+}
+
+// defaultMaxNameLength is the longest file name most filesystems accept, in bytes.
+const defaultMaxNameLength = 255
+
+// WithMaxNameLength shortens the elements of zip entry names that are longer than n bytes, or
+// than 255 bytes if n is 0 or less, in the paths handed to walkFn and the files written by
+// Mirror.  Zip entry names can be up to 65535 bytes long, which filesystems do not accept as
+// the name of a file.  Long elements keep their start and their extension, with a hash of the
+// whole element in between, e.g., "aaaa…aaaa~1a2b3c4d.txt", so distinct names stay distinct.
+// Shortened paths cannot be handed back to Stat and the other functions that find entries by
+// path.  Without this option, paths hold the entry names as they are, and elements longer than
+// the filesystem allows only fail once they are used to create or open real files.
+func WithMaxNameLength(n int) Option {
+	return func(o *options) {
+		if n <= 0 {
+			n = defaultMaxNameLength
+		}
+		o.maxNameLength = n
+	}
+}
+
+// shortenName shortens the slash separated elements of name that are longer than set by
+// WithMaxNameLength.
+func (o *options) shortenName(name string) string {
+	if o.maxNameLength <= 0 || len(name) <= o.maxNameLength {
+		return name
+	}
+	elems := strings.Split(name, "/")
+	for i, elem := range elems {
+		if len(elem) <= o.maxNameLength {
+			continue
+		}
+		sum := sha1.Sum([]byte(elem))
+		suffix := "~" + hex.EncodeToString(sum[:4])
+		if ext := path.Ext(elem); len(ext) <= 16 {
+			suffix += ext
+		}
+		keep := o.maxNameLength - len(suffix)
+		if keep < 0 {
+			keep = 0
+		}
+		// cut at the start of a rune so that the name stays valid UTF-8
+		for keep > 0 && !utf8.RuneStart(elem[keep]) {
+			keep--
+		}
+		elems[i] = elem[:keep] + suffix
+	}
+	return strings.Join(elems, "/")
+}
+
+// WithPathResolver makes Walk construct the paths of entries inside zip files with r.
+func WithPathResolver(r PathResolver) Option {
+	return func(o *options) {
+		o.pathResolver = r
+	}
+}
+
+// MatchesPath reports whether candidate is root or lies beneath it, following both directories
+// and zip files, e.g., a.zip/b/c.txt lies beneath a.zip/b but a.zip/bar does not.  Both paths
+// are cleaned and may use either separator.
+func MatchesPath(candidate, root string) bool {
+	candidate = path.Clean(filepath.ToSlash(candidate))
+	root = path.Clean(filepath.ToSlash(root))
+	switch {
+	case candidate == root:
+		return true
+	case root == ".":
+		return !path.IsAbs(candidate) && candidate != ".." && !strings.HasPrefix(candidate, "../")
+	case root == "/":
+		return path.IsAbs(candidate)
+	}
+	return strings.HasPrefix(candidate, root+"/")
+}
+
+// WalkParents returns the zip files that path goes through, outermost first, followed by path
+// itself, e.g., [a.zip a.zip/b.zip a.zip/b.zip/c.txt] for a.zip/b.zip/c.txt.  Each of them can
+// be opened on its own.  A path that does not go through a zip file is returned alone.
+func WalkParents(path string) []string {
+	zipPath, inner := splitZipPath(path)
+	if zipPath == "" {
+		return []string{path}
+	}
+	parents := []string{zipPath}
+	for inner != "" {
+		end := zipBoundary(inner)
+		if end == -1 {
+			parents = append(parents, zipPath+"/"+inner)
+			break
+		}
+		zipPath, inner = zipPath+"/"+inner[:end], inner[end+1:]
+		parents = append(parents, zipPath)
+	}
+	return parents
+}
@@ -0,0 +1,51 @@
+package zipwalk
+
+import "sync"
+
+// BytePool supplies the buffers that zip files stored inside other zip files and tar archives,
+// and the entries read ahead by WithConcurrentRead, are read into.  Get returns a buffer whose
+// capacity is used, whatever its length, and Put takes back a buffer that is no longer used,
+// which may be a larger one than Get returned.  Implementations must be safe for concurrent use.
+type BytePool interface {
+	Get() []byte
+	Put([]byte)
+}
+
+// WithBytePool reads into buffers from pool rather than allocating a new one for each nested
+// zip file and each entry read ahead, to reduce the work of the garbage collector when walking
+// many of them.  Buffers go back to pool once Walk is done with them: after the entries of a
+// nested zip file have been walked, or after walkFn returns for an entry read ahead.  Readers
+// handed to walkFn must not be used after walkFn returns.
+func WithBytePool(pool BytePool) Option {
+	return func(o *options) {
+		o.bytePool = pool
+	}
+}
+
+// releaseBytes hands buf, returned by readAll, back to the pool set by WithBytePool.
+func (o *options) releaseBytes(buf []byte) {
+	if o.bytePool != nil && buf != nil {
+		o.bytePool.Put(buf[:0])
+	}
+}
+
+// NewBytePool returns a BytePool backed by a sync.Pool, handing out new buffers with a capacity
+// of size bytes when it has none to reuse.
+func NewBytePool(size int) BytePool {
+	return &syncBytePool{pool: sync.Pool{New: func() interface{} {
+		buf := make([]byte, 0, size)
+		return &buf
+	}}}
+}
+
+type syncBytePool struct {
+	pool sync.Pool
+}
+
+func (p *syncBytePool) Get() []byte {
+	return *p.pool.Get().(*[]byte)
+}
+
+func (p *syncBytePool) Put(buf []byte) {
+	p.pool.Put(&buf)
+}
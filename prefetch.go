@@ -0,0 +1,130 @@
+package zipwalk
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// WithConcurrentRead decompresses up to n entries of each zip file ahead of walkFn, on n
+// goroutines, while walkFn is still called serially and in the order of the entries.  The real
+// files are walked serially too, in lexical order like WalkCompat, so this uses several cores
+// for decompression without walkFn having to be safe for concurrent use.
+// The entries read ahead are held in memory, so at most n entries of each zip file are held at
+// a time.  Entries are checked against the limits, WithEntryValidator and WithSnapshot before
+// they are read ahead, so the validator may be called before walkFn is done with the entries in
+// front of them.  Values of n below 2 read entries as walkFn asks for them, as usual.
+func WithConcurrentRead(n int) Option {
+	return func(o *options) {
+		o.concurrentRead = n
+		if n > 1 {
+			o.serial = true
+		}
+	}
+}
+
+// prefetchedEntry is the decompressed content of a zip entry read ahead of walkFn, along with
+// the error that opening or reading it ended with.  checkErr is the error that checkEntry
+// rejected it with, in which case it was not read.
+type prefetchedEntry struct {
+	data     []byte
+	checkErr error
+	openErr  error
+	readErr  error
+}
+
+// reader replays the reading of the entry, ending with the error that reading it ended with.
+func (pe *prefetchedEntry) reader() (io.ReadCloser, error) {
+	if pe.openErr != nil {
+		return nil, pe.openErr
+	}
+	if pe.readErr != nil {
+		return ioutil.NopCloser(io.MultiReader(bytes.NewReader(pe.data), errReader{pe.readErr})), nil
+	}
+	return ioutil.NopCloser(bytes.NewReader(pe.data)), nil
+}
+
+type errReader struct {
+	err error
+}
+
+func (er errReader) Read(p []byte) (int, error) {
+	return 0, er.err
+}
+
+// prefetcher reads the entries of a zip file ahead of walkFn.  Every entry gets a result, which
+// is nil for the entries that are left to be read as usual.
+type prefetcher struct {
+	results []chan *prefetchedEntry
+	sem     chan struct{} // held from the start of reading an entry until walkFn is done with it
+	done    chan struct{}
+	wg      sync.WaitGroup
+	o       *options
+}
+
+func newPrefetcher(o *options, depth int, filePath string, files []*zip.File) *prefetcher {
+	p := &prefetcher{
+		results: make([]chan *prefetchedEntry, len(files)),
+		sem:     make(chan struct{}, o.concurrentRead),
+		done:    make(chan struct{}),
+		o:       o,
+	}
+	for i := range p.results {
+		p.results[i] = make(chan *prefetchedEntry, 1)
+	}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		for i, f := range files {
+			if f.FileInfo().IsDir() || o.skipPath(f.Name) {
+				p.results[i] <- nil
+				continue
+			}
+			select {
+			case p.sem <- struct{}{}:
+			case <-p.done:
+				return
+			}
+			entryPath := joinEntry(o, filePath, f.Name)
+			if err := checkEntry(o, depth, entryPath, f); err != nil {
+				p.results[i] <- &prefetchedEntry{checkErr: err}
+				continue
+			}
+			p.wg.Add(1)
+			go func(i int, f *zip.File) {
+				defer p.wg.Done()
+				pe := &prefetchedEntry{}
+				rdr, err := openEntry(o, entryPath, f)
+				if err != nil {
+					pe.openErr = err
+				} else {
+					pe.data, pe.readErr = o.readAll(o.throttle(o.limitReader(rdr)))
+					rdr.Close()
+				}
+				p.results[i] <- pe
+			}(i, f)
+		}
+	}()
+	return p
+}
+
+// next waits for the result of the entry at index i, which must be taken in order.
+func (p *prefetcher) next(i int) *prefetchedEntry {
+	return <-p.results[i]
+}
+
+// release lets the next entry be read ahead once walkFn is done with pe.
+func (p *prefetcher) release(pe *prefetchedEntry) {
+	if pe != nil {
+		p.o.releaseBytes(pe.data)
+		<-p.sem
+	}
+}
+
+// stop stops reading ahead and waits for the entries being read to finish.
+func (p *prefetcher) stop() {
+	close(p.done)
+	p.wg.Wait()
+}
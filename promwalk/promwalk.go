@@ -0,0 +1,108 @@
+// Package promwalk walks file trees with zipwalk while recording Prometheus metrics about the
+// walk.  It is kept apart from zipwalk so that only programs that use it depend on the
+// Prometheus client.
+package promwalk
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mzimmerman/zipwalk"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// walkMetrics holds the collectors that WalkWithMetrics records to.
+type walkMetrics struct {
+	files    *prometheus.CounterVec
+	zips     prometheus.Counter
+	sizes    prometheus.Histogram
+	duration prometheus.Histogram
+}
+
+// newWalkMetrics registers the collectors of WalkWithMetrics with reg, or returns those already
+// registered by an earlier walk, so that every walk adds to the same metrics.
+func newWalkMetrics(reg prometheus.Registerer) (*walkMetrics, error) {
+	files, err := register(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "zipwalk_files_visited_total",
+		Help: "Files visited by walks, including those inside zip files, by walk root and file extension.",
+	}, []string{"root", "extension"}))
+	if err != nil {
+		return nil, err
+	}
+	zips, err := register(reg, prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "zipwalk_zips_descended_total",
+		Help: "Zip files opened and walked into, including nested zip files.",
+	}))
+	if err != nil {
+		return nil, err
+	}
+	sizes, err := register(reg, prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "zipwalk_entry_size_bytes",
+		Help:    "Uncompressed size of the files visited by walks.",
+		Buckets: prometheus.ExponentialBuckets(64, 4, 10),
+	}))
+	if err != nil {
+		return nil, err
+	}
+	duration, err := register(reg, prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "zipwalk_walk_duration_seconds",
+		Help:    "Time taken by whole walks.",
+		Buckets: prometheus.DefBuckets,
+	}))
+	if err != nil {
+		return nil, err
+	}
+	return &walkMetrics{
+		files:    files.(*prometheus.CounterVec),
+		zips:     zips.(prometheus.Counter),
+		sizes:    sizes.(prometheus.Histogram),
+		duration: duration.(prometheus.Histogram),
+	}, nil
+}
+
+// register registers c with reg, returning the collector registered before it if there is one.
+func register(reg prometheus.Registerer, c prometheus.Collector) (prometheus.Collector, error) {
+	if err := reg.Register(c); err != nil {
+		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			return are.ExistingCollector, nil
+		}
+		return nil, err
+	}
+	return c, nil
+}
+
+// WalkWithMetrics is zipwalk.Walk, recording the following metrics with reg, or with
+// prometheus.DefaultRegisterer if reg is nil:
+//
+//   - zipwalk_files_visited_total, a counter of the files handed to walkFn, labelled by root
+//     and by lower case file extension
+//   - zipwalk_zips_descended_total, a counter of the zip files walked into
+//   - zipwalk_entry_size_bytes, a histogram of the sizes of the files handed to walkFn
+//   - zipwalk_walk_duration_seconds, a histogram of the duration of the walks
+//
+// The metrics are registered by the first walk and shared by the walks after it.
+func WalkWithMetrics(root string, walkFn zipwalk.WalkFunc, reg prometheus.Registerer) error {
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+	m, err := newWalkMetrics(reg)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	defer func() {
+		m.duration.Observe(time.Since(start).Seconds())
+	}()
+	return zipwalk.Walk(root, func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		if err == nil && !info.IsDir() {
+			m.files.WithLabelValues(root, strings.ToLower(filepath.Ext(path))).Inc()
+			m.sizes.Observe(float64(info.Size()))
+		}
+		return walkFn(path, info, reader, err)
+	}, zipwalk.WithOnZipOpen(func(path string, entryCount int) {
+		m.zips.Inc()
+	}))
+}
@@ -0,0 +1,41 @@
+package promwalk
+
+import (
+	"io"
+	"os"
+	"testing"
+
+	"github.com/mzimmerman/zipwalk/testutil"
+	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestWalkWithMetrics(t *testing.T) {
+	nested := testutil.ZipBytes(t, map[string][]byte{"c.txt": []byte("c")})
+	zipPath := testutil.NewTempZip(t, map[string][]byte{"a.txt": []byte("a"), "b.TXT": []byte("bb"), "n.zip": nested})
+	reg := prometheus.NewRegistry()
+	for i := 0; i < 2; i++ {
+		err := WalkWithMetrics(zipPath, func(path string, info os.FileInfo, reader io.Reader, err error) error {
+			return err
+		}, reg)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	m, err := newWalkMetrics(reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := promtestutil.ToFloat64(m.files.WithLabelValues(zipPath, ".txt")); got != 6 {
+		t.Errorf("Expected 6 .txt files visited over two walks, got %v", got)
+	}
+	if got := promtestutil.ToFloat64(m.files.WithLabelValues(zipPath, ".zip")); got != 4 {
+		t.Errorf("Expected 4 .zip files visited over two walks, got %v", got)
+	}
+	if got := promtestutil.ToFloat64(m.zips); got != 4 {
+		t.Errorf("Expected 4 zip files descended over two walks, got %v", got)
+	}
+	if got := promtestutil.CollectAndCount(m.duration); got != 1 {
+		t.Errorf("Expected the walk duration to be recorded, got %d metrics", got)
+	}
+}
@@ -0,0 +1,76 @@
+package zipwalk
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// WithRateLimit limits the walk to decompressing bytesPerSec bytes of zip entries per second,
+// counted over the whole walk, so that a batch job does not take the I/O bandwidth and CPU of
+// other work.  The limit applies to the decompressed content that walkFn reads and that nested
+// zip files are read into, with bursts of up to a second's worth of bytes.
+func WithRateLimit(bytesPerSec int64) Option {
+	return func(o *options) {
+		o.rateLimit = &rateLimiter{rate: float64(bytesPerSec), tokens: float64(bytesPerSec), last: time.Now()}
+	}
+}
+
+// rateLimiter is a token bucket holding up to a second's worth of bytes.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // bytes per second
+	tokens float64 // may be negative when bytes have been taken ahead of the rate
+	last   time.Time
+}
+
+// wait takes n bytes from the bucket, waiting until the rate allows for them or ctx is done.
+func (rl *rateLimiter) wait(ctx context.Context, n int) error {
+	rl.mu.Lock()
+	now := time.Now()
+	rl.tokens += now.Sub(rl.last).Seconds() * rl.rate
+	if rl.tokens > rl.rate {
+		rl.tokens = rl.rate
+	}
+	rl.last = now
+	rl.tokens -= float64(n)
+	delay := time.Duration(-rl.tokens / rl.rate * float64(time.Second))
+	rl.mu.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// throttle limits reading from r as set by WithRateLimit.
+func (o *options) throttle(r io.Reader) io.Reader {
+	if o.rateLimit == nil || o.rateLimit.rate <= 0 {
+		return r
+	}
+	return &throttledReader{r: r, o: o}
+}
+
+type throttledReader struct {
+	r io.Reader
+	o *options
+}
+
+func (tr *throttledReader) Read(p []byte) (int, error) {
+	// larger reads than the bucket holds would burst past the rate
+	if burst := max(int(tr.o.rateLimit.rate), 1); len(p) > burst {
+		p = p[:burst]
+	}
+	n, err := tr.r.Read(p)
+	if werr := tr.o.rateLimit.wait(tr.o.ctx, n); werr != nil && err == nil {
+		err = werr
+	}
+	return n, err
+}
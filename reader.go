@@ -0,0 +1,130 @@
+package zipwalk
+
+import (
+	"archive/zip"
+	"compress/bzip2"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"time"
+	"unicode/utf8"
+)
+
+// methodBzip2 is the zip compression method of bzip2-compressed entries.
+const methodBzip2 = 12
+
+// unicodePathExtraID is the ID of the Info-ZIP Unicode Path Extra Field.
+const unicodePathExtraID = 0x7075
+
+// newZipReader is zip.NewReader with support for what archive/zip lacks:
+//   - bzip2 and LZMA-compressed entries.  The decompressors are registered on the reader rather
+//     than globally so that programs importing zipwalk can still register their own.  LZMA
+//     entries read through zip.File.Open must end with an end marker, as most tools write them;
+//     Walk also reads those that do not.
+//   - UTF-8 names stored in the Info-ZIP Unicode Path Extra Field by tools that write the
+//     standard name in a legacy code page.  They replace the names of their entries.
+func newZipReader(r io.ReaderAt, size int64) (*zip.Reader, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	zr.RegisterDecompressor(methodBzip2, func(r io.Reader) io.ReadCloser {
+		return ioutil.NopCloser(bzip2.NewReader(r))
+	})
+	zr.RegisterDecompressor(methodLZMA, func(r io.Reader) io.ReadCloser {
+		return newLZMAReader(r, -1)
+	})
+	for _, f := range zr.File {
+		if name, ok := unicodePathName(&f.FileHeader); ok {
+			f.Name = name
+		}
+	}
+	return zr, nil
+}
+
+// unicodePathName returns the name stored in the Unicode Path Extra Field of fh, provided that
+// the field was written for the name fh has.
+func unicodePathName(fh *zip.FileHeader) (string, bool) {
+	for extra := fh.Extra; len(extra) >= 4; {
+		id := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		if len(extra) < 4+size {
+			break
+		}
+		field := extra[4 : 4+size]
+		extra = extra[4+size:]
+		// version 1, the CRC-32 of the standard name, then the UTF-8 name
+		if id != unicodePathExtraID || len(field) < 5 || field[0] != 1 {
+			continue
+		}
+		if binary.LittleEndian.Uint32(field[1:]) != crc32.ChecksumIEEE([]byte(fh.Name)) || !utf8.Valid(field[5:]) {
+			continue
+		}
+		return string(field[5:]), true
+	}
+	return "", false
+}
+
+// ntfsExtraID is the ID of the NTFS Extra Field, which holds the times of an entry with the
+// 100 nanosecond resolution of NTFS.
+const ntfsExtraID = 0x000a
+
+// ntfsEpoch is the time that NTFS times count from.
+var ntfsEpoch = time.Date(1601, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// ntfsModTime returns the modification time stored in the NTFS Extra Field of fh, if it has
+// one.  archive/zip reads the field as well, but lets the whole seconds of any other timestamp
+// field that follows it take its place.
+func ntfsModTime(fh *zip.FileHeader) (time.Time, bool) {
+	off, ok := ntfsModTimeOffset(fh.Extra)
+	if !ok {
+		return time.Time{}, false
+	}
+	ticks := int64(binary.LittleEndian.Uint64(fh.Extra[off:]))
+	return time.Unix(ntfsEpoch.Unix()+ticks/1e7, ticks%1e7*100).UTC(), true
+}
+
+// withNTFSModTime returns a copy of extra, which holds an NTFS Extra Field, with the
+// modification time in that field replaced by t.
+func withNTFSModTime(extra []byte, t time.Time) []byte {
+	off, ok := ntfsModTimeOffset(extra)
+	if !ok {
+		return extra
+	}
+	extra = append([]byte(nil), extra...)
+	ticks := (t.Unix()-ntfsEpoch.Unix())*1e7 + int64(t.Nanosecond()/100)
+	binary.LittleEndian.PutUint64(extra[off:], uint64(ticks))
+	return extra
+}
+
+// ntfsModTimeOffset returns the offset in extra of the modification time held by its NTFS Extra
+// Field, if it has one.
+func ntfsModTimeOffset(extra []byte) (int, bool) {
+	for pos := 0; len(extra)-pos >= 4; {
+		id := binary.LittleEndian.Uint16(extra[pos:])
+		size := int(binary.LittleEndian.Uint16(extra[pos+2:]))
+		if len(extra)-pos < 4+size {
+			break
+		}
+		field, fieldPos := extra[pos+4:pos+4+size], pos+4
+		pos += 4 + size
+		if id != ntfsExtraID || len(field) < 4 {
+			continue
+		}
+		// 4 reserved bytes, then attributes, of which tag 1 holds the modification, access
+		// and creation times
+		for attrPos := 4; len(field)-attrPos >= 4; {
+			tag := binary.LittleEndian.Uint16(field[attrPos:])
+			attrSize := int(binary.LittleEndian.Uint16(field[attrPos+2:]))
+			if len(field)-attrPos < 4+attrSize {
+				break
+			}
+			if tag == 1 && attrSize == 24 {
+				return fieldPos + attrPos + 4, true
+			}
+			attrPos += 4 + attrSize
+		}
+	}
+	return 0, false
+}
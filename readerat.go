@@ -0,0 +1,38 @@
+package zipwalk
+
+import (
+	"io"
+	"sync"
+)
+
+// NewReaderAt returns an io.ReaderAt reading from rs, such as a seekable HTTP body, for WalkAt.
+// ReadAt calls are serialised, as each one seeks rs before reading, and seeking is skipped when
+// rs is already at the offset asked for.  Nothing else may read from or seek rs while the
+// io.ReaderAt is in use.
+func NewReaderAt(rs io.ReadSeeker) io.ReaderAt {
+	return &seekReaderAt{rs: rs, pos: -1}
+}
+
+type seekReaderAt struct {
+	mu  sync.Mutex
+	rs  io.ReadSeeker
+	pos int64 // offset rs is at, or -1 if not known
+}
+
+func (sra *seekReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	sra.mu.Lock()
+	defer sra.mu.Unlock()
+	if off != sra.pos {
+		if _, err := sra.rs.Seek(off, io.SeekStart); err != nil {
+			sra.pos = -1
+			return 0, err
+		}
+		sra.pos = off
+	}
+	n, err := io.ReadFull(sra.rs, p)
+	sra.pos += int64(n)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
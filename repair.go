@@ -0,0 +1,258 @@
+package zipwalk
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+const (
+	fileHeaderSignature     = 0x04034b50
+	dataDescriptorSignature = 0x08074b50
+	fileHeaderLen           = 30
+	zip64ExtraID            = 0x0001
+)
+
+// RepairStats reports what Repair recovered.
+type RepairStats struct {
+	Recovered int // entries written to dst
+	Lost      int // entries found in src whose data is truncated or corrupt
+}
+
+// Repair writes the entries of the zip file at src, whose central directory may be missing or
+// damaged, such as by an interrupted download, to a new zip file at dst.  The entries are found
+// by reading the local file headers in front of their data from the start of src.  Entries whose
+// data is truncated or does not match its checksum are left out and counted as lost.  The
+// compressed data of the other entries is copied as it is.  Entries whose size is only recorded
+// after their data can be recovered when they are stored or deflated, and the scan stops at the
+// first entry whose end cannot be found.
+func Repair(src, dst string) (RepairStats, error) {
+	var stats RepairStats
+	in, err := os.Open(src)
+	if err != nil {
+		return stats, err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return stats, err
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return stats, err
+	}
+	defer out.Close()
+	zw := zip.NewWriter(out)
+	for offset := int64(0); ; {
+		fh, dataStart, next, err := readLocalEntry(in, info.Size(), offset)
+		if err == errNoLocalHeader {
+			break
+		}
+		if err != nil {
+			// the entry is cut short, or the end of its data cannot be found, so neither can
+			// the entries after it
+			stats.Lost++
+			break
+		}
+		offset = next
+		if fh.Flags&0x1 == 0 && !validEntry(fh, io.NewSectionReader(in, dataStart, int64(fh.CompressedSize64))) {
+			stats.Lost++
+			continue
+		}
+		w, err := zw.CreateRaw(fh)
+		if err != nil {
+			return stats, fmt.Errorf("Error creating zip entry %s - %w", fh.Name, err)
+		}
+		if _, err := io.Copy(w, io.NewSectionReader(in, dataStart, int64(fh.CompressedSize64))); err != nil {
+			return stats, fmt.Errorf("Error copying zip entry %s - %w", fh.Name, err)
+		}
+		stats.Recovered++
+	}
+	if err := zw.Close(); err != nil {
+		return stats, err
+	}
+	return stats, out.Close()
+}
+
+// errNoLocalHeader means that no local file header starts at the offset read, which ends the
+// entries of a zip file.
+var errNoLocalHeader = fmt.Errorf("zipwalk: no local file header")
+
+// readLocalEntry reads the local file header at offset of the size bytes of r, returning the
+// header of the entry, the offset of its compressed data and the offset just past it, including
+// any data descriptor.
+func readLocalEntry(r io.ReaderAt, size, offset int64) (*zip.FileHeader, int64, int64, error) {
+	buf := make([]byte, fileHeaderLen)
+	if _, err := r.ReadAt(buf, offset); err != nil || binary.LittleEndian.Uint32(buf) != fileHeaderSignature {
+		return nil, 0, 0, errNoLocalHeader
+	}
+	fh := &zip.FileHeader{
+		ReaderVersion:      binary.LittleEndian.Uint16(buf[4:]),
+		Flags:              binary.LittleEndian.Uint16(buf[6:]),
+		Method:             binary.LittleEndian.Uint16(buf[8:]),
+		ModifiedTime:       binary.LittleEndian.Uint16(buf[10:]),
+		ModifiedDate:       binary.LittleEndian.Uint16(buf[12:]),
+		CRC32:              binary.LittleEndian.Uint32(buf[14:]),
+		CompressedSize64:   uint64(binary.LittleEndian.Uint32(buf[18:])),
+		UncompressedSize64: uint64(binary.LittleEndian.Uint32(buf[22:])),
+	}
+	nameLen, extraLen := int(binary.LittleEndian.Uint16(buf[26:])), int(binary.LittleEndian.Uint16(buf[28:]))
+	buf = make([]byte, nameLen+extraLen)
+	if _, err := r.ReadAt(buf, offset+fileHeaderLen); err != nil {
+		return nil, 0, 0, io.ErrUnexpectedEOF
+	}
+	fh.Name = string(buf[:nameLen])
+	zip64 := fh.CompressedSize64 == 0xffffffff || fh.UncompressedSize64 == 0xffffffff
+	fh.Extra = parseLocalExtra(fh, buf[nameLen:])
+	dataStart := offset + fileHeaderLen + int64(nameLen+extraLen)
+	if fh.Flags&0x8 == 0 {
+		dataEnd := dataStart + int64(fh.CompressedSize64)
+		if dataEnd > size {
+			return nil, 0, 0, io.ErrUnexpectedEOF
+		}
+		return fh, dataStart, dataEnd, nil
+	}
+	// the sizes and checksum follow the data, so the end of the data has to be found first
+	fh.Flags &^= 0x8
+	dataEnd, err := findDataEnd(r, size, dataStart, fh.Method)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	descLen := 12
+	if zip64 {
+		descLen = 20
+	}
+	descStart := dataEnd
+	desc := make([]byte, descLen+4)
+	n, _ := r.ReadAt(desc, descStart)
+	if n >= 4 && binary.LittleEndian.Uint32(desc) == dataDescriptorSignature {
+		desc, n = desc[4:], n-4
+		descStart += 4
+	}
+	if n < descLen {
+		return nil, 0, 0, io.ErrUnexpectedEOF
+	}
+	fh.CRC32 = binary.LittleEndian.Uint32(desc)
+	if zip64 {
+		fh.CompressedSize64, fh.UncompressedSize64 = binary.LittleEndian.Uint64(desc[4:]), binary.LittleEndian.Uint64(desc[12:])
+	} else {
+		fh.CompressedSize64, fh.UncompressedSize64 = uint64(binary.LittleEndian.Uint32(desc[4:])), uint64(binary.LittleEndian.Uint32(desc[8:]))
+	}
+	if dataStart+int64(fh.CompressedSize64) != dataEnd {
+		return nil, 0, 0, zip.ErrFormat
+	}
+	return fh, dataStart, descStart + int64(descLen), nil
+}
+
+// parseLocalExtra takes the sizes of a zip64 entry from the extra field of its local file
+// header and returns the extra field without the zip64 record, which zip.Writer writes itself.
+func parseLocalExtra(fh *zip.FileHeader, extra []byte) []byte {
+	var kept []byte
+	for len(extra) >= 4 {
+		id, size := binary.LittleEndian.Uint16(extra), int(binary.LittleEndian.Uint16(extra[2:]))
+		if 4+size > len(extra) {
+			break
+		}
+		field := extra[4 : 4+size]
+		if id != zip64ExtraID {
+			kept = append(kept, extra[:4+size]...)
+		} else {
+			if fh.UncompressedSize64 == 0xffffffff && len(field) >= 8 {
+				fh.UncompressedSize64, field = binary.LittleEndian.Uint64(field), field[8:]
+			}
+			if fh.CompressedSize64 == 0xffffffff && len(field) >= 8 {
+				fh.CompressedSize64 = binary.LittleEndian.Uint64(field)
+			}
+		}
+		extra = extra[4+size:]
+	}
+	return kept
+}
+
+// findDataEnd returns the offset just past the compressed data starting at dataStart, for
+// entries whose size is only recorded in the data descriptor after it.  Deflated data ends
+// where the flate stream does, and stored data where a data descriptor whose size matches the
+// data in front of it starts.
+func findDataEnd(r io.ReaderAt, size, dataStart int64, method uint16) (int64, error) {
+	switch method {
+	case zip.Deflate:
+		cr := &countingByteReader{r: bufio.NewReader(io.NewSectionReader(r, dataStart, size-dataStart))}
+		rc, err := decompressor(method, cr, -1)
+		if err != nil {
+			return 0, err
+		}
+		defer rc.Close()
+		if _, err := io.Copy(ioutil.Discard, rc); err != nil {
+			return 0, err
+		}
+		return dataStart + cr.n, nil
+	case zip.Store:
+		sig := make([]byte, 4)
+		binary.LittleEndian.PutUint32(sig, dataDescriptorSignature)
+		buf := make([]byte, 32*1024)
+		for pos := dataStart; pos < size; pos += int64(len(buf) - len(sig) + 1) {
+			n, _ := r.ReadAt(buf, pos)
+			for i := 0; i+len(sig) <= n; i++ {
+				if !bytes.Equal(buf[i:i+len(sig)], sig) {
+					continue
+				}
+				desc := make([]byte, 8)
+				if _, err := r.ReadAt(desc, pos+int64(i)+8); err != nil {
+					continue
+				}
+				if int64(binary.LittleEndian.Uint32(desc)) == pos+int64(i)-dataStart {
+					return pos + int64(i), nil
+				}
+			}
+			if n < len(buf) {
+				break
+			}
+		}
+		return 0, io.ErrUnexpectedEOF
+	}
+	return 0, zip.ErrAlgorithm
+}
+
+// countingByteReader counts the bytes read through it, and is an io.ByteReader so that
+// decompressors read no more than they need.
+type countingByteReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (cr *countingByteReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+func (cr *countingByteReader) ReadByte() (byte, error) {
+	b, err := cr.r.ReadByte()
+	if err == nil {
+		cr.n++
+	}
+	return b, err
+}
+
+// validEntry reports whether the compressed data of the entry decompresses to the size and
+// checksum recorded in fh.  Entries compressed with methods zipwalk cannot read are assumed to
+// be valid.
+func validEntry(fh *zip.FileHeader, data io.Reader) bool {
+	rc, err := decompressor(fh.Method, data, int64(fh.UncompressedSize64))
+	if err == zip.ErrAlgorithm {
+		return true
+	}
+	if err != nil {
+		return false
+	}
+	defer rc.Close()
+	h := crc32.NewIEEE()
+	n, err := io.Copy(h, rc)
+	return err == nil && uint64(n) == fh.UncompressedSize64 && h.Sum32() == fh.CRC32
+}
@@ -0,0 +1,51 @@
+package zipwalk
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"time"
+)
+
+// WithRetry makes Walk open files again when opening them fails with an error that network
+// filesystems such as NFS return transiently: ESTALE, EIO or EAGAIN.  Files are opened up to
+// maxAttempts times, waiting backoff before the second attempt and twice as long before each
+// attempt after it.  Other errors, such as missing files or denied permissions, are handed to
+// walkFn straight away.
+func WithRetry(maxAttempts int, backoff time.Duration) Option {
+	return func(o *options) {
+		o.retryAttempts = maxAttempts
+		o.retryBackoff = backoff
+	}
+}
+
+// WithOpenFile makes Walk open the files it walks with openFile rather than os.Open, e.g., to
+// open them through a different mount or to fail them in tests.  Errors of openFile are retried
+// as set by WithRetry.
+func WithOpenFile(openFile func(name string) (*os.File, error)) Option {
+	return func(o *options) {
+		o.openFile = openFile
+	}
+}
+
+// retryable reports whether opening a file may succeed when it is tried again after err.
+func retryable(err error) bool {
+	return errors.Is(err, syscall.ESTALE) || errors.Is(err, syscall.EIO) || errors.Is(err, syscall.EAGAIN)
+}
+
+// open opens the file at path with openFile, retrying as set by WithRetry.  Waiting between
+// attempts stops once the context of the walk is done.
+func (o *options) open(path string) (*os.File, error) {
+	f, err := o.openFile(path)
+	wait := o.retryBackoff
+	for attempt := 1; err != nil && attempt < o.retryAttempts && retryable(err); attempt++ {
+		select {
+		case <-o.ctx.Done():
+			return nil, err
+		case <-time.After(wait):
+		}
+		wait *= 2
+		f, err = o.openFile(path)
+	}
+	return f, err
+}
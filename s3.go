@@ -0,0 +1,129 @@
+package zipwalk
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// S3Client is the minimal interface to an object store that WithObjectStorage needs.
+// GetObject must return the length bytes of the object starting at offset, which maps onto
+// an HTTP range request.
+type S3Client interface {
+	HeadObject(bucket, key string) (size int64, err error)
+	GetObject(bucket, key string, offset, length int64) (io.ReadCloser, error)
+}
+
+// WithObjectStorage lets Walk be called with an s3://bucket/key root, which is walked as a
+// remote zip file.  Only the byte ranges needed for the central directory and the visited
+// entries are fetched through client, so the archive is never downloaded as a whole.  Ranges
+// are fetched in blocks of 256 KiB, of which the 16 used last are kept, so the many small reads
+// of the zip file make one request per block rather than one each.  An invalid root is
+// returned as an error without walkFn being called.
+func WithObjectStorage(client S3Client) Option {
+	return func(o *options) {
+		o.objectStorage = client
+	}
+}
+
+// isObjectURL reports whether root refers to an object in object storage.
+func isObjectURL(root string) bool {
+	return strings.HasPrefix(root, "s3://")
+}
+
+func walkObject(o *options, root string, walkFn WalkFunc) error {
+	bucket, key, ok := strings.Cut(strings.TrimPrefix(root, "s3://"), "/")
+	if !ok || bucket == "" || key == "" {
+		return fmt.Errorf("invalid object URL - %s", root)
+	}
+	size, err := o.objectStorage.HeadObject(bucket, key)
+	if err != nil {
+		return walkFn(root, objectInfo{name: path.Base(key)}, nil, err)
+	}
+	ra := &objectReaderAt{client: o.objectStorage, bucket: bucket, key: key, size: size, blocks: map[int64][]byte{}}
+	info := objectInfo{name: path.Base(key), size: size}
+	return walkFuncRecursive(o, 1, root, info, io.NewSectionReader(ra, 0, size), walkFn, nil)
+}
+
+const (
+	objectBlockSize = 256 << 10
+	objectBlocks    = 16 // blocks kept by objectReaderAt
+)
+
+// objectReaderAt serves ReadAt calls from blocks of the object fetched with ranged GetObject
+// requests, keeping the blocks used last.
+type objectReaderAt struct {
+	client      S3Client
+	bucket, key string
+	size        int64
+	mu          sync.Mutex
+	blocks      map[int64][]byte // by index
+	order       []int64          // indexes of blocks, least recently used first
+}
+
+func (ra *objectReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for n < len(p) {
+		if off >= ra.size {
+			return n, io.EOF
+		}
+		block, err := ra.block(off / objectBlockSize)
+		if err != nil {
+			return n, err
+		}
+		copied := copy(p[n:], block[off%objectBlockSize:])
+		n += copied
+		off += int64(copied)
+	}
+	return n, nil
+}
+
+// block returns the block at index i, fetching it if it is not kept.
+func (ra *objectReaderAt) block(i int64) ([]byte, error) {
+	ra.mu.Lock()
+	defer ra.mu.Unlock()
+	for j, idx := range ra.order {
+		if idx == i {
+			ra.order = append(append(ra.order[:j:j], ra.order[j+1:]...), i)
+			return ra.blocks[i], nil
+		}
+	}
+	start := i * objectBlockSize
+	length := int64(objectBlockSize)
+	if start+length > ra.size {
+		length = ra.size - start
+	}
+	rc, err := ra.client.GetObject(ra.bucket, ra.key, start, length)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	block := make([]byte, length)
+	if _, err := io.ReadFull(rc, block); err != nil {
+		return nil, err
+	}
+	if len(ra.order) == objectBlocks {
+		delete(ra.blocks, ra.order[0])
+		ra.order = ra.order[1:]
+	}
+	ra.blocks[i] = block
+	ra.order = append(ra.order, i)
+	return block, nil
+}
+
+// objectInfo is the os.FileInfo of an object in object storage.
+type objectInfo struct {
+	name string
+	size int64
+}
+
+func (oi objectInfo) Name() string       { return oi.name }
+func (oi objectInfo) Size() int64        { return oi.size }
+func (oi objectInfo) Mode() os.FileMode  { return 0444 }
+func (oi objectInfo) ModTime() time.Time { return time.Time{} }
+func (oi objectInfo) IsDir() bool        { return false }
+func (oi objectInfo) Sys() interface{}   { return nil }
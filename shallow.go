@@ -0,0 +1,17 @@
+package zipwalk
+
+// WalkShallow calls walkFn for each direct child of the zip file at zipPath, which may itself
+// be inside other zip files, e.g., file1.zip/file2.zip.  Nested zip files are handed to walkFn
+// as regular files and are not descended into.
+func WalkShallow(zipPath string, walkFn WalkFunc, opts ...Option) error {
+	o := newOptions(opts)
+	o.shallow = true
+	zr, info, closer, err := openZip(zipPath)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+	return o.run(walkFn, func(walkFn WalkFunc) error {
+		return walkZipEntries(o, 1, zipPath, info, zr, walkFn)
+	})
+}
@@ -0,0 +1,355 @@
+package zipwalk
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	_ "crypto/sha1" // digests used by JAR manifests
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrNotSigned is returned by VerifySignature for archives without a JAR signature.
+var ErrNotSigned = fmt.Errorf("zipwalk: archive is not signed")
+
+// SignatureResult describes the JAR signature of an archive.
+type SignatureResult struct {
+	SignerName    string    // common name of the signing certificate
+	SignatureTime time.Time // signing time attribute of the signature, if any
+	// Valid reports whether the signature matches META-INF/MANIFEST.MF, the manifest matches
+	// the content of every entry outside META-INF and every such entry is in the manifest.
+	Valid bool
+	// CertChain starts with the signing certificate, followed by the certificates included in
+	// the signature that issued it.  Whether the chain is trusted is up to the caller.
+	CertChain []*x509.Certificate
+}
+
+// VerifySignature verifies the JAR signature of the zip file at path, which may be inside other
+// zip files, e.g., file1.zip/app.jar.  The signature is read from the first META-INF/*.SF file
+// that has a matching .RSA, .EC or .DSA signature block.  A signature that does not verify is reported
+// with Valid set to false rather than as an error.
+func VerifySignature(path string) (*SignatureResult, error) {
+	zr, _, closer, err := openZip(path)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+	sfName, blockName := findSignatureFiles(zr)
+	if sfName == "" {
+		return nil, ErrNotSigned
+	}
+	sf, err := readZipEntry(zr, sfName)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading %s - %w", sfName, err)
+	}
+	block, err := readZipEntry(zr, blockName)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading %s - %w", blockName, err)
+	}
+	manifest, err := readZipEntry(zr, "META-INF/MANIFEST.MF")
+	if err != nil {
+		return nil, fmt.Errorf("Error reading META-INF/MANIFEST.MF - %w", err)
+	}
+	result, err := verifySignatureBlock(block, sf)
+	if err != nil {
+		return nil, fmt.Errorf("Error verifying %s - %w", blockName, err)
+	}
+	if result.Valid {
+		result.Valid, err = verifyManifest(zr, manifest, sf)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// findSignatureFiles returns the first signature file in zr that has a signature block.
+func findSignatureFiles(zr *zip.Reader) (sfName, blockName string) {
+	names := map[string]bool{}
+	for _, f := range zr.File {
+		names[f.Name] = true
+	}
+	var sfNames []string
+	for name := range names {
+		if path.Dir(name) == "META-INF" && strings.HasSuffix(strings.ToUpper(name), ".SF") {
+			sfNames = append(sfNames, name)
+		}
+	}
+	sort.Strings(sfNames)
+	for _, name := range sfNames {
+		base := name[:len(name)-len(".SF")]
+		for _, ext := range []string{".RSA", ".EC", ".DSA"} {
+			if names[base+ext] {
+				return name, base + ext
+			}
+		}
+	}
+	return "", ""
+}
+
+// verifyManifest checks the digest of manifest recorded in sf and the digests of the entries of
+// zr recorded in manifest.
+func verifyManifest(zr *zip.Reader, manifest, sf []byte) (bool, error) {
+	sfSections := parseManifest(sf)
+	if len(sfSections) == 0 || !checkDigests(sfSections[0], "-Digest-Manifest", manifest) {
+		return false, nil
+	}
+	entries := map[string]map[string]string{}
+	for _, section := range parseManifest(manifest) {
+		if name, ok := section["Name"]; ok {
+			entries[name] = section
+		}
+	}
+	for _, f := range zr.File {
+		if strings.HasPrefix(f.Name, "META-INF/") || f.FileInfo().IsDir() {
+			continue
+		}
+		section, ok := entries[f.Name]
+		if !ok {
+			return false, nil
+		}
+		rdr, err := f.Open()
+		if err != nil {
+			return false, fmt.Errorf("Error opening file %s - %w", f.Name, err)
+		}
+		content, err := ioutil.ReadAll(rdr)
+		rdr.Close()
+		if err != nil {
+			return false, fmt.Errorf("Error reading file - %s - %w", f.Name, err)
+		}
+		if !checkDigests(section, "-Digest", content) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// manifestDigests maps the digest names used in JAR manifests to their hash functions.
+var manifestDigests = map[string]crypto.Hash{
+	"SHA1":    crypto.SHA1,
+	"SHA-256": crypto.SHA256,
+	"SHA-384": crypto.SHA384,
+	"SHA-512": crypto.SHA512,
+}
+
+// checkDigests reports whether section holds at least one digest attribute named with suffix,
+// e.g., SHA-256-Digest, and all such digests match data.
+func checkDigests(section map[string]string, suffix string, data []byte) bool {
+	found := false
+	for key, value := range section {
+		hash, ok := manifestDigests[strings.TrimSuffix(key, suffix)]
+		if !ok || !strings.HasSuffix(key, suffix) {
+			continue
+		}
+		h := hash.New()
+		h.Write(data)
+		if base64.StdEncoding.EncodeToString(h.Sum(nil)) != value {
+			return false
+		}
+		found = true
+	}
+	return found
+}
+
+// parseManifest splits a JAR manifest or signature file into its sections of attributes, the
+// main section first.  Lines starting with a space continue the previous line.
+func parseManifest(data []byte) []map[string]string {
+	var sections []map[string]string
+	section := map[string]string{}
+	lastKey := ""
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		switch {
+		case line == "":
+			if len(section) > 0 {
+				sections = append(sections, section)
+				section = map[string]string{}
+			}
+		case strings.HasPrefix(line, " ") && lastKey != "":
+			section[lastKey] += line[1:]
+		default:
+			if i := strings.Index(line, ": "); i > 0 {
+				lastKey = line[:i]
+				section[lastKey] = line[i+2:]
+			}
+		}
+	}
+	if len(section) > 0 {
+		sections = append(sections, section)
+	}
+	return sections
+}
+
+// The PKCS #7 structures of a JAR signature block, see RFC 2315.
+type pkcs7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      pkcs7ContentInfo
+	Certificates     asn1.RawValue     `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue     `asn1:"optional,tag:1"`
+	SignerInfos      []pkcs7SignerInfo `asn1:"set"`
+}
+
+type pkcs7SignerInfo struct {
+	Version                   int
+	IssuerAndSerialNumber     pkcs7IssuerAndSerial
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	AuthenticatedAttributes   asn1.RawValue `asn1:"optional,tag:0"`
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+	UnauthenticatedAttributes asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type pkcs7IssuerAndSerial struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type pkcs7Attribute struct {
+	Type  asn1.ObjectIdentifier
+	Value asn1.RawValue `asn1:"set"`
+}
+
+var (
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSigningTime   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+)
+
+// pkcs7Digests maps the digest algorithms of PKCS #7 signatures to their hash functions.
+var pkcs7Digests = map[string]crypto.Hash{
+	"1.3.14.3.2.26":          crypto.SHA1,
+	"2.16.840.1.101.3.4.2.1": crypto.SHA256,
+	"2.16.840.1.101.3.4.2.2": crypto.SHA384,
+	"2.16.840.1.101.3.4.2.3": crypto.SHA512,
+}
+
+// verifySignatureBlock verifies the detached PKCS #7 signature in block over content, the
+// signature file.
+func verifySignatureBlock(block, content []byte) (*SignatureResult, error) {
+	var ci pkcs7ContentInfo
+	if _, err := asn1.Unmarshal(block, &ci); err != nil {
+		return nil, err
+	}
+	if !ci.ContentType.Equal(oidSignedData) {
+		return nil, errors.New("signature block does not hold signed data")
+	}
+	var sd pkcs7SignedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		return nil, err
+	}
+	if len(sd.SignerInfos) == 0 {
+		return nil, errors.New("signature block has no signers")
+	}
+	certs, err := x509.ParseCertificates(sd.Certificates.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	si := sd.SignerInfos[0]
+	var signer *x509.Certificate
+	for _, cert := range certs {
+		if bytes.Equal(cert.RawIssuer, si.IssuerAndSerialNumber.Issuer.FullBytes) && cert.SerialNumber.Cmp(si.IssuerAndSerialNumber.SerialNumber) == 0 {
+			signer = cert
+			break
+		}
+	}
+	if signer == nil {
+		return nil, errors.New("signature block does not hold the signing certificate")
+	}
+	hash, ok := pkcs7Digests[si.DigestAlgorithm.Algorithm.String()]
+	if !ok {
+		return nil, fmt.Errorf("unsupported digest algorithm %s", si.DigestAlgorithm.Algorithm)
+	}
+	result := &SignatureResult{SignerName: signer.Subject.CommonName, Valid: true}
+	signed := content
+	if len(si.AuthenticatedAttributes.FullBytes) > 0 {
+		// the attributes are signed with their SET OF tag rather than the [0] they are stored with
+		signed = append([]byte{0x31}, si.AuthenticatedAttributes.FullBytes[1:]...)
+		var attrs []pkcs7Attribute
+		if _, err := asn1.UnmarshalWithParams(signed, &attrs, "set"); err != nil {
+			return nil, err
+		}
+		h := hash.New()
+		h.Write(content)
+		// the signature covers only the attributes, so content is only signed through its digest
+		hasDigest := false
+		for _, attr := range attrs {
+			switch {
+			case attr.Type.Equal(oidMessageDigest):
+				var digest []byte
+				if _, err := asn1.Unmarshal(attr.Value.Bytes, &digest); err != nil {
+					return nil, err
+				}
+				hasDigest = true
+				result.Valid = result.Valid && bytes.Equal(digest, h.Sum(nil))
+			case attr.Type.Equal(oidSigningTime):
+				asn1.Unmarshal(attr.Value.Bytes, &result.SignatureTime)
+			}
+		}
+		result.Valid = result.Valid && hasDigest
+	}
+	h := hash.New()
+	h.Write(signed)
+	switch pub := signer.PublicKey.(type) {
+	case *rsa.PublicKey:
+		result.Valid = result.Valid && rsa.VerifyPKCS1v15(pub, hash, h.Sum(nil), si.EncryptedDigest) == nil
+	case *ecdsa.PublicKey:
+		result.Valid = result.Valid && ecdsa.VerifyASN1(pub, h.Sum(nil), si.EncryptedDigest)
+	case *dsa.PublicKey:
+		var sig struct{ R, S *big.Int }
+		if rest, err := asn1.Unmarshal(si.EncryptedDigest, &sig); err != nil || len(rest) > 0 {
+			result.Valid = false
+			break
+		}
+		// the digest is cut to the size of Q, e.g., SHA-256 with the 224 bit Q of a 2048 bit key
+		digest := h.Sum(nil)
+		if n := (pub.Q.BitLen() + 7) / 8; n < len(digest) {
+			digest = digest[:n]
+		}
+		result.Valid = result.Valid && dsa.Verify(pub, digest, sig.R, sig.S)
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", pub)
+	}
+	result.CertChain = []*x509.Certificate{signer}
+	for cert := signer; !bytes.Equal(cert.RawIssuer, cert.RawSubject); {
+		var parent *x509.Certificate
+		for _, candidate := range certs {
+			if bytes.Equal(candidate.RawSubject, cert.RawIssuer) {
+				parent = candidate
+				break
+			}
+		}
+		if parent == nil || len(result.CertChain) > len(certs) {
+			break
+		}
+		if cert.CheckSignatureFrom(parent) != nil {
+			result.Valid = false
+			break
+		}
+		result.CertChain = append(result.CertChain, parent)
+		cert = parent
+	}
+	return result, nil
+}
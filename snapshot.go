@@ -0,0 +1,87 @@
+package zipwalk
+
+import (
+	"archive/zip"
+	"os"
+	"sync"
+	"time"
+)
+
+// EntrySnapshot records a zip entry as a walk saw it, so that a later walk can tell whether it
+// changed.
+type EntrySnapshot struct {
+	Path    string
+	Size    uint64 // uncompressed size
+	CRC32   uint32
+	ModTime time.Time
+}
+
+// Snapshot holds the zip entries seen by an earlier walk and records those seen by walks made
+// with WithSnapshot, for processing only the entries that changed since, e.g.:
+//
+//	snap := zipwalk.NewSnapshot(prev, func(path string, info os.FileInfo) {
+//		log.Printf("%s is unchanged", path)
+//	})
+//	err := zipwalk.Walk(root, walkFn, zipwalk.WithSnapshot(snap))
+//	snap.UpdateSnapshot(prev) // save prev for the next run
+type Snapshot struct {
+	prev   map[string]EntrySnapshot
+	onSkip func(path string, info os.FileInfo)
+	mu     sync.Mutex
+	seen   map[string]EntrySnapshot
+}
+
+// NewSnapshot returns a Snapshot of the entries in prev, by path, calling onSkip, if not nil,
+// for the entries that walks skip because they match prev.  prev is not modified by walks.
+func NewSnapshot(prev map[string]EntrySnapshot, onSkip func(path string, info os.FileInfo)) *Snapshot {
+	return &Snapshot{prev: prev, onSkip: onSkip, seen: map[string]EntrySnapshot{}}
+}
+
+// WithSnapshot skips the zip entries whose path, size and CRC32 match an entry of snap, calling
+// its onSkip callback rather than walkFn, without reading them.  A zip file inside a zip file
+// that matches is skipped along with its entries.  The other zip entries are walked as usual and
+// recorded in snap, for UpdateSnapshot.  Directories and files outside of zip files, which have
+// no CRC32 to compare, are always walked.  Paths are those handed to walkFn, before
+// WithPathMapper and WithPathHasher are applied.
+func WithSnapshot(snap *Snapshot) Option {
+	return func(o *options) {
+		o.snapshot = snap
+	}
+}
+
+// UpdateSnapshot adds the zip entries walked since NewSnapshot to snap, replacing the entries
+// there with the same paths.  Entries that were skipped are still in snap when it is the map
+// given to NewSnapshot.
+func (s *Snapshot) UpdateSnapshot(snap map[string]EntrySnapshot) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for path, entry := range s.seen {
+		snap[path] = entry
+	}
+}
+
+// matches reports whether the entry f at path matches the earlier walk.
+func (s *Snapshot) matches(path string, f *zip.File) bool {
+	if f.Mode().IsDir() {
+		return false
+	}
+	prev, ok := s.prev[path]
+	return ok && prev.Size == f.UncompressedSize64 && prev.CRC32 == f.CRC32
+}
+
+// skipped calls onSkip for an entry that matches the earlier walk.
+func (s *Snapshot) skipped(path string, info os.FileInfo) {
+	if s.onSkip != nil {
+		s.onSkip(path, info)
+	}
+}
+
+// record records the entry f at path, which does not match the earlier walk, for UpdateSnapshot.
+func (s *Snapshot) record(path string, f *zip.File) {
+	if f.Mode().IsDir() {
+		return
+	}
+	s.mu.Lock()
+	s.seen[path] = EntrySnapshot{Path: path, Size: f.UncompressedSize64, CRC32: f.CRC32, ModTime: f.Modified}
+	s.mu.Unlock()
+}
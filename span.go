@@ -0,0 +1,289 @@
+package zipwalk
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	directoryEndSignature    = 0x06054b50
+	directoryHeaderSignature = 0x02014b50
+	directoryEndLen          = 22
+	directoryHeaderLen       = 46
+)
+
+// SpanReader supplies the earlier volumes of a zip archive that has been spanned across
+// several files (e.g., archive.z01, archive.z02, archive.zip).  NextVolume returns the volumes
+// in order, starting with the first, and io.EOF once there are no more.
+type SpanReader interface {
+	NextVolume() (io.ReaderAt, int64, error)
+}
+
+// WithSpanningSupport lets Walk read spanned zip archives.  When Walk opens the last volume of
+// such an archive, at path, the earlier volumes are requested from a new SpanReader returned by
+// newSpanReader(path) and stitched in front of it, so every archive in the tree gets its own.
+// A function is taken rather than a single SpanReader, as NextVolume only goes forwards and one
+// reader would hand the volumes of the first archive to every other.  newSpanReader may be
+// called concurrently, for archives in different directories.
+func WithSpanningSupport(newSpanReader func(path string) SpanReader) Option {
+	return func(o *options) {
+		o.span = newSpanReader
+	}
+}
+
+// errNoDirectoryEnd means that data holds no end of central directory record, so it is not a
+// zip file at all.
+var errNoDirectoryEnd = errors.New("zip: not a valid zip file, end of central directory not found")
+
+// directoryEnd holds the fields of the end of central directory record.
+type directoryEnd struct {
+	diskNbr       uint16
+	dirDiskNbr    uint16
+	dirRecords    uint16
+	dirSize       uint32
+	dirOffset     uint32
+	commentLength uint16
+}
+
+// readDirectoryEnd finds the end of central directory record of the zip data in r and returns
+// it along with its offset.  The record is the one nearest the end whose comment ends the data,
+// so the signature turning up inside the comment or the compressed data is passed over.
+func readDirectoryEnd(r io.ReaderAt, size int64) (*directoryEnd, int64, error) {
+	searchLen := int64(directoryEndLen + 65535)
+	if searchLen > size {
+		searchLen = size
+	}
+	buf := make([]byte, searchLen)
+	if _, err := r.ReadAt(buf, size-searchLen); err != nil && err != io.EOF {
+		return nil, 0, err
+	}
+	for i := len(buf) - directoryEndLen; i >= 0; i-- {
+		if binary.LittleEndian.Uint32(buf[i:]) != directoryEndSignature {
+			continue
+		}
+		b := buf[i+4:]
+		if i+directoryEndLen+int(binary.LittleEndian.Uint16(b[16:])) != len(buf) {
+			continue
+		}
+		return &directoryEnd{
+			diskNbr:       binary.LittleEndian.Uint16(b[0:]),
+			dirDiskNbr:    binary.LittleEndian.Uint16(b[2:]),
+			dirRecords:    binary.LittleEndian.Uint16(b[6:]),
+			dirSize:       binary.LittleEndian.Uint32(b[8:]),
+			dirOffset:     binary.LittleEndian.Uint32(b[12:]),
+			commentLength: binary.LittleEndian.Uint16(b[16:]),
+		}, size - searchLen + int64(i), nil
+	}
+	return nil, 0, errNoDirectoryEnd
+}
+
+// multiReaderAt presents several io.ReaderAts as one, one after the other.
+type multiReaderAt struct {
+	parts   []io.ReaderAt
+	offsets []int64 // offset of each part, with the total size as the last element
+}
+
+func newMultiReaderAt(parts []io.ReaderAt, sizes []int64) *multiReaderAt {
+	m := &multiReaderAt{parts: parts, offsets: make([]int64, len(parts)+1)}
+	for i, size := range sizes {
+		m.offsets[i+1] = m.offsets[i] + size
+	}
+	return m
+}
+
+func (m *multiReaderAt) Size() int64 {
+	return m.offsets[len(m.parts)]
+}
+
+func (m *multiReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for i := range m.parts {
+		if len(p) == 0 {
+			break
+		}
+		if off >= m.offsets[i+1] {
+			continue
+		}
+		partOff := off - m.offsets[i]
+		want := m.offsets[i+1] - off
+		if want > int64(len(p)) {
+			want = int64(len(p))
+		}
+		read, err := m.parts[i].ReadAt(p[:want], partOff)
+		n += read
+		if err != nil && !(err == io.EOF && int64(read) == want) {
+			return n, err
+		}
+		p = p[want:]
+		off += want
+	}
+	if len(p) > 0 {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// joinSpannedZip stitches the earlier volumes supplied by sr in front of last, the final volume
+// of a spanned zip archive.  The central directory of a spanned archive records offsets relative
+// to the volume each entry starts on, so a rewritten central directory holding offsets into the
+// stitched volumes is appended after them.
+func joinSpannedZip(last io.ReaderAt, lastSize int64, end *directoryEnd, sr SpanReader) (*multiReaderAt, error) {
+	var parts []io.ReaderAt
+	var sizes []int64
+	for {
+		ra, size, err := sr.NextVolume()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Error reading zip volume %d - %w", len(parts)+1, err)
+		}
+		parts = append(parts, ra)
+		sizes = append(sizes, size)
+	}
+	if len(parts) != int(end.diskNbr) {
+		return nil, fmt.Errorf("zip archive spans %d volumes but %d were supplied", end.diskNbr+1, len(parts)+1)
+	}
+	volumes := newMultiReaderAt(append(parts, last), append(sizes, lastSize))
+	if end.dirSize == 0xffffffff || end.dirOffset == 0xffffffff {
+		return nil, errors.New("zip64 spanned archives are not supported")
+	}
+	dir := make([]byte, end.dirSize)
+	if _, err := volumes.ReadAt(dir, volumes.offsets[end.dirDiskNbr]+int64(end.dirOffset)); err != nil {
+		return nil, fmt.Errorf("Error reading central directory - %w", err)
+	}
+	for pos := 0; pos+directoryHeaderLen <= len(dir); {
+		rec := dir[pos:]
+		if binary.LittleEndian.Uint32(rec) != directoryHeaderSignature {
+			return nil, errors.New("zip: invalid central directory header")
+		}
+		disk := binary.LittleEndian.Uint16(rec[34:])
+		offset := binary.LittleEndian.Uint32(rec[42:])
+		if int(disk) >= len(volumes.parts) || offset == 0xffffffff {
+			return nil, errors.New("zip: invalid volume reference in central directory")
+		}
+		abs := volumes.offsets[disk] + int64(offset)
+		if abs > 0xffffffff {
+			return nil, errors.New("zip64 spanned archives are not supported")
+		}
+		binary.LittleEndian.PutUint16(rec[34:], 0)
+		binary.LittleEndian.PutUint32(rec[42:], uint32(abs))
+		pos += directoryHeaderLen + int(binary.LittleEndian.Uint16(rec[28:])) + int(binary.LittleEndian.Uint16(rec[30:])) + int(binary.LittleEndian.Uint16(rec[32:]))
+	}
+	dirOffset := volumes.Size()
+	if dirOffset > 0xffffffff {
+		return nil, errors.New("zip64 spanned archives are not supported")
+	}
+	eocd := make([]byte, directoryEndLen)
+	binary.LittleEndian.PutUint32(eocd[0:], directoryEndSignature)
+	binary.LittleEndian.PutUint16(eocd[8:], end.dirRecords)
+	binary.LittleEndian.PutUint16(eocd[10:], end.dirRecords)
+	binary.LittleEndian.PutUint32(eocd[12:], end.dirSize)
+	binary.LittleEndian.PutUint32(eocd[16:], uint32(dirOffset))
+	return newMultiReaderAt(
+		[]io.ReaderAt{volumes, bytesReaderAt(dir), bytesReaderAt(eocd)},
+		[]int64{volumes.Size(), int64(len(dir)), int64(len(eocd))},
+	), nil
+}
+
+// bytesReaderAt serves ReadAt calls from a byte slice.
+type bytesReaderAt []byte
+
+func (b bytesReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(b)) {
+		return 0, io.EOF
+	}
+	n := copy(p, b[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// spannedInfo reports the size of a stitched spanned archive instead of its last volume.
+type spannedInfo struct {
+	os.FileInfo
+	size int64
+}
+
+func (si spannedInfo) Size() int64 {
+	return si.size
+}
+
+// NewMultiVolumeReaderAt opens the volumes of a multi-disk zip archive, given in order (e.g.,
+// archive.z01, archive.z02, archive.zip), and stitches them into a single io.ReaderAt of the
+// returned size that can be passed to WalkAt or zip.NewReader.  The returned io.ReaderAt also
+// implements io.Closer, which closes the volume files.
+func NewMultiVolumeReaderAt(volumes []string) (io.ReaderAt, int64, error) {
+	if len(volumes) == 0 {
+		return nil, 0, errors.New("zipwalk: no volumes given")
+	}
+	mv := &multiVolume{}
+	var sizes []int64
+	for _, volume := range volumes {
+		f, err := os.Open(volume)
+		if err != nil {
+			mv.Close()
+			return nil, 0, err
+		}
+		mv.files = append(mv.files, f)
+		info, err := f.Stat()
+		if err != nil {
+			mv.Close()
+			return nil, 0, fmt.Errorf("Error reading file - %s - %w", volume, err)
+		}
+		sizes = append(sizes, info.Size())
+	}
+	parts := make([]io.ReaderAt, len(mv.files))
+	for i, f := range mv.files {
+		parts[i] = f
+	}
+	last, lastSize := parts[len(parts)-1], sizes[len(sizes)-1]
+	end, _, err := readDirectoryEnd(last, lastSize)
+	if err != nil || end.diskNbr == 0 {
+		// not a spanned archive, or one whose offsets already account for the earlier volumes
+		mv.multiReaderAt = newMultiReaderAt(parts, sizes)
+		return mv, mv.Size(), nil
+	}
+	joined, err := joinSpannedZip(last, lastSize, end, &volumeSlice{parts[:len(parts)-1], sizes[:len(sizes)-1]})
+	if err != nil {
+		mv.Close()
+		return nil, 0, err
+	}
+	mv.multiReaderAt = joined
+	return mv, mv.Size(), nil
+}
+
+// multiVolume is the io.ReaderAt returned by NewMultiVolumeReaderAt.
+type multiVolume struct {
+	*multiReaderAt
+	files []*os.File
+}
+
+func (mv *multiVolume) Close() error {
+	var err error
+	for _, f := range mv.files {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// volumeSlice is a SpanReader over volumes that have already been opened.
+type volumeSlice struct {
+	parts []io.ReaderAt
+	sizes []int64
+}
+
+func (vs *volumeSlice) NextVolume() (io.ReaderAt, int64, error) {
+	if len(vs.parts) == 0 {
+		return nil, 0, io.EOF
+	}
+	ra, size := vs.parts[0], vs.sizes[0]
+	vs.parts, vs.sizes = vs.parts[1:], vs.sizes[1:]
+	return ra, size, nil
+}
@@ -0,0 +1,24 @@
+// Package sqlite reads zip files stored as BLOBs in SQLite databases, or any other database
+// behind database/sql, for zipwalk.WalkAt.  It is kept apart from zipwalk, and imports no
+// driver itself, so that zipwalk does not depend on one.
+package sqlite
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"io"
+)
+
+// OpenSQLiteBlob runs query with args on db and returns the first column of the first row it
+// returns, e.g., the BLOB selected by "SELECT data FROM artifacts WHERE id = ?", as an
+// io.ReaderAt along with its size, to be handed to zipwalk.WalkAt.  database/sql cannot read
+// BLOBs in parts, so the BLOB is read into memory.  A query returning no rows fails with
+// sql.ErrNoRows.
+func OpenSQLiteBlob(db *sql.DB, query string, args ...interface{}) (io.ReaderAt, int64, error) {
+	var blob []byte
+	if err := db.QueryRow(query, args...).Scan(&blob); err != nil {
+		return nil, 0, fmt.Errorf("Error reading blob - %s - %w", query, err)
+	}
+	return bytes.NewReader(blob), int64(len(blob)), nil
+}
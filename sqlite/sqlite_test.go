@@ -0,0 +1,81 @@
+package sqlite_test
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/mzimmerman/zipwalk"
+	"github.com/mzimmerman/zipwalk/sqlite"
+	"github.com/mzimmerman/zipwalk/testutil"
+)
+
+// blobDriver is a database/sql driver whose every query returns the blobs it was opened with,
+// one per row, standing in for a SQLite driver.
+type blobDriver struct {
+	blobs [][]byte
+}
+
+func (d *blobDriver) Open(name string) (driver.Conn, error)     { return d, nil }
+func (d *blobDriver) Prepare(query string) (driver.Stmt, error) { return d, nil }
+func (d *blobDriver) Close() error                              { return nil }
+func (d *blobDriver) Begin() (driver.Tx, error)                 { return nil, errors.New("not supported") }
+func (d *blobDriver) NumInput() int                             { return -1 }
+func (d *blobDriver) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, errors.New("not supported")
+}
+func (d *blobDriver) Query(args []driver.Value) (driver.Rows, error) {
+	return &blobRows{blobs: d.blobs}, nil
+}
+
+type blobRows struct {
+	blobs [][]byte
+}
+
+func (r *blobRows) Columns() []string { return []string{"data"} }
+func (r *blobRows) Close() error      { return nil }
+func (r *blobRows) Next(dest []driver.Value) error {
+	if len(r.blobs) == 0 {
+		return io.EOF
+	}
+	dest[0], r.blobs = r.blobs[0], r.blobs[1:]
+	return nil
+}
+
+func TestOpenSQLiteBlob(t *testing.T) {
+	zipBytes := testutil.ZipBytes(t, map[string][]byte{"a.txt": []byte("a"), "b.txt": []byte("b")})
+	sql.Register("zipwalk-blobs", &blobDriver{blobs: [][]byte{zipBytes}})
+	sql.Register("zipwalk-empty", &blobDriver{})
+	db, err := sql.Open("zipwalk-blobs", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	r, size, err := sqlite.OpenSQLiteBlob(db, "SELECT data FROM artifacts WHERE id = ?", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	err = zipwalk.WalkAt(r, size, "artifact.zip", func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		got = append(got, path)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"artifact.zip", "artifact.zip/a.txt", "artifact.zip/b.txt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	empty, err := sql.Open("zipwalk-empty", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer empty.Close()
+	if _, _, err := sqlite.OpenSQLiteBlob(empty, "SELECT data FROM artifacts WHERE id = ?", 2); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected sql.ErrNoRows for a missing row, got %v", err)
+	}
+}
@@ -0,0 +1,89 @@
+package zipwalk
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// tarExtensions lists the extensions of the files Walk treats as tar archives, optionally
+// gzip-compressed.
+var tarExtensions = []string{".tar", ".tar.gz", ".tgz"}
+
+func isTar(name string) bool {
+	name = strings.ToLower(name)
+	for _, ext := range tarExtensions {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// walkTar calls walkFn for the tar archive at filePath and then for each of its entries,
+// descending into the entries that are zip files, e.g., archive.tar/lib/inner.zip/a.txt.  Tar
+// archives are read front to back, so zip files inside them are read into memory first.
+func walkTar(o *options, filePath string, info os.FileInfo, content io.Reader, walkFn WalkFunc) error {
+	err := walkFn(filePath, info, nil, nil)
+	if err == SkipZip {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("walkTar received error from walkFn for file %s - %w", filePath, err)
+	}
+	br := bufio.NewReader(content)
+	content = br
+	if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return walkFn(filePath, info, nil, err)
+		}
+		defer gr.Close()
+		content = gr
+	}
+	tr := tar.NewReader(content)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return walkFn(filePath, info, nil, err)
+		}
+		if o.skipPath(hdr.Name) || o.skipEmpty && hdr.Typeflag == tar.TypeReg && hdr.Size == 0 {
+			continue
+		}
+		entryPath := joinEntry(o, filePath, hdr.Name)
+		entryInfo := hdr.FileInfo()
+		if !o.shallow && isZip(hdr.Name) && hdr.Typeflag == tar.TypeReg {
+			buf, err := o.readAll(tr)
+			if err != nil {
+				o.releaseBytes(buf)
+				return fmt.Errorf("Error reading file - %s - %w", entryPath, err)
+			}
+			err = walkFuncRecursive(o, 1, entryPath, entryInfo, bytes.NewReader(buf), walkFn, nil)
+			o.releaseBytes(buf)
+			if err != nil {
+				return fmt.Errorf("Received error from walkFuncRecursive - %s - %w", entryPath, err)
+			}
+			continue
+		}
+		var rdr io.Reader
+		if hdr.Typeflag == tar.TypeReg {
+			rdr = tr
+		}
+		err = walkFn(entryPath, entryInfo, rdr, nil)
+		if err == filepath.SkipDir {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("Received error from walkFn - %s - %w", entryPath, err)
+		}
+	}
+}
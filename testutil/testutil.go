@@ -0,0 +1,124 @@
+// Package testutil builds zip files for tests of code that uses zipwalk.
+package testutil
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// NewTempZip writes a zip file holding an entry for each name in files, with the given content,
+// and returns its path.  Content for names ending in .zip is expected to be a zip file itself
+// (e.g., from ZipBytes) and is stored uncompressed so that it can be walked into.  The file is
+// removed when the test finishes.
+func NewTempZip(t testing.TB, files map[string][]byte) string {
+	t.Helper()
+	f, err := os.CreateTemp("", "zipwalk-*.zip")
+	if err != nil {
+		t.Fatalf("Error creating temporary zip file - %v", err)
+	}
+	t.Cleanup(func() { os.Remove(f.Name()) })
+	if err := writeZip(f, files); err != nil {
+		f.Close()
+		t.Fatalf("Error writing temporary zip file %s - %v", f.Name(), err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Error closing temporary zip file %s - %v", f.Name(), err)
+	}
+	return f.Name()
+}
+
+// ZipBytes returns a zip file holding an entry for each name in files, for use as the content
+// of a nested zip file given to NewTempZip.
+func ZipBytes(t testing.TB, files map[string][]byte) []byte {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	if err := writeZip(buf, files); err != nil {
+		t.Fatalf("Error writing zip - %v", err)
+	}
+	return buf.Bytes()
+}
+
+// writeZip writes the entries of files to w in name order.
+func writeZip(w io.Writer, files map[string][]byte) error {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	entries := make([]entry, len(names))
+	for i, name := range names {
+		entries[i] = entry{name: name, content: files[name]}
+	}
+	return writeEntries(w, entries)
+}
+
+// entry is a named entry of a zip file to be written.
+type entry struct {
+	name    string
+	content []byte
+	inner   *Builder // builds the content of a nested zip file instead, if set
+}
+
+// writeEntries writes entries to w in the given order.
+func writeEntries(w io.Writer, entries []entry) error {
+	zw := zip.NewWriter(w)
+	for _, e := range entries {
+		fh := &zip.FileHeader{Name: e.name, Method: zip.Deflate}
+		if strings.HasSuffix(strings.ToLower(e.name), ".zip") {
+			fh.Method = zip.Store
+		}
+		ew, err := zw.CreateHeader(fh)
+		if err != nil {
+			return err
+		}
+		content := e.content
+		if e.inner != nil {
+			content = e.inner.Build()
+		}
+		if _, err := ew.Write(content); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// Builder builds a zip file, including nested zip files, entry by entry, e.g.:
+//
+//	zipBytes := new(testutil.Builder).
+//		AddFile("a.txt", "hi there").
+//		AddZip("b.zip", new(testutil.Builder).AddFile("dir1/dir1.txt", "hi there")).
+//		Build()
+//
+// The zero value is an empty zip file.  Entries are written in the order they were added.
+type Builder struct {
+	entries []entry
+}
+
+// AddFile adds an entry at path with the given content.  Paths ending in "/" add directories,
+// whose content is ignored.
+func (b *Builder) AddFile(path, content string) *Builder {
+	b.entries = append(b.entries, entry{name: path, content: []byte(content)})
+	return b
+}
+
+// AddZip adds a zip file at path built by inner, which is stored uncompressed so that it can be
+// walked into.  inner is built along with b, so entries added to it later are included.
+func (b *Builder) AddZip(path string, inner *Builder) *Builder {
+	b.entries = append(b.entries, entry{name: path, inner: inner})
+	return b
+}
+
+// Build returns the bytes of the zip file.  It panics if an entry cannot be written, which only
+// happens for invalid paths.
+func (b *Builder) Build() []byte {
+	buf := new(bytes.Buffer)
+	if err := writeEntries(buf, b.entries); err != nil {
+		panic("testutil: building zip - " + err.Error())
+	}
+	return buf.Bytes()
+}
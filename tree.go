@@ -0,0 +1,130 @@
+package zipwalk
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+// ZipTree is a node of the tree of entries of a zip file, as built by NewZipTree.  The
+// children of a nested zip file are its entries.
+type ZipTree struct {
+	Name     string
+	Info     os.FileInfo
+	Children []*ZipTree
+	IsZip    bool
+	Err      error // why the entries of a nested zip file are missing, e.g., ErrEncrypted
+}
+
+// NewZipTree builds the tree of the entries of the zip file at zipPath, which may itself be
+// inside other zip files, descending into nested zip files.  Only the headers of the entries are
+// read, apart from nested zip files, which are read into memory to list their entries.  A nested
+// zip file that cannot be read has no children, and the error reading it in its Err, rather
+// than failing the whole tree.  Directories that only the names of their entries imply get a
+// node too, in the order they are first implied.  Otherwise, children are in the order of their
+// entries.
+func NewZipTree(zipPath string) (*ZipTree, error) {
+	zr, info, closer, err := openZip(zipPath)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+	root := &ZipTree{Name: info.Name(), Info: info, IsZip: true}
+	addZipEntries(newOptions(nil), root, ForwardSlashResolver{}.Join(zipPath), info, zr)
+	return root, nil
+}
+
+// addZipEntries adds the entries of zr, the zip file at filePath, below its node.
+func addZipEntries(o *options, node *ZipTree, filePath string, info os.FileInfo, zr *zip.Reader) {
+	nodes := map[string]*ZipTree{"": node}
+	for _, f := range zr.File {
+		name := strings.TrimPrefix(path.Clean("/"+f.Name), "/")
+		if name == "" {
+			continue
+		}
+		child := nodes[name]
+		if child == nil {
+			parent := node
+			if dir := path.Dir(name); dir != "." {
+				parent = impliedNode(nodes, dir, info)
+			}
+			child = &ZipTree{Name: path.Base(name)}
+			parent.Children = append(parent.Children, child)
+			nodes[name] = child
+		}
+		child.Info = NewZipFileInfo(info.ModTime(), f.FileInfo())
+		if f.FileInfo().IsDir() || !isZip(name) {
+			continue
+		}
+		entryPath := filePath + "/" + name
+		buf, err := readEntry(o, entryPath, f)
+		if err != nil {
+			child.Err = err
+			continue
+		}
+		nested, err := newZipReader(bytes.NewReader(buf), int64(len(buf)))
+		if err != nil {
+			// named like a zip file, but not one
+			continue
+		}
+		child.IsZip = true
+		addZipEntries(o, child, entryPath, child.Info, nested)
+	}
+}
+
+// readEntry reads the content of the entry f at entryPath into memory.
+func readEntry(o *options, entryPath string, f *zip.File) ([]byte, error) {
+	rdr, err := openEntry(o, entryPath, f)
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+	buf, err := ioutil.ReadAll(rdr)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading file - %s - %w", entryPath, err)
+	}
+	return buf, nil
+}
+
+// impliedNode returns the node of the directory dir, creating it and the nodes of its parents
+// as implied directories if they have not been seen yet.
+func impliedNode(nodes map[string]*ZipTree, dir string, info os.FileInfo) *ZipTree {
+	if node := nodes[dir]; node != nil {
+		return node
+	}
+	parent := nodes[""]
+	if parentDir := path.Dir(dir); parentDir != "." {
+		parent = impliedNode(nodes, parentDir, info)
+	}
+	node := &ZipTree{Name: path.Base(dir), Info: impliedDirInfo{name: path.Base(dir), modTime: info.ModTime()}}
+	parent.Children = append(parent.Children, node)
+	nodes[dir] = node
+	return node
+}
+
+// Find returns the node at the slash separated path below t, e.g., dir/inner.zip/a.txt, or t
+// itself for an empty path.
+func (t *ZipTree) Find(path string) (*ZipTree, bool) {
+	node := t
+	for _, name := range strings.Split(strings.Trim(path, "/"), "/") {
+		if name == "" || name == "." {
+			continue
+		}
+		var next *ZipTree
+		for _, child := range node.Children {
+			if child.Name == name {
+				next = child
+				break
+			}
+		}
+		if next == nil {
+			return nil, false
+		}
+		node = next
+	}
+	return node, true
+}
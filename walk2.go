@@ -0,0 +1,73 @@
+package zipwalk
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// Entry describes a file, directory or zip entry handed to a WalkFuncV2.
+type Entry struct {
+	Path   string
+	Info   os.FileInfo
+	Reader io.ReaderAt // content of files, nil for directories and when Err is set
+	Err    error
+	// Depth is the number of zip files that Path goes through, so 0 for the real files.
+	Depth int
+	// ZipChain lists the paths of those zip files, outermost first.
+	ZipChain []string
+	// OriginalPath is Path before WithPathMapper and WithPathHasher derived Path from it, or
+	// Path itself.
+	OriginalPath string
+}
+
+// WalkFuncV2 is the type of the function called by Walk2 for each file or directory.  Like a
+// WalkFunc, it may return SkipDir, SkipZip or any other error to stop the walk.
+type WalkFuncV2 func(entry Entry) error
+
+// Walk2 is Walk for a WalkFuncV2, which receives everything about an entry in one Entry that
+// can grow without breaking callers.  Content that is not already held in memory or in a real
+// file, such as the files inside zip files, is read into memory to be handed over as an
+// io.ReaderAt.
+func Walk2(root string, fn WalkFuncV2, opts ...Option) error {
+	o := newOptions(opts)
+	o.rawPaths = true
+	return o.run(func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		entry := Entry{Path: path, OriginalPath: path, Info: info, Err: err}
+		entry.Path = o.mapPath(path, info)
+		entry.ZipChain = zipChain(path)
+		entry.Depth = len(entry.ZipChain)
+		if reader != nil && err == nil {
+			ra, ok := reader.(io.ReaderAt)
+			if !ok {
+				buf, err := ioutil.ReadAll(reader)
+				if err != nil {
+					entry.Err = fmt.Errorf("Error reading file - %s - %w", path, err)
+				} else {
+					ra = bytes.NewReader(buf)
+				}
+			}
+			entry.Reader = ra
+		}
+		return fn(entry)
+	}, func(walkFn WalkFunc) error {
+		return walk(root, walkFn, o)
+	})
+}
+
+// zipChain returns the paths of the zip files that path goes through, outermost first.
+func zipChain(path string) []string {
+	zipPath, rest := splitZipPath(path)
+	if zipPath == "" {
+		return nil
+	}
+	chain := []string{zipPath}
+	for end := zipBoundary(rest); end != -1; end = zipBoundary(rest) {
+		zipPath += "/" + rest[:end]
+		chain = append(chain, zipPath)
+		rest = rest[end+1:]
+	}
+	return chain
+}
@@ -0,0 +1,85 @@
+package zipwalk
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ErrStaleToken is returned by WalkN when the entry recorded in a WalkToken is no longer
+// found at the recorded position, which means the tree changed between the two calls.
+var ErrStaleToken = fmt.Errorf("zipwalk: resume token does not match the tree")
+
+// errStopWalk is used internally to unwind a walk early without reporting an error.
+var errStopWalk = errors.New("zipwalk: stop walk")
+
+// WalkToken records a position in the walk order so that a later WalkN call can continue
+// exactly where a previous one stopped.
+type WalkToken struct {
+	Offset int    // number of entries that came before the resumption point
+	Path   string // path of the last entry before the resumption point
+}
+
+// Resume makes WalkN continue from the position recorded in token instead of using its
+// offset argument.
+func Resume(token WalkToken) Option {
+	return func(o *options) {
+		o.resume = &token
+	}
+}
+
+// SaveToken makes WalkN store the position it stopped at in token, ready to be passed to
+// Resume on the next call.
+func SaveToken(token *WalkToken) Option {
+	return func(o *options) {
+		o.save = token
+	}
+}
+
+// WalkN walks the tree like Walk but skips the first offset entries and returns once n entries
+// have been handed to walkFn, which allows callers to page through large trees.  The real files
+// are walked serially in lexical order so that a given offset always refers to the same entry.
+// Only the entries that the options, such as WithExtensions, let through to walkFn count.
+func WalkN(root string, n int, offset int, walkFn WalkFunc, opts ...Option) error {
+	o := newOptions(opts)
+	o.serial = true
+	if o.resume != nil {
+		offset = o.resume.Offset
+	}
+	seen, handed := 0, 0
+	lastPath := ""
+	if o.resume != nil {
+		lastPath = o.resume.Path
+	}
+	err := o.run(func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		seen++
+		if seen <= offset {
+			if seen == offset && o.resume != nil && o.resume.Path != "" && o.resume.Path != path {
+				return ErrStaleToken
+			}
+			return nil
+		}
+		if handed >= n {
+			return errStopWalk
+		}
+		handed++
+		lastPath = path
+		if err := walkFn(path, info, reader, err); err != nil {
+			return err
+		}
+		if handed == n {
+			return errStopWalk
+		}
+		return nil
+	}, func(walkFn WalkFunc) error {
+		return walk(root, walkFn, o)
+	})
+	if o.save != nil {
+		*o.save = WalkToken{Offset: offset + handed, Path: lastPath}
+	}
+	if errors.Is(err, errStopWalk) {
+		return nil
+	}
+	return err
+}
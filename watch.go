@@ -0,0 +1,182 @@
+package zipwalk
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Watcher watches the zip files under a directory tree, see Watch.
+type Watcher struct {
+	root     string
+	walkFn   WalkFunc
+	opts     []Option
+	interval time.Duration
+	zips     map[string]watchedZip
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+	err      error
+}
+
+// watchedZip is what a Watcher knows of a zip file since it last walked it.
+type watchedZip struct {
+	modTime time.Time
+	size    int64
+	crcs    map[string]uint32 // CRC-32 of every entry, by path
+}
+
+// WithWatchInterval sets how often a Watcher looks for zip files that have been created or
+// modified, once a second by default.
+func WithWatchInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.watchInterval = d
+	}
+}
+
+// Watch watches the zip files under root and, whenever one is created or modified, walks it
+// again, calling walkFn only for the entries that are new or whose content has changed, as told
+// by their CRC-32.  The zip files already under root are walked once before Watch returns, to
+// learn their entries, without calling walkFn.  Zip files whose walk fails, e.g., because they
+// are still being written, and paths under root that cannot be read are handed to walkFn with
+// the error and tried again later.  walkFn is called from a single goroutine.  When walkFn
+// returns SkipZip or filepath.SkipDir for an entry, the remaining changes of that zip file are
+// not handed to walkFn, until they change again.  Any other error returned by walkFn stops the
+// Watcher.
+//
+// The tree is polled, see WithWatchInterval, so Watch works the same on every platform and
+// filesystem, including network filesystems that do not report changes.
+func Watch(root string, walkFn WalkFunc, opts ...Option) (*Watcher, error) {
+	o := newOptions(opts)
+	w := &Watcher{
+		root:     root,
+		walkFn:   walkFn,
+		opts:     opts,
+		interval: o.watchInterval,
+		zips:     map[string]watchedZip{},
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if w.interval <= 0 {
+		w.interval = time.Second
+	}
+	if err := w.scan(false); err != nil {
+		return nil, err
+	}
+	go w.run()
+	return w, nil
+}
+
+// Stop stops watching and waits for a walk in progress to finish.  It returns the error that
+// stopped the Watcher before, if any.
+func (w *Watcher) Stop() error {
+	w.stopOnce.Do(func() {
+		close(w.stop)
+	})
+	<-w.done
+	return w.err
+}
+
+func (w *Watcher) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			if err := w.scan(true); err != nil {
+				w.err = err
+				return
+			}
+		}
+	}
+}
+
+// scan walks the zip files under root that are new or modified since the last scan, calling
+// walkFn for their new and changed entries if notify is set.
+func (w *Watcher) scan(notify bool) error {
+	seen := map[string]bool{}
+	err := filepath.Walk(w.root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if !notify {
+				return err
+			}
+			return w.report(path, info, err)
+		}
+		if info.IsDir() || !isZip(path) {
+			return nil
+		}
+		seen[path] = true
+		old, ok := w.zips[path]
+		if ok && old.modTime.Equal(info.ModTime()) && old.size == info.Size() {
+			return nil
+		}
+		crcs, walkFnErr, err := w.walkZip(path, old.crcs, notify)
+		if walkFnErr != nil {
+			return walkFnErr
+		}
+		if err != nil {
+			if !notify {
+				return err
+			}
+			return w.report(path, info, err)
+		}
+		w.zips[path] = watchedZip{modTime: info.ModTime(), size: info.Size(), crcs: crcs}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for path := range w.zips {
+		if !seen[path] {
+			delete(w.zips, path)
+		}
+	}
+	return nil
+}
+
+// report hands err for path to walkFn, returning what walkFn returned unless it is only asking
+// to skip path.
+func (w *Watcher) report(path string, info os.FileInfo, err error) error {
+	if err := w.walkFn(path, info, nil, err); err != SkipZip && err != filepath.SkipDir {
+		return err
+	}
+	return nil
+}
+
+// walkZip walks the zip file at path, returning the CRC-32 of its entries.  The entries whose
+// CRC-32 is not in old are handed to walkFn if notify is set, until walkFn asks to skip the
+// rest of the zip file, and walkFnErr is the error walkFn returned for them, if any.
+func (w *Watcher) walkZip(path string, old map[string]uint32, notify bool) (crcs map[string]uint32, walkFnErr error, err error) {
+	crcs = map[string]uint32{}
+	skipped := false
+	err = Walk(path, func(entryPath string, info os.FileInfo, reader io.Reader, err error) error {
+		if err != nil {
+			return err
+		}
+		h, ok := info.(Headered)
+		if !ok || info.IsDir() || h.ZipHeader() == nil {
+			return nil
+		}
+		crc := h.ZipHeader().CRC32
+		crcs[entryPath] = crc
+		if prev, ok := old[entryPath]; notify && !skipped && (!ok || prev != crc) {
+			switch err := w.walkFn(entryPath, info, reader, nil); err {
+			case nil:
+			case SkipZip, filepath.SkipDir:
+				// the remaining entries are still recorded, so they are not handed on later
+				skipped = true
+			default:
+				walkFnErr = err
+				return err
+			}
+		}
+		return nil
+	}, w.opts...)
+	return crcs, walkFnErr, err
+}
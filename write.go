@@ -0,0 +1,546 @@
+package zipwalk
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// CreateZipFromFS writes a zip archive to dst containing the tree rooted at root in src.  Entry
+// names are relative to root and keep the modification times reported by src.
+func CreateZipFromFS(dst io.Writer, src fs.FS, root string) error {
+	zw := zip.NewWriter(dst)
+	err := fs.WalkDir(src, root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel := path.Base(name)
+		switch {
+		case name == root && d.IsDir():
+			return nil
+		case root == ".":
+			rel = name
+		case name != root:
+			rel = strings.TrimPrefix(name, root+"/")
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		fh, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return fmt.Errorf("Error creating header for %s - %w", name, err)
+		}
+		fh.Name = rel
+		if d.IsDir() {
+			fh.Name += "/"
+		} else {
+			fh.Method = zip.Deflate
+		}
+		w, err := zw.CreateHeader(fh)
+		if err != nil {
+			return fmt.Errorf("Error creating zip entry %s - %w", rel, err)
+		}
+		if d.IsDir() {
+			return nil
+		}
+		f, err := src.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if _, err := io.Copy(w, f); err != nil {
+			return fmt.Errorf("Error writing zip entry %s - %w", rel, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// DeduplicateZip copies the zip file at src to dst, keeping only the first entry with any given
+// name.  Entries are copied without being recompressed.  It returns the number of entries that
+// were left out.
+func DeduplicateZip(src, dst string) (int, error) {
+	zr, err := zip.OpenReader(src)
+	if err != nil {
+		return 0, fmt.Errorf("error opening zip file - %s - %w", src, err)
+	}
+	defer zr.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return 0, err
+	}
+	defer out.Close()
+	zw := zip.NewWriter(out)
+	if err := zw.SetComment(zr.Comment); err != nil {
+		return 0, err
+	}
+	seen := make(map[string]bool, len(zr.File))
+	removed := 0
+	for _, f := range zr.File {
+		if seen[f.Name] {
+			removed++
+			continue
+		}
+		seen[f.Name] = true
+		if err := zw.Copy(f); err != nil {
+			return removed, fmt.Errorf("Error copying zip entry %s - %w", f.Name, err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return removed, err
+	}
+	return removed, out.Close()
+}
+
+// CopyEntry copies the file at src, which may be inside zip files, e.g., file1.zip/a.txt, to the
+// entry named by dst inside a zip file on the filesystem, e.g., file2.zip/dir1/a.txt, replacing
+// any entry of that name.  The zip file is created if it does not exist.  Entries are compressed
+// with the method that the other entries of the destination already use, and when src is
+// compressed with the same method its compressed bytes are copied as they are instead of being
+// decompressed and compressed again.  An existing destination keeps its comment and permissions,
+// and a new one is created with 0666 less the umask, as os.Create would.
+func CopyEntry(src, dst string) error {
+	f, _, closer, err := findEntry(src)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+	dstZip, name := splitZipPath(dst)
+	if dstZip == "" || name == "" {
+		return fmt.Errorf("path is not inside a zip file - %s", dst)
+	}
+	if inner, _ := splitZipPath(name); inner != "" {
+		return fmt.Errorf("copying into nested zip files is not supported - %s", dst)
+	}
+	var existing []*zip.File
+	var comment string
+	var dstInfo os.FileInfo
+	method := f.Method
+	if zr, err := zip.OpenReader(dstZip); err == nil {
+		defer zr.Close()
+		if dstInfo, err = os.Stat(dstZip); err != nil {
+			return err
+		}
+		existing, comment = zr.File, zr.Comment
+		for _, ef := range existing {
+			if !ef.FileInfo().IsDir() {
+				method = ef.Method
+				break
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("error opening zip file - %s - %w", dstZip, err)
+	}
+	out, err := createTemp(filepath.Dir(dstZip), filepath.Base(dstZip)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+	if dstInfo != nil {
+		// the umask may have taken permissions away from the temporary file
+		if err := out.Chmod(dstInfo.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+	zw := zip.NewWriter(out)
+	if err := zw.SetComment(comment); err != nil {
+		return err
+	}
+	for _, ef := range existing {
+		if ef.Name == name {
+			continue
+		}
+		if err := zw.Copy(ef); err != nil {
+			return fmt.Errorf("Error copying zip entry %s - %w", ef.Name, err)
+		}
+	}
+	fh := f.FileHeader
+	fh.Name = name
+	if f.Method == method {
+		err = copyRaw(zw, &fh, f)
+	} else {
+		fh.Method = method
+		err = copyRecompressed(zw, &fh, f)
+	}
+	if err != nil {
+		return fmt.Errorf("Error copying %s to %s - %w", src, dst, err)
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(out.Name(), dstZip)
+}
+
+// createTemp creates a new file in dir whose name starts with prefix, like os.CreateTemp, but
+// with 0666 less the umask rather than only readable by its owner, as it replaces another file.
+func createTemp(dir, prefix string) (*os.File, error) {
+	for i := 0; ; i++ {
+		name := filepath.Join(dir, prefix+strconv.FormatUint(uint64(rand.Uint32()), 10))
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if !os.IsExist(err) || i == 10000 {
+			return f, err
+		}
+	}
+}
+
+// copyRaw writes the compressed bytes of f to zw as they are, under the header fh.
+func copyRaw(zw *zip.Writer, fh *zip.FileHeader, f *zip.File) error {
+	raw, err := f.OpenRaw()
+	if err != nil {
+		return err
+	}
+	w, err := zw.CreateRaw(fh)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, raw)
+	return err
+}
+
+// copyRecompressed decompresses f and compresses it again to zw with the method of fh.
+func copyRecompressed(zw *zip.Writer, fh *zip.FileHeader, f *zip.File) error {
+	rdr, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rdr.Close()
+	w, err := zw.CreateHeader(fh)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, rdr)
+	return err
+}
+
+// WithCompressionLevel sets the flate compression level, from flate.BestSpeed to
+// flate.BestCompression, of the entries written by CompressDir.  The default is
+// flate.DefaultCompression.
+func WithCompressionLevel(level int) Option {
+	return func(o *options) {
+		o.compressLevel = level
+	}
+}
+
+// CompressDir writes the files of the tree rooted at src to a new zip file at dst, with names
+// relative to src and the modification times and modes of the files.  The tree is walked like
+// Walk does, so options such as WithExtensions and WithExcludePattern select the files written,
+// but zip files are written as they are rather than descended into, so walking dst visits the
+// same files as walking src.  Tar archives are written as they are too.  Empty directories are
+// not written, and neither is dst if it lies inside src.
+func CompressDir(src, dst string, opts ...Option) error {
+	o := newOptions(opts)
+	o.serial = true
+	if _, err := flate.NewWriter(ioutil.Discard, o.compressLevel); err != nil {
+		return err
+	}
+	absDst, err := filepath.Abs(dst)
+	if err != nil {
+		return err
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	zw := zip.NewWriter(out)
+	zw.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(w, o.compressLevel)
+	})
+	err = o.run(func(filePath string, info os.FileInfo, reader io.Reader, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || isArchiveEntry(info) {
+			return nil
+		}
+		if abs, err := filepath.Abs(filePath); err == nil && abs == absDst {
+			return SkipZip
+		}
+		if err := addWalkedFile(zw, src, filePath, info, reader); err != nil {
+			return err
+		}
+		if isZip(filePath) || isTar(filePath) {
+			return SkipZip
+		}
+		return nil
+	}, func(walkFn WalkFunc) error {
+		return walk(src, walkFn, o)
+	})
+	if err == nil {
+		err = zw.Close()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dst)
+	}
+	return err
+}
+
+// StreamZip writes a zip file to dst holding the files of the tree rooted at src for which
+// filter returns true, with names relative to src, e.g., a.zip/b.txt for the entry b.txt of the
+// zip file a.zip.  Zip files and tar archives that filter accepts are written as they are;
+// those it rejects are descended into, offering their entries to filter.  Directories are not
+// offered.  Files are walked serially and copied one at a time, so only nested zip files are
+// held in memory, as when walking them.  Nothing needs to seek dst, so it can be an HTTP response.
+func StreamZip(src string, dst io.Writer, filter func(path string, info os.FileInfo) bool, opts ...Option) error {
+	o := newOptions(opts)
+	o.serial = true
+	zw := zip.NewWriter(dst)
+	err := o.run(func(filePath string, info os.FileInfo, reader io.Reader, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !filter(filePath, info) {
+			return nil
+		}
+		if err := addWalkedFile(zw, src, filePath, info, reader); err != nil {
+			return err
+		}
+		if isZip(filePath) || isTar(filePath) && !isArchiveEntry(info) {
+			return SkipZip
+		}
+		return nil
+	}, func(walkFn WalkFunc) error {
+		return walk(src, walkFn, o)
+	})
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// addWalkedFile writes the file handed over at filePath by a walk of src to zw, named by its
+// path relative to src, with its content from reader.
+func addWalkedFile(zw *zip.Writer, src, filePath string, info os.FileInfo, reader io.Reader) error {
+	rel, err := filepath.Rel(src, filePath)
+	if err != nil {
+		return err
+	}
+	if rel == "." {
+		rel = info.Name()
+	}
+	fh, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return fmt.Errorf("Error creating header for %s - %w", filePath, err)
+	}
+	if zfi, ok := info.(ZipFileInfo); ok && zfi.ZipHeader() != nil {
+		fh.Modified = zfi.ZipHeader().Modified
+	}
+	fh.Name = filepath.ToSlash(rel)
+	fh.Method = zip.Deflate
+	if isZip(filePath) {
+		// zip files are already compressed, and are walked into faster when stored
+		fh.Method = zip.Store
+	}
+	w, err := zw.CreateHeader(fh)
+	if err != nil {
+		return fmt.Errorf("Error creating zip entry %s - %w", fh.Name, err)
+	}
+	if reader == nil {
+		if isArchiveEntry(info) || !isTar(filePath) {
+			return nil
+		}
+		// tar archives are handed to walkFn without their content
+		f, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		reader = f
+	}
+	if _, err := io.Copy(w, reader); err != nil {
+		return fmt.Errorf("Error writing zip entry %s - %w", fh.Name, err)
+	}
+	return nil
+}
+
+// isArchiveEntry reports whether info describes an entry inside a zip file or tar archive
+// rather than a real file.
+func isArchiveEntry(info os.FileInfo) bool {
+	_, inTar := info.Sys().(*tar.Header)
+	return inTar || isZipEntry(info)
+}
+
+// RepackStats reports what Repack did.
+type RepackStats struct {
+	Recompressed int   // entries whose compressed data was replaced
+	BytesBefore  int64 // compressed size of all entries in src
+	BytesAfter   int64 // compressed size of all entries in dst
+}
+
+// BytesSaved is the number of compressed bytes that Repack saved.
+func (rs RepackStats) BytesSaved() int64 {
+	return rs.BytesBefore - rs.BytesAfter
+}
+
+// Repack copies the zip file at src, which may itself be inside other zip files, to dst,
+// compressing each stored or deflated entry with flate.BestCompression and keeping the result
+// only when it is smaller than the entry already is.  Incompressible stored entries therefore
+// stay stored.  The order, names, modification times and comments of the entries and the
+// comment of the archive are kept.  Entries are compressed in memory, one at a time.
+func Repack(src, dst string) (RepackStats, error) {
+	var stats RepackStats
+	zr, _, closer, err := openZip(src)
+	if err != nil {
+		return stats, err
+	}
+	defer closer.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return stats, err
+	}
+	defer out.Close()
+	zw := zip.NewWriter(out)
+	if err := zw.SetComment(zr.Comment); err != nil {
+		return stats, err
+	}
+	for _, f := range zr.File {
+		stats.BytesBefore += int64(f.CompressedSize64)
+		compressed, err := recompress(f)
+		if err != nil {
+			return stats, fmt.Errorf("Error recompressing zip entry %s - %w", f.Name, err)
+		}
+		if compressed == nil {
+			stats.BytesAfter += int64(f.CompressedSize64)
+			if err := zw.Copy(f); err != nil {
+				return stats, fmt.Errorf("Error copying zip entry %s - %w", f.Name, err)
+			}
+			continue
+		}
+		fh := f.FileHeader
+		fh.Method = zip.Deflate
+		fh.Flags &^= 0x8 // the sizes are known, so no data descriptor follows the data
+		fh.CompressedSize64 = uint64(len(compressed))
+		w, err := zw.CreateRaw(&fh)
+		if err != nil {
+			return stats, fmt.Errorf("Error creating zip entry %s - %w", f.Name, err)
+		}
+		if _, err := w.Write(compressed); err != nil {
+			return stats, fmt.Errorf("Error writing zip entry %s - %w", f.Name, err)
+		}
+		stats.Recompressed++
+		stats.BytesAfter += int64(len(compressed))
+	}
+	if err := zw.Close(); err != nil {
+		return stats, err
+	}
+	return stats, out.Close()
+}
+
+// recompress deflates the content of f with flate.BestCompression and returns the result if it
+// is smaller than f already is, or nil otherwise.
+func recompress(f *zip.File) ([]byte, error) {
+	if (f.Method != zip.Store && f.Method != zip.Deflate) || f.FileInfo().IsDir() {
+		return nil, nil
+	}
+	rdr, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+	content, err := ioutil.ReadAll(rdr)
+	if err != nil {
+		return nil, err
+	}
+	buf := new(bytes.Buffer)
+	fw, err := flate.NewWriter(buf, flate.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	fw.Write(content)
+	if err := fw.Close(); err != nil {
+		return nil, err
+	}
+	if uint64(buf.Len()) >= f.CompressedSize64 {
+		return nil, nil
+	}
+	return buf.Bytes(), nil
+}
+
+// CloneZip copies every entry of the zip archive of the given size read from src to a new zip
+// archive written to dst, passing each through fn along with a copy of its header.  fn may
+// change the header, e.g., to rename the entry or change its compression method, and returns
+// the content to write, which may be read from r.  Returning r itself copies the compressed
+// bytes of the entry as they are, unless the compression method was changed, and returning a
+// nil reader drops the entry.  r is only decompressed if it is read from.  The comment of the
+// archive is kept.
+func CloneZip(src io.ReaderAt, size int64, dst io.Writer, fn func(path string, header *zip.FileHeader, r io.Reader) (io.Reader, error)) error {
+	zr, err := newZipReader(src, size)
+	if err != nil {
+		return err
+	}
+	zw := zip.NewWriter(dst)
+	if err := zw.SetComment(zr.Comment); err != nil {
+		return err
+	}
+	for _, f := range zr.File {
+		if err := cloneEntry(zw, f, fn); err != nil {
+			return fmt.Errorf("Error cloning zip entry %s - %w", f.Name, err)
+		}
+	}
+	return zw.Close()
+}
+
+func cloneEntry(zw *zip.Writer, f *zip.File, fn func(path string, header *zip.FileHeader, r io.Reader) (io.Reader, error)) error {
+	original := &lazyEntryReader{f: f}
+	defer original.Close()
+	fh := f.FileHeader
+	r, err := fn(f.Name, &fh, original)
+	if err != nil || r == nil {
+		return err
+	}
+	if r == io.Reader(original) && fh.Method == f.Method {
+		return copyRaw(zw, &fh, f)
+	}
+	w, err := zw.CreateHeader(&fh)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
+
+// lazyEntryReader reads the decompressed content of a zip entry, which is only opened once it is
+// first read from.
+type lazyEntryReader struct {
+	f  *zip.File
+	rc io.ReadCloser
+}
+
+func (lr *lazyEntryReader) Read(p []byte) (int, error) {
+	if lr.rc == nil {
+		rc, err := lr.f.Open()
+		if err != nil {
+			return 0, err
+		}
+		lr.rc = rc
+	}
+	return lr.rc.Read(p)
+}
+
+func (lr *lazyEntryReader) Close() error {
+	if lr.rc == nil {
+		return nil
+	}
+	return lr.rc.Close()
+}
@@ -1,19 +1,31 @@
+// Package zipwalk walks file trees like filepath.Walk does, except that it also walks through
+// the contents of zip files, including zip files stored inside other zip files.  Zip files
+// inside tar archives (.tar, .tar.gz and .tgz) are walked as well, e.g.,
+// archive.tar/lib/inner.zip/a.txt.
+//
+// The os.FileInfo handed to a WalkFunc for an entry inside a zip file is a ZipFileInfo.  It
+// implements Headered, so the complete zip.FileHeader of the entry, with its CRC32, extra
+// fields and comment, can be reached with a type assertion:
+//
+//	if h, ok := info.(zipwalk.Headered); ok {
+//		crc := h.ZipHeader().CRC32
+//	}
 package zipwalk
 
 import (
 	"archive/zip"
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 	"time"
 
-	// "github.com/alexmullins/zip"
-
 	"github.com/iafan/cwalk"
 )
 
@@ -31,6 +43,12 @@ var SkipZip = fmt.Errorf("SkipZip")
 // containing the file "a", the walk function will be called with argument
 // "dir/a". The info argument is the os.FileInfo for the named path.
 //
+// The reader argument holds the content of files.  For entries inside zip files
+// it streams straight out of the zip file without being buffered, so it is only
+// valid until walkFn returns and must not be kept.  Zip files nested inside
+// other zip files are the exception; they are read into memory because
+// zip.NewReader needs random access.
+//
 // If there was a problem walking to the file or directory named by path, the
 // incoming error will describe the problem and the function can decide how
 // to handle that error (and Walk will not descend into that directory). If
@@ -41,24 +59,73 @@ var SkipZip = fmt.Errorf("SkipZip")
 // Walk skips the remaining files in the containing directory.
 type WalkFunc func(path string, info os.FileInfo, reader io.Reader, err error) error
 
-// Walk walks the file tree rooted at root including through zip files, calling walkFn for each file or
+// Walk walks the file tree rooted at root including through zip files (and other zip based
+// formats such as .epub), calling walkFn for each file or
 // directory in the tree, including root. All errors that arise visiting files
 // and directories are filtered by walkFn. The real files are walked in lexical
 // order, which makes the output deterministic but means that for very
 // large directories Walk can be inefficient.  Files insize zip files are walked in the order they appear in the zip file.
-// Walk does not follow symbolic links.
-func Walk(root string, walkFn WalkFunc) error {
-	return cwalk.Walk(root, func(filePath string, info os.FileInfo, err error) error {
+// Walk does not follow symbolic links. The behaviour of the walk can be adjusted with opts.
+// An error returned by walkFn for an entry inside a zip file stops the walk too, and Walk
+// returns it wrapped with the path of the entry, so errors.Is finds it.  Walk used to ignore
+// such errors and carry on with the next entry.
+func Walk(root string, walkFn WalkFunc, opts ...Option) error {
+	o := newOptions(opts)
+	return o.run(walkFn, func(walkFn WalkFunc) error {
+		return walk(root, walkFn, o)
+	})
+}
+
+// WalkAt walks the zip archive of the given size read from r as if it were a file named name,
+// calling walkFn for the archive itself and then for each of its entries.
+func WalkAt(r io.ReaderAt, size int64, name string, walkFn WalkFunc, opts ...Option) error {
+	info := objectInfo{name: filepath.Base(name), size: size}
+	o := newOptions(opts)
+	return o.run(walkFn, func(walkFn WalkFunc) error {
+		return walkFuncRecursive(o, 1, name, info, io.NewSectionReader(r, 0, size), walkFn, nil)
+	})
+}
+
+func walk(root string, walkFn WalkFunc, o *options) error {
+	if o.objectStorage != nil && isObjectURL(root) {
+		return walkObject(o, root, walkFn)
+	}
+	walkReal := cwalk.Walk
+	if o.reverse {
+		walkReal = walkReverse
+	} else if o.serial {
+		walkReal = filepath.Walk
+	}
+	return walkReal(root, func(filePath string, info os.FileInfo, err error) error {
+		if err == nil && filePath != root && o.skipName(info.Name()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
 		if err != nil || info.IsDir() {
 			return walkFn(filePath, info, nil, err)
 		}
-		f, err := os.Open(filePath)
+		f, err := o.open(filePath)
 		if err != nil {
 			return walkFn(filePath, info, nil, err)
 		}
 		defer f.Close()
-		if strings.ToLower(filepath.Ext(filePath)) == ".zip" {
-			return walkFuncRecursive(filePath, info, f, walkFn, err)
+		if isTar(filePath) {
+			return walkTar(o, filePath, info, f, walkFn)
+		}
+		// info already holds the size zip.NewReader needs, so zip files are not stat'ed again
+		if isZip(filePath) {
+			if o.span != nil {
+				if end, _, err := readDirectoryEnd(f, info.Size()); err == nil && end.diskNbr > 0 {
+					joined, err := joinSpannedZip(f, info.Size(), end, o.span(filePath))
+					if err != nil {
+						return walkFn(filePath, info, nil, err)
+					}
+					return walkFuncRecursive(o, 1, filePath, spannedInfo{info, joined.Size()}, io.NewSectionReader(joined, 0, joined.Size()), walkFn, nil)
+				}
+			}
+			return walkFuncRecursive(o, 1, filePath, info, f, walkFn, err)
 		}
 		return walkFn(filePath, info, f, nil)
 	})
@@ -68,13 +135,46 @@ func Walk(root string, walkFn WalkFunc) error {
 type ZipFileInfo struct {
 	os.FileInfo
 	LastModified time.Time
+	LinkTarget   string // target of a symbolic link entry, see WithReportSymlinks
+	IsEntering   bool   // marks the call made before the entries of a zip file, see WithZipBoundaryEvents
+	IsLeaving    bool   // marks the call made after the entries of a zip file, see WithZipBoundaryEvents
 }
 
-// ModTime returns the date of the full parent zip file's modification time
+// ModTime returns the date of the full parent zip file's modification time, unless the entry
+// records its own modification time in the NTFS Extra Field, with sub-second precision, in
+// which case that time is returned in the location of LastModified.
 func (zfi ZipFileInfo) ModTime() time.Time {
+	if fh := zfi.ZipHeader(); fh != nil {
+		if t, ok := ntfsModTime(fh); ok {
+			return t.In(zfi.LastModified.Location())
+		}
+	}
 	return zfi.LastModified
 }
 
+// Headered is implemented by the os.FileInfo of entries inside zip files.
+type Headered interface {
+	ZipHeader() *zip.FileHeader
+}
+
+// ZipHeader returns the zip.FileHeader of the entry, or nil if the ZipFileInfo was not created
+// from a zip entry.
+func (zfi ZipFileInfo) ZipHeader() *zip.FileHeader {
+	fh, _ := zfi.FileInfo.Sys().(*zip.FileHeader)
+	return fh
+}
+
+// CompressionRatio returns how many times larger the entry is once decompressed.  It is 0 for
+// stored entries, empty entries and ZipFileInfos not created from a zip entry.  Unusually high
+// ratios point at zip bombs, which WithEntryValidator can reject before they are decompressed.
+func (zfi ZipFileInfo) CompressionRatio() float64 {
+	fh := zfi.ZipHeader()
+	if fh == nil || fh.Method == zip.Store || fh.CompressedSize64 == 0 {
+		return 0
+	}
+	return float64(fh.UncompressedSize64) / float64(fh.CompressedSize64)
+}
+
 // NewZipFileInfo creates an os.FileInfo from given last modified time and "parent" FileInfo
 func NewZipFileInfo(lm time.Time, info os.FileInfo) ZipFileInfo {
 	return ZipFileInfo{
@@ -83,116 +183,596 @@ func NewZipFileInfo(lm time.Time, info os.FileInfo) ZipFileInfo {
 	}
 }
 
-func walkFuncRecursive(filePath string, info os.FileInfo, content io.Reader, walkFn WalkFunc, err error) error {
+func walkFuncRecursive(o *options, depth int, filePath string, info os.FileInfo, content io.Reader, walkFn WalkFunc, err error) error {
 	if err != nil {
-		return fmt.Errorf("walkFuncRecursive received error when called for file %s - %v", filepath.Join(filePath, info.Name()), err)
+		return fmt.Errorf("walkFuncRecursive received error when called for file %s - %w", filePath, err)
 	}
 	err = walkFn(filePath, info, content, nil)
 	if err == SkipZip {
 		return nil
 	}
 	if err != nil {
-		return fmt.Errorf("walkFuncRecursive received error from walkFn for file %s - %v", filepath.Join(filePath, info.Name()), err)
+		return fmt.Errorf("walkFuncRecursive received error from walkFn for file %s - %w", filePath, err)
 	}
 	// is a zip file
-	zr, err := zip.NewReader(content.(io.ReaderAt), info.Size())
+	start := time.Now()
+	size := info.Size()
+	if sized, ok := content.(interface{ Size() int64 }); ok {
+		// nested zip files have been read into memory, and the size of what was read is the
+		// one to trust rather than the size recorded in the header of their entry
+		size = sized.Size()
+	}
+	ra := content.(io.ReaderAt)
+	if br, ok := content.(*bytes.Reader); ok {
+		// the entries are read through a reader of their own, leaving the one handed to walkFn
+		// to walkFn, even if it keeps using it after returning
+		ra = io.NewSectionReader(br, 0, br.Size())
+	}
+	var zr *zip.Reader
+	if _, real := content.(*os.File); real && o.cdCache != nil {
+		zr, err = newCachedZipReader(o, filePath, info, ra, size)
+	} else {
+		zr, err = newZipReader(ra, size)
+	}
+	if errors.Is(err, zip.ErrFormat) {
+		zr, err = openEmbeddedZip(ra, size)
+	}
 	if err != nil {
-		if strings.Contains(err.Error(), "zip: not a valid zip file") {
-			log.Printf("File %s is not a valid zip file - %v", filepath.Join(filePath, info.Name()), err)
+		if errors.Is(err, zip.ErrFormat) && o.reportInvalid {
+			return walkFn(filePath, info, nil, err)
+		}
+		if errors.Is(err, zip.ErrFormat) || errors.Is(err, errNoDirectoryEnd) {
+			log.Printf("File %s is not a valid zip file - %v", filePath, err)
 			return nil
 		}
-		return fmt.Errorf("walkFuncRecursive error reading file %s - %v", filepath.Join(filePath, info.Name()), err)
+		return fmt.Errorf("walkFuncRecursive error reading file %s - %v", filePath, err)
 		// return walkFn(filePath, info, nil, err)
 	}
-	for fileNum := range zr.File {
-		// if !f.FileHeader.IsEncrypted() {
-		f := zr.File[fileNum]
-		rdr, err := f.Open()
-		if err == nil {
-			err = func() error {
-				defer rdr.Close()
-				if strings.ToLower(filepath.Ext(f.Name)) == ".zip" {
-					insideContent, err := ioutil.ReadAll(rdr)
-					if err != nil {
-						if strings.Contains(err.Error(), "flate: corrupt input before offset") {
-							log.Printf("File %s is likely encrypted - %v", filepath.Join(filePath, f.Name), err)
-							return nil
-						}
-						if strings.Contains(err.Error(), "EOF") {
-							log.Printf("File %s error reading file, got unexpected EOF - %v", filepath.Join(filePath, f.Name), err)
-							return nil
-						}
-						return fmt.Errorf("Error reading file - %s - %v", filepath.Join(filePath, f.Name), err)
-					}
-					err = walkFuncRecursive(filepath.Join(filePath, f.Name), NewZipFileInfo(info.ModTime(), f.FileInfo()), bytes.NewReader(insideContent), walkFn, err)
-					if err != nil {
-						return fmt.Errorf("Received error from walkFuncRecursive - %s - %v", filepath.Join(filePath, f.Name), err)
-					}
-				} else {
-					err = walkFn(filepath.Join(filePath, f.Name), NewZipFileInfo(info.ModTime(), f.FileInfo()), rdr, err)
-					if err != nil {
-						if err == filepath.SkipDir {
-							return err
-						}
-						return fmt.Errorf("Received error from walkFn - %s - %v", filepath.Join(filePath, f.Name), err)
-					}
-				}
+	if o.onZipOpen != nil {
+		o.onZipOpen(filePath, len(zr.File))
+	}
+	if o.reportEmpty != nil && len(zr.File) == 0 {
+		o.reportEmpty(filePath)
+	}
+	if o.onZipClose != nil {
+		defer func() {
+			o.onZipClose(filePath, time.Since(start))
+		}()
+	}
+	if !o.boundaryEvents {
+		return walkZipEntries(o, depth, filePath, info, zr, walkFn)
+	}
+	err = walkFn(filePath, boundaryInfo(info, true), nil, nil)
+	if err == SkipZip {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("walkFuncRecursive received error from walkFn for file %s - %w", filePath, err)
+	}
+	if err := walkZipEntries(o, depth, filePath, info, zr, walkFn); err != nil {
+		return err
+	}
+	err = walkFn(filePath, boundaryInfo(info, false), nil, nil)
+	if err != nil && err != SkipZip {
+		return fmt.Errorf("walkFuncRecursive received error from walkFn for file %s - %w", filePath, err)
+	}
+	return nil
+}
+
+// boundaryInfo returns the os.FileInfo of the zip file info marked as entering or leaving it,
+// see WithZipBoundaryEvents.
+func boundaryInfo(info os.FileInfo, entering bool) ZipFileInfo {
+	zfi, ok := info.(ZipFileInfo)
+	if !ok {
+		zfi = NewZipFileInfo(info.ModTime(), info)
+	}
+	zfi.IsEntering, zfi.IsLeaving = entering, !entering
+	return zfi
+}
+
+// isBoundaryEvent reports whether info marks entering or leaving a zip file.
+func isBoundaryEvent(info os.FileInfo) bool {
+	zfi, ok := info.(ZipFileInfo)
+	return ok && (zfi.IsEntering || zfi.IsLeaving)
+}
+
+// teeRaw copies the compressed bytes of f to the WithTeeWriter writer and hands them to the
+// WithTee callback.
+func teeRaw(o *options, path string, f *zip.File) error {
+	raw, err := f.OpenRaw()
+	if err != nil {
+		return fmt.Errorf("Error opening raw file %s - %w", path, err)
+	}
+	var writers []io.Writer
+	if o.teeWriter != nil {
+		if w := o.teeWriter(path); w != nil {
+			writers = append(writers, w)
+		}
+	}
+	compressed := new(bytes.Buffer)
+	if o.tee != nil {
+		writers = append(writers, compressed)
+	}
+	if _, err := io.Copy(io.MultiWriter(writers...), raw); err != nil {
+		return fmt.Errorf("Error copying raw file %s - %w", path, err)
+	}
+	if o.tee != nil {
+		o.tee(path, compressed.Bytes())
+	}
+	return nil
+}
+
+// joinEntry builds the path reported for the zip entry name inside the zip file at filePath.
+func joinEntry(o *options, filePath, name string) string {
+	name = o.shortenName(name)
+	if isObjectURL(filePath) {
+		return "s3://" + o.pathResolver.Join(strings.TrimPrefix(filePath, "s3://"), name)
+	}
+	return o.pathResolver.Join(filePath, name)
+}
+
+// openEmbeddedZip reads a zip file that has been appended to other data, such as the stub of a
+// self-extracting archive, and whose offsets are relative to the start of the zip file rather
+// than the start of the data.  The start is found from the end of central directory record.
+func openEmbeddedZip(r io.ReaderAt, size int64) (*zip.Reader, error) {
+	end, endOffset, err := readDirectoryEnd(r, size)
+	if err != nil {
+		return nil, err
+	}
+	start := endOffset - int64(end.dirSize) - int64(end.dirOffset)
+	if start <= 0 || start >= endOffset {
+		return nil, zip.ErrFormat
+	}
+	return newZipReader(io.NewSectionReader(r, start, size-start), size-start)
+}
+
+// walkZipEntries calls walkFn for every entry of the zip file at filePath, descending into
+// the entries that are zip files themselves.  depth is the number of zip files that filePath
+// goes through, including itself.
+func walkZipEntries(o *options, depth int, filePath string, info os.FileInfo, zr *zip.Reader, walkFn WalkFunc) error {
+	var stats ZipStats
+	files := zipFiles(o, zr)
+	var pf *prefetcher
+	if o.concurrentRead > 1 {
+		pf = newPrefetcher(o, depth, filePath, files)
+		defer pf.stop()
+	}
+	for i, f := range files {
+		var pe *prefetchedEntry
+		if pf != nil {
+			pe = pf.next(i)
+		}
+		if o.skipEmpty && f.UncompressedSize64 == 0 && !f.Mode().IsDir() {
+			pf.release(pe)
+			stats.Empty++
+			continue
+		}
+		err := walkZipEntry(o, depth, filePath, info, f, pe, walkFn)
+		pf.release(pe)
+		if err != nil && err != filepath.SkipDir {
+			return err
+		}
+		stats.Entries++
+		stats.Bytes += int64(f.UncompressedSize64)
+		if err == filepath.SkipDir {
+			break
+		}
+	}
+	if o.onZipComplete != nil {
+		o.onZipComplete(filePath, stats)
+	}
+	return nil
+}
+
+// normalizeDOSEpoch replaces the times of the entry f, described by info, that are earlier than
+// the MS-DOS epoch with the sentinel set by WithNormalizeDOSEpochTimestamps: those in its header
+// and the time that info.ModTime returns.
+func normalizeDOSEpoch(o *options, info *ZipFileInfo, f *zip.File) {
+	fh := f.FileHeader
+	if fh.Modified.Before(dosEpoch) {
+		fh.Modified = o.dosEpoch
+	}
+	if t, ok := ntfsModTime(&fh); ok && t.Before(dosEpoch) {
+		fh.Extra = withNTFSModTime(fh.Extra, o.dosEpoch)
+	}
+	info.FileInfo = fh.FileInfo()
+	if info.LastModified.Before(dosEpoch) {
+		info.LastModified = o.dosEpoch
+	}
+}
+
+// errSnapshotMatch rejects the entries that WithSnapshot skips.
+var errSnapshotMatch = fmt.Errorf("zipwalk: entry matches the snapshot")
+
+// checkEntry returns the error that the options reject the entry f at entryPath with before it
+// is read, or nil if it is to be read.  It does not call walkFn, so that the entries read ahead
+// by WithConcurrentRead can be checked before they are read too.
+func checkEntry(o *options, depth int, entryPath string, f *zip.File) error {
+	if o.totalBytesExceeded() {
+		return ErrTotalBytesExceeded
+	}
+	if o.zipSlip && !isLocalName(f.Name) {
+		return ErrInsecurePath
+	}
+	if o.maxFileSize > 0 && f.UncompressedSize64 > uint64(o.maxFileSize) {
+		return ErrEntryTooLarge
+	}
+	if !o.shallow && isZip(f.Name) && o.maxDepth > 0 && depth >= o.maxDepth {
+		return ErrMaxDepthExceeded
+	}
+	if o.entryValidator != nil {
+		if err := o.entryValidator(entryPath, &f.FileHeader); err != nil {
+			return err
+		}
+	}
+	if o.snapshot != nil && o.snapshot.matches(entryPath, f) {
+		return errSnapshotMatch
+	}
+	return nil
+}
+
+// walkZipEntry calls walkFn for the single entry f of the zip file at filePath, descending into
+// it if it is a zip file itself.  pe is its content if it has been read ahead by
+// WithConcurrentRead, or nil.
+func walkZipEntry(o *options, depth int, filePath string, info os.FileInfo, f *zip.File, pe *prefetchedEntry, walkFn WalkFunc) error {
+	if o.skipPath(f.Name) {
+		return nil
+	}
+	entryPath := joinEntry(o, filePath, f.Name)
+	entryInfo := NewZipFileInfo(info.ModTime(), f.FileInfo())
+	if o.utc {
+		entryInfo.LastModified = entryInfo.LastModified.UTC()
+	}
+	if !o.dosEpoch.IsZero() {
+		normalizeDOSEpoch(o, &entryInfo, f)
+	}
+	var err error
+	if pe != nil {
+		err = pe.checkErr
+	} else {
+		err = checkEntry(o, depth, entryPath, f)
+	}
+	if err == nil && o.totalBytesExceeded() {
+		err = ErrTotalBytesExceeded
+	}
+	switch err {
+	case nil:
+	case ErrTotalBytesExceeded:
+		return err
+	case errSnapshotMatch:
+		o.snapshot.skipped(entryPath, entryInfo)
+		return nil
+	default:
+		return entryError(walkFn, entryPath, entryInfo, err)
+	}
+	if o.snapshot != nil {
+		o.snapshot.record(entryPath, f)
+	}
+	if o.tee != nil || o.teeWriter != nil {
+		if err := teeRaw(o, entryPath, f); err != nil {
+			return err
+		}
+	}
+	var rdr io.ReadCloser
+	if pe != nil {
+		rdr, err = pe.reader()
+	} else {
+		rdr, err = openEntry(o, entryPath, f)
+	}
+	if err != nil {
+		if err == ErrEncrypted || err == ErrWrongPassword {
+			return entryError(walkFn, entryPath, entryInfo, err)
+		}
+		if strings.Contains(err.Error(), "zip: unsupported") {
+			log.Printf("File %s is likely corrupted - %v", entryPath, err)
+			return nil
+		}
+		return fmt.Errorf("Error opening file %s - %v", entryPath, err)
+	}
+	defer rdr.Close()
+	if o.entryComment != nil && f.Comment != "" {
+		o.entryComment(entryPath, f.Comment)
+	}
+	content := io.Reader(rdr)
+	if pe == nil {
+		// entries read ahead were limited as they were read
+		content = o.throttle(o.limitReader(rdr))
+	}
+	if o.reportSymlinks && f.Mode()&os.ModeSymlink != 0 {
+		target, err := ioutil.ReadAll(content)
+		if err != nil {
+			return fmt.Errorf("Error reading file - %s - %w", entryPath, err)
+		}
+		entryInfo.LinkTarget = string(target)
+		return entryError(walkFn, entryPath, entryInfo, nil)
+	}
+	if !o.shallow && isZip(f.Name) {
+		insideContent, err := o.readAll(content)
+		defer o.releaseBytes(insideContent)
+		if err != nil {
+			if errors.Is(err, ErrEntryTooLarge) {
+				return entryError(walkFn, entryPath, entryInfo, err)
+			}
+			if strings.Contains(err.Error(), "flate: corrupt input before offset") {
+				log.Printf("File %s is likely encrypted - %v", entryPath, err)
+				return nil
+			}
+			if strings.Contains(err.Error(), "EOF") {
+				log.Printf("File %s error reading file, got unexpected EOF - %v", entryPath, err)
 				return nil
-			}()
-		} else { // err != nil
-			if strings.Contains(err.Error(), "zip: unsupported") {
-				log.Printf("File %s is likely corrupted - %v", filepath.Join(filePath, f.Name), err)
-			} else {
-				return fmt.Errorf("Error opening file %s - %v", filepath.Join(filePath, f.Name), err)
 			}
+			return fmt.Errorf("Error reading file - %s - %w", entryPath, err)
 		}
+		err = walkFuncRecursive(o, depth+1, entryPath, entryInfo, bytes.NewReader(insideContent), walkFn, err)
+		if err != nil {
+			return fmt.Errorf("Received error from walkFuncRecursive - %s - %w", entryPath, err)
+		}
+		return nil
+	}
+	err = walkFn(entryPath, entryInfo, o.bufferReader(content), err)
+	if err != nil {
+		if err == SkipZip {
+			// nothing to skip, as the entry is not walked into, e.g., a zip file with WithShallow
+			return nil
+		}
+		if err == filepath.SkipDir {
+			return err
+		}
+		return fmt.Errorf("Received error from walkFn - %s - %w", entryPath, err)
 	}
 	return nil
 }
 
+// entryError hands err to walkFn for a zip entry that is not going to be read.
+func entryError(walkFn WalkFunc, entryPath string, info os.FileInfo, err error) error {
+	err = walkFn(entryPath, info, nil, err)
+	if err != nil && err != filepath.SkipDir {
+		return fmt.Errorf("Received error from walkFn - %s - %w", entryPath, err)
+	}
+	return err
+}
+
 // Stat will get the status of files embedded in a zip path
 // e.g., file1.zip/file2.zip/a.txt
 func Stat(path string) (os.FileInfo, error) {
-	path = filepath.ToSlash(filepath.Clean(path))
-	firstZipLoc := strings.Index(strings.ToLower(filepath.ToSlash(path)), ".zip/")
-	if firstZipLoc == -1 {
+	zipPath, _ := splitZipPath(path)
+	if zipPath == "" {
 		return os.Stat(path)
 	}
-	curLoc := firstZipLoc + 4
-	firstZip, err := zip.OpenReader(path[:curLoc])
+	f, _, closer, err := findEntry(path)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+	return f.FileInfo(), nil
+}
+
+// FileExists reports whether path, which may be inside zip files, e.g., file1.zip/a.txt, names
+// a file rather than a directory.  Missing paths and directories report false without an
+// error.
+func FileExists(path string) (bool, error) {
+	info, err := Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return !info.IsDir(), nil
+}
+
+// IsDir reports whether path, which may be inside zip files, e.g., file1.zip/dir1, names a
+// directory.  Directories that are only implied by the names of the entries inside them count
+// as well.  Missing paths and files report false without an error.
+func IsDir(path string) (bool, error) {
+	info, err := Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		if chain := zipChain(path); len(chain) > 0 {
+			last := chain[len(chain)-1]
+			_, err := WalkDir(last, strings.TrimPrefix(filepath.ToSlash(filepath.Clean(path)), last+"/"))
+			return err == nil, nil
+		}
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return info.IsDir(), nil
+}
+
+// PathExists reports whether path, which may be inside zip files, names a file or a directory,
+// including implied directories like IsDir.  Missing paths report false without an error.
+func PathExists(path string) (bool, error) {
+	_, err := Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return IsDir(path)
+	}
+	return err == nil, err
+}
+
+// ErrNestedEntry is returned by LookupPath for entries of zip files that are themselves stored
+// inside zip files, whose bytes are not found at a fixed range of a real file.
+var ErrNestedEntry = fmt.Errorf("zipwalk: entry is inside a nested zip file")
+
+// LookupPath locates the compressed data of the entry at path, e.g., file1.zip/a.txt, within the
+// real zip file holding it, so that the bytes can be read or sent on without going through
+// zipwalk.  The data is the compressedSize bytes of file from compressedOffset on, compressed
+// with header.Method.  The caller must close file.  Entries of nested zip files report
+// ErrNestedEntry.
+func LookupPath(path string) (file *os.File, compressedOffset, compressedSize int64, header *zip.FileHeader, err error) {
+	zipPath, inner := splitZipPath(path)
+	if zipPath == "" {
+		return nil, 0, 0, nil, fmt.Errorf("path is not inside a zip file - %s", path)
+	}
+	if zipBoundary(inner) != -1 {
+		return nil, 0, 0, nil, fmt.Errorf("%w - %s", ErrNestedEntry, path)
+	}
+	f, _, closer, err := findEntry(path)
+	if err != nil {
+		return nil, 0, 0, nil, err
+	}
+	offset, err := f.DataOffset()
+	if err != nil {
+		closer.Close()
+		return nil, 0, 0, nil, fmt.Errorf("Error locating file %s - %w", path, err)
+	}
+	return closer.(*os.File), offset, int64(f.CompressedSize64), &f.FileHeader, nil
+}
+
+// findEntry locates the zip entry for a path that goes through at least one zip file and
+// returns it along with the os.FileInfo of the outermost zip file.  The returned closer
+// releases the outermost zip file and must be closed once the entry is no longer needed.
+func findEntry(path string) (*zip.File, os.FileInfo, io.Closer, error) {
+	zipPath, inner := splitZipPath(path)
+	if zipPath == "" {
+		return nil, nil, nil, fmt.Errorf("path is not inside a zip file - %s", path)
+	}
+	firstZip, outer, closer, err := openRealZip(zipPath)
 	if err != nil {
-		return nil, fmt.Errorf("error opening zip file - %s", path)
+		return nil, nil, nil, fmt.Errorf("error opening zip file - %s - %w", path, err)
 	}
-	defer firstZip.Close()
-	return statRecursive(&firstZip.Reader, path[curLoc+1:])
+	f, err := findRecursive(firstZip, inner)
+	if err != nil {
+		closer.Close()
+		return nil, nil, nil, err
+	}
+	return f, outer, closer, nil
 }
 
-func statRecursive(zf *zip.Reader, path string) (os.FileInfo, error) {
+// openRealZip opens a zip file on the filesystem.  The size given to zip.NewReader comes from
+// the same os.FileInfo that is returned, so the file is only stat'ed once.
+func openRealZip(path string) (*zip.Reader, os.FileInfo, io.Closer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, nil, err
+	}
+	zr, err := newZipReader(f, info.Size())
+	if err != nil {
+		f.Close()
+		return nil, nil, nil, err
+	}
+	return zr, info, f, nil
+}
+
+// zipExtensions lists the extensions of the files Walk treats as zip archives.
+var zipExtensions = []string{".zip", ".epub"}
+
+// isZip reports whether name has the extension of a file Walk treats as a zip archive.
+func isZip(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, zipExt := range zipExtensions {
+		if ext == zipExt {
+			return true
+		}
+	}
+	return false
+}
+
+// zipBoundary returns the index just past the name of the first zip file that the slash
+// separated path goes through, or -1 if it does not go through one.
+func zipBoundary(path string) int {
+	lower := strings.ToLower(path)
+	end := -1
+	for _, ext := range zipExtensions {
+		if loc := strings.Index(lower, ext+"/"); loc != -1 && (end == -1 || loc+len(ext) < end) {
+			end = loc + len(ext)
+		}
+	}
+	return end
+}
+
+// splitZipPath splits path at the first real zip file it goes through, returning the path of
+// that zip file and the remaining path inside it.  Directories whose names end in ".zip" are
+// not zip files and are passed over.  zipPath is empty if path does not go through a zip file.
+func splitZipPath(path string) (zipPath, inner string) {
+	path = filepath.ToSlash(filepath.Clean(path))
+	for start := 0; ; {
+		end := zipBoundary(path[start:])
+		if end == -1 {
+			return "", ""
+		}
+		end += start
+		if info, err := os.Stat(path[:end]); err != nil || !info.IsDir() {
+			return path[:end], path[end+1:]
+		}
+		start = end
+	}
+}
+
+// cleanEntryName returns the zip entry name as it appears at the end of the paths Walk builds
+// for it, without "./", duplicate slashes, or a leading or trailing slash.
+func cleanEntryName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+func findRecursive(zf *zip.Reader, path string) (*zip.File, error) {
 	fileToFind := path
-	nextZipLoc := strings.Index(strings.ToLower(filepath.ToSlash(path)), ".zip/")
+	nextZipLoc := zipBoundary(filepath.ToSlash(path))
 	if nextZipLoc != -1 {
-		fileToFind = path[:nextZipLoc+4]
+		fileToFind = path[:nextZipLoc]
 	}
 	for _, f := range zf.File {
-		if f.Name == fileToFind {
+		if cleanEntryName(f.Name) == fileToFind {
 			if nextZipLoc == -1 {
-				return f.FileInfo(), nil
+				return f, nil
 			}
 			fopen, err := f.Open()
 			if err != nil {
-				return nil, fmt.Errorf("Error opening the file we wanted to find - %s - %v", path, err)
+				return nil, fmt.Errorf("Error opening the file we wanted to find - %s - %w", path, err)
 			}
 			buf, err := ioutil.ReadAll(fopen)
 			fopen.Close()
 			if err != nil {
-				return nil, fmt.Errorf("Error reading zip file - %s - %v", path, err)
+				return nil, fmt.Errorf("Error reading zip file - %s - %w", path, err)
 			}
-			zr, err := zip.NewReader(bytes.NewReader(buf), int64(len(buf)))
+			zr, err := newZipReader(bytes.NewReader(buf), int64(len(buf)))
 			if err != nil {
-				return nil, fmt.Errorf("Error opening zip file - %s - %v", path, err)
+				return nil, fmt.Errorf("Error opening zip file - %s - %w", path, err)
 			}
-			return statRecursive(zr, path[len(fileToFind)+1:])
+			return findRecursive(zr, path[len(fileToFind)+1:])
 		}
 	}
 	return nil, os.ErrNotExist
 }
+
+// openZip opens the zip file at path, which may be a real file or an entry of another zip
+// file.  The returned os.FileInfo carries the modification time of the outermost real zip
+// file, matching what Walk reports for the same entries.
+func openZip(path string) (*zip.Reader, os.FileInfo, io.Closer, error) {
+	zipPath, _ := splitZipPath(path)
+	if zipPath == "" {
+		zr, info, closer, err := openRealZip(path)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("error opening zip file - %s - %w", path, err)
+		}
+		return zr, info, closer, nil
+	}
+	f, outer, closer, err := findEntry(path)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer closer.Close()
+	rdr, err := f.Open()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("Error opening zip file - %s - %w", path, err)
+	}
+	buf, err := ioutil.ReadAll(rdr)
+	rdr.Close()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("Error reading zip file - %s - %w", path, err)
+	}
+	zr, err := newZipReader(bytes.NewReader(buf), int64(len(buf)))
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("Error opening zip file - %s - %w", path, err)
+	}
+	return zr, NewZipFileInfo(outer.ModTime(), f.FileInfo()), nopCloser{}, nil
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
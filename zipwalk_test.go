@@ -1,15 +1,53 @@
 package zipwalk_test
 
 import (
+	"archive/tar"
+	"archive/zip"
 	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"crypto/aes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
 	"io"
+	"io/fs"
 	"io/ioutil"
+	"log/slog"
+	"math/big"
 	"os"
+	"path"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"testing/fstest"
+	"time"
+	"unicode/utf8"
 
 	"github.com/mzimmerman/zipwalk"
+	"github.com/mzimmerman/zipwalk/testutil"
+	"go.uber.org/goleak"
 )
 
 func TestOpen(t *testing.T) {
@@ -28,6 +66,8 @@ func TestOpen(t *testing.T) {
 		{"testdata/a.zip/b.zip/dir1.zip/dir1/dir1.txt", false},
 		{"testdata/dir2.zip", false},
 		{"testdata/dir2.zip/dir1/dir1.txt", false},
+		{"testdata/folder.zip", false},
+		{"testdata/folder.zip/c.txt", false},
 		{"test/a.txt", true},
 		{"testdata/b.zip", true},
 		{"testdata/a.zip/b.txt", true},
@@ -65,6 +105,10 @@ func TestWalk(t *testing.T) {
 		"testdata/a.zip":                              nil,
 		"testdata/dir2.zip/dir1/dir1.txt":             []byte("hi there"),
 		"testdata/dir2.zip":                           nil,
+		"testdata/folder.zip/c.txt":                   []byte("hi there"),
+		"testdata/lzma.zip":                           nil,
+		"testdata/lzma.zip/empty.txt":                 nil,
+		"testdata/lzma.zip/lorem.txt":                 nil,
 		"testdata/testme.zip":                         nil,
 		"testdata/zerobyte.zip":                       nil,
 	}
@@ -112,3 +156,4382 @@ func TestWalk(t *testing.T) {
 		t.Errorf("Expected path not traversed - %s", k)
 	}
 }
+
+func TestWalkN(t *testing.T) {
+	var all []string
+	err := zipwalk.WalkN("testdata", 1000, 0, func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		all = append(all, filepath.ToSlash(path))
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Error walking testdata - %v", err)
+	}
+	var paged []string
+	var token zipwalk.WalkToken
+	for page := 0; page < len(all); page++ {
+		var got []string
+		err := zipwalk.WalkN("testdata", 3, 0, func(path string, info os.FileInfo, reader io.Reader, err error) error {
+			got = append(got, filepath.ToSlash(path))
+			return err
+		}, zipwalk.Resume(token), zipwalk.SaveToken(&token))
+		if err != nil {
+			t.Fatalf("Error walking page %d - %v", page, err)
+		}
+		if len(got) == 0 {
+			break
+		}
+		if len(got) > 3 {
+			t.Errorf("Expected at most 3 entries on page %d, got %d", page, len(got))
+		}
+		paged = append(paged, got...)
+	}
+	if len(paged) != len(all) {
+		t.Fatalf("Expected %d paged entries, got %d", len(all), len(paged))
+	}
+	for i := range all {
+		if all[i] != paged[i] {
+			t.Errorf("Entry %d differs - %s vs %s", i, all[i], paged[i])
+		}
+	}
+	err = zipwalk.WalkN("testdata", 1, 0, func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		return err
+	}, zipwalk.Resume(zipwalk.WalkToken{Offset: 2, Path: "testdata/nope"}))
+	if !errors.Is(err, zipwalk.ErrStaleToken) {
+		t.Errorf("Expected ErrStaleToken, got %v", err)
+	}
+
+	// offsets and limits count the entries that the options let through to walkFn
+	var filtered []string
+	err = zipwalk.WalkCompat("testdata", func(path string, info os.FileInfo, err error) error {
+		filtered = append(filtered, filepath.ToSlash(path))
+		return err
+	}, zipwalk.WithExtensions(".txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	err = zipwalk.WalkN("testdata", 3, 2, func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		got = append(got, filepath.ToSlash(path))
+		return err
+	}, zipwalk.WithExtensions(".txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filtered) < 5 || !reflect.DeepEqual(got, filtered[2:5]) {
+		t.Errorf("Expected %v from offset 2 of %v, got %v", filtered[2:min(5, len(filtered))], filtered, got)
+	}
+}
+
+func TestWalkErrorInsideZip(t *testing.T) {
+	errStop := errors.New("stop")
+	var after []string
+	stopped := false
+	err := zipwalk.Walk("testdata/a.zip", func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		if err != nil {
+			return err
+		}
+		if stopped {
+			after = append(after, path)
+		}
+		if filepath.ToSlash(path) == "testdata/a.zip/a.txt" {
+			stopped = true
+			return errStop
+		}
+		return nil
+	})
+	if !errors.Is(err, errStop) {
+		t.Errorf("Expected the error returned inside the zip file, got %v", err)
+	}
+	if !stopped || len(after) != 0 {
+		t.Errorf("Expected the walk to stop at a.txt, got %v after it", after)
+	}
+}
+
+func TestGetMetadata(t *testing.T) {
+	md, err := zipwalk.GetMetadata("testdata/a.zip/b.zip/a.txt")
+	if err != nil {
+		t.Fatalf("Error getting metadata - %v", err)
+	}
+	if md.Name != "a.txt" || md.Size != uint64(len("hi there")) || md.CRC32 == 0 {
+		t.Errorf("Unexpected metadata - %+v", md)
+	}
+	if _, err := zipwalk.GetMetadata("testdata/a.txt"); err == nil {
+		t.Errorf("Expected error getting metadata for a file outside a zip")
+	}
+	if _, err := zipwalk.GetMetadata("testdata/a.zip/nope.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected os.ErrNotExist, got %v", err)
+	}
+}
+
+func TestWalkDirectoryNamedZip(t *testing.T) {
+	sawDir, sawFile := false, false
+	m := sync.Mutex{}
+	err := zipwalk.Walk("testdata/folder.zip", func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		if err != nil {
+			return err
+		}
+		m.Lock()
+		defer m.Unlock()
+		switch filepath.ToSlash(path) {
+		case "testdata/folder.zip":
+			sawDir = info.IsDir()
+		case "testdata/folder.zip/c.txt":
+			sawFile = !info.IsDir()
+		default:
+			t.Errorf("Got unexpected path - %s", path)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("Error walking directory named like a zip - %v", err)
+	}
+	if !sawDir || !sawFile {
+		t.Errorf("Expected the directory and its file to be walked, got dir=%v file=%v", sawDir, sawFile)
+	}
+	info, err := zipwalk.Stat("testdata/folder.zip/c.txt")
+	if err != nil {
+		t.Fatalf("Error calling Stat through a directory named like a zip - %v", err)
+	}
+	if info.Size() != int64(len("hi there")) {
+		t.Errorf("Expected size %d, got %d", len("hi there"), info.Size())
+	}
+}
+
+func TestWithEntryComment(t *testing.T) {
+	zipPath := filepath.Join(t.TempDir(), "comment.zip")
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	for _, fh := range []*zip.FileHeader{{Name: "a.txt", Comment: "sha1:abc"}, {Name: "b.txt"}} {
+		w, err := zw.CreateHeader(fh)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte("hi there"))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(zipPath, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	comments := map[string]string{}
+	var order []string
+	err := zipwalk.Walk(zipPath, func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		order = append(order, "walk:"+filepath.Base(path))
+		return err
+	}, zipwalk.WithEntryComment(func(path, comment string) {
+		order = append(order, "comment:"+filepath.Base(path))
+		comments[filepath.Base(path)] = comment
+	}))
+	if err != nil {
+		t.Fatalf("Error walking %s - %v", zipPath, err)
+	}
+	if len(comments) != 1 || comments["a.txt"] != "sha1:abc" {
+		t.Errorf("Unexpected comments - %v", comments)
+	}
+	expected := []string{"walk:comment.zip", "comment:a.txt", "walk:a.txt", "walk:b.txt"}
+	if strings.Join(order, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected call order %v, got %v", expected, order)
+	}
+}
+
+func TestWalkShallow(t *testing.T) {
+	var got []string
+	err := zipwalk.WalkShallow("testdata/a.zip/b.zip", func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		if err != nil {
+			return err
+		}
+		got = append(got, filepath.ToSlash(path))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error walking shallow - %v", err)
+	}
+	expected := []string{"testdata/a.zip/b.zip/a.txt", "testdata/a.zip/b.zip/dir1.zip"}
+	if strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+}
+
+func TestWithShallow(t *testing.T) {
+	var got []string
+	err := zipwalk.Walk("testdata/a.zip", func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		if err != nil {
+			return err
+		}
+		path = filepath.ToSlash(path)
+		got = append(got, path)
+		if path != "testdata/a.zip" && strings.HasSuffix(path, ".zip") {
+			// handed over as an opaque file, whose content is the nested zip file itself
+			content, err := ioutil.ReadAll(reader)
+			if err != nil {
+				return err
+			}
+			if !bytes.HasPrefix(content, []byte("PK\x03\x04")) {
+				t.Errorf("Expected the content of %s to be a zip file, got %q", path, content)
+			}
+		}
+		return nil
+	}, zipwalk.WithShallow())
+	if err != nil {
+		t.Fatalf("Error walking shallow - %v", err)
+	}
+	foundNested := false
+	for _, path := range got {
+		if strings.HasPrefix(path, "testdata/a.zip/b.zip/") {
+			t.Errorf("Expected nested zip files not to be descended into, got %s", path)
+		}
+		foundNested = foundNested || path == "testdata/a.zip/b.zip"
+	}
+	if !foundNested {
+		t.Errorf("Expected testdata/a.zip/b.zip to be walked, got %v", got)
+	}
+	shallow := got
+
+	got = nil
+	err = zipwalk.Walk("testdata/a.zip", func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		got = append(got, filepath.ToSlash(path))
+		if err != nil {
+			return err
+		}
+		return zipwalk.SkipZip
+	}, zipwalk.WithShallow())
+	if err != nil {
+		t.Fatalf("Error walking shallow with SkipZip - %v", err)
+	}
+	if len(got) != 1 || got[0] != "testdata/a.zip" {
+		t.Errorf("Expected SkipZip to skip the entries of the zip file, got %v", got)
+	}
+
+	// nested zip files are not walked into anyway, so SkipZip for them changes nothing
+	got = nil
+	err = zipwalk.Walk("testdata/a.zip", func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		got = append(got, filepath.ToSlash(path))
+		if err != nil || filepath.ToSlash(path) == "testdata/a.zip" {
+			return err
+		}
+		if strings.HasSuffix(path, ".zip") {
+			return zipwalk.SkipZip
+		}
+		return nil
+	}, zipwalk.WithShallow())
+	if err != nil {
+		t.Fatalf("Error walking shallow with SkipZip for nested zip files - %v", err)
+	}
+	if !reflect.DeepEqual(got, shallow) {
+		t.Errorf("Expected %v to be walked, got %v", shallow, got)
+	}
+}
+
+func TestEPUB(t *testing.T) {
+	epubPath := filepath.Join(t.TempDir(), "book.epub")
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	for _, entry := range []struct{ Name, Content string }{
+		{"mimetype", "application/epub+zip"},
+		{"META-INF/container.xml", `<?xml version="1.0"?><container xmlns="urn:oasis:names:tc:opendocument:xmlns:container" version="1.0"><rootfiles><rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/></rootfiles></container>`},
+		{"OEBPS/content.opf", `<?xml version="1.0"?><package xmlns="http://www.idpf.org/2007/opf" version="3.0"><manifest><item id="ch1" href="ch1.xhtml" media-type="application/xhtml+xml"/><item id="css" href="style.css" media-type="text/css"/></manifest></package>`},
+		{"OEBPS/ch1.xhtml", "<html/>"},
+	} {
+		w, err := zw.Create(entry.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(entry.Content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(epubPath, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	items, err := zipwalk.ParseEPUBManifest(epubPath)
+	if err != nil {
+		t.Fatalf("Error parsing manifest - %v", err)
+	}
+	if len(items) != 2 || items[0].ID != "ch1" || items[0].Href != "ch1.xhtml" || items[1].MediaType != "text/css" {
+		t.Errorf("Unexpected manifest - %+v", items)
+	}
+	found := false
+	err = zipwalk.Walk(epubPath, func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		if filepath.ToSlash(path) == filepath.ToSlash(epubPath)+"/OEBPS/ch1.xhtml" {
+			found = true
+		}
+		return err
+	})
+	if err != nil {
+		t.Errorf("Error walking epub - %v", err)
+	}
+	if !found {
+		t.Errorf("Expected Walk to descend into the epub")
+	}
+	if _, err := zipwalk.Stat(epubPath + "/OEBPS/ch1.xhtml"); err != nil {
+		t.Errorf("Error calling Stat inside epub - %v", err)
+	}
+}
+
+func BenchmarkWalk(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		err := zipwalk.Walk("testdata", func(path string, info os.FileInfo, reader io.Reader, err error) error {
+			return err
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// benchmarkZip returns the path of a zip file holding 1000 entries of 10KB each, stored inside
+// another zip file when nested is set.
+func benchmarkZip(b *testing.B, nested bool) string {
+	files := make(map[string][]byte, 1000)
+	for i := 0; i < 1000; i++ {
+		files[fmt.Sprintf("dir%d/%d.txt", i%10, i)] = bytes.Repeat([]byte(fmt.Sprintf("entry %d ", i)), 10*1024)[:10*1024]
+	}
+	if nested {
+		return testutil.NewTempZip(b, map[string][]byte{"inner.zip": testutil.ZipBytes(b, files)})
+	}
+	return testutil.NewTempZip(b, files)
+}
+
+func benchmarkWalkZip(b *testing.B, zipPath string) {
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := zipwalk.Walk(zipPath, func(path string, info os.FileInfo, reader io.Reader, err error) error {
+			if err != nil || info.IsDir() || strings.HasSuffix(path, ".zip") {
+				return err
+			}
+			_, err = io.Copy(ioutil.Discard, reader)
+			return err
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkWalkBuffered walks a zip file stored inside another zip file, which Walk reads into
+// memory before walking it.
+func BenchmarkWalkBuffered(b *testing.B) {
+	benchmarkWalkZip(b, benchmarkZip(b, true))
+}
+
+// BenchmarkWalkStreaming walks a zip file on the filesystem, whose entries are streamed to
+// walkFn.
+func BenchmarkWalkStreaming(b *testing.B) {
+	benchmarkWalkZip(b, benchmarkZip(b, false))
+}
+
+func TestCreateZipFromFS(t *testing.T) {
+	modTime := time.Date(2020, 1, 2, 3, 4, 6, 0, time.UTC)
+	src := fstest.MapFS{
+		"root/a.txt":     {Data: []byte("hi there"), ModTime: modTime},
+		"root/sub/b.txt": {Data: []byte("bye"), ModTime: modTime},
+		"other.txt":      {Data: []byte("skip me")},
+	}
+	buf := new(bytes.Buffer)
+	if err := zipwalk.CreateZipFromFS(buf, src, "root"); err != nil {
+		t.Fatalf("Error creating zip - %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("Error reading created zip - %v", err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+		if !f.FileInfo().IsDir() && !f.Modified.Equal(modTime) {
+			t.Errorf("Expected %s to be modified at %v, got %v", f.Name, modTime, f.Modified)
+		}
+	}
+	expected := []string{"a.txt", "sub/", "sub/b.txt"}
+	if strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected entries %v, got %v", expected, names)
+	}
+}
+
+func TestDeduplicateZip(t *testing.T) {
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "dup.zip"), filepath.Join(dir, "dedup.zip")
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	for _, entry := range []struct{ Name, Content string }{{"a.txt", "first"}, {"b.txt", "b"}, {"a.txt", "second"}, {"A.txt", "upper"}, {"a.txt", "third"}} {
+		w, err := zw.Create(entry.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(entry.Content))
+	}
+	zw.SetComment("keep me")
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(src, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	removed, err := zipwalk.DeduplicateZip(src, dst)
+	if err != nil {
+		t.Fatalf("Error deduplicating - %v", err)
+	}
+	if removed != 2 {
+		t.Errorf("Expected 2 duplicates removed, got %d", removed)
+	}
+	zr, err := zip.OpenReader(dst)
+	if err != nil {
+		t.Fatalf("Error opening deduplicated zip - %v", err)
+	}
+	defer zr.Close()
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	if strings.Join(names, ",") != "a.txt,b.txt,A.txt" || zr.Comment != "keep me" {
+		t.Errorf("Unexpected entries %v with comment %q", names, zr.Comment)
+	}
+	rdr, err := zr.File[0].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rdr.Close()
+	if got, _ := ioutil.ReadAll(rdr); string(got) != "first" {
+		t.Errorf("Expected the first a.txt to be kept, got %q", got)
+	}
+}
+
+func TestListDirs(t *testing.T) {
+	dirs, err := zipwalk.ListDirs("testdata/a.zip/b.zip/dir1.zip")
+	if err != nil {
+		t.Fatalf("Error listing dirs - %v", err)
+	}
+	expected := []string{"testdata/a.zip/b.zip/dir1.zip/dir1"}
+	if strings.Join(dirs, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected %v, got %v", expected, dirs)
+	}
+	zipPath := encryptedEntryZip(t)
+	dirs, err = zipwalk.ListDirs(zipPath)
+	if err != nil {
+		t.Fatalf("Error listing dirs with an encrypted entry - %v", err)
+	}
+	expected = []string{zipPath + "/dir", zipPath + "/dir/sub"}
+	if !reflect.DeepEqual(dirs, expected) {
+		t.Errorf("Expected %v, got %v", expected, dirs)
+	}
+}
+
+// encryptedEntryZip returns the path of a zip file holding dir/secret.zip, flagged as encrypted,
+// dir/sub/plain.txt and n.zip, a zip file holding c.txt.
+func encryptedEntryZip(t *testing.T) string {
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	secret := []byte("not really encrypted")
+	w, err := zw.CreateRaw(&zip.FileHeader{Name: "dir/secret.zip", Method: zip.Store, Flags: 0x1, CRC32: crc32.ChecksumIEEE(secret), CompressedSize64: uint64(len(secret)), UncompressedSize64: uint64(len(secret))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(secret)
+	nested := testutil.ZipBytes(t, map[string][]byte{"c.txt": []byte("c")})
+	for name, content := range map[string][]byte{"dir/sub/plain.txt": []byte("plain"), "n.zip": nested} {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(content)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zipPath := filepath.Join(t.TempDir(), "encrypted.zip")
+	if err := ioutil.WriteFile(zipPath, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return zipPath
+}
+
+type fakeS3 struct {
+	objects  map[string][]byte
+	fetched  int64
+	requests int
+}
+
+func (s *fakeS3) HeadObject(bucket, key string) (int64, error) {
+	obj, ok := s.objects[bucket+"/"+key]
+	if !ok {
+		return 0, os.ErrNotExist
+	}
+	return int64(len(obj)), nil
+}
+
+func (s *fakeS3) GetObject(bucket, key string, offset, length int64) (io.ReadCloser, error) {
+	obj, ok := s.objects[bucket+"/"+key]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	if offset+length > int64(len(obj)) {
+		length = int64(len(obj)) - offset
+	}
+	s.fetched += length
+	s.requests++
+	return ioutil.NopCloser(bytes.NewReader(obj[offset : offset+length])), nil
+}
+
+func TestWithObjectStorage(t *testing.T) {
+	obj, err := ioutil.ReadFile("testdata/dir2.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &fakeS3{objects: map[string][]byte{"bucket/builds/dir2.zip": obj}}
+	var got []string
+	err = zipwalk.Walk("s3://bucket/builds/dir2.zip", func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && path != "s3://bucket/builds/dir2.zip" {
+			content, err := ioutil.ReadAll(reader)
+			if err != nil || string(content) != "hi there" {
+				t.Errorf("Unexpected content for %s - %q %v", path, content, err)
+			}
+		}
+		got = append(got, filepath.ToSlash(path))
+		return nil
+	}, zipwalk.WithObjectStorage(client))
+	if err != nil {
+		t.Fatalf("Error walking object - %v", err)
+	}
+	if len(got) < 2 || got[0] != "s3://bucket/builds/dir2.zip" || got[len(got)-1] != "s3://bucket/builds/dir2.zip/dir1/dir1.txt" {
+		t.Errorf("Unexpected paths - %v", got)
+	}
+
+	// the many small reads of a zip file with many entries are served from a few blocks
+	files := map[string][]byte{}
+	for i := 0; i < 500; i++ {
+		files[fmt.Sprintf("f%03d.txt", i)] = bytes.Repeat([]byte("x"), 1000)
+	}
+	client = &fakeS3{objects: map[string][]byte{"bucket/many.zip": testutil.ZipBytes(t, files)}}
+	entries := 0
+	err = zipwalk.Walk("s3://bucket/many.zip", func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		if err != nil {
+			return err
+		}
+		entries++
+		_, err = io.Copy(ioutil.Discard, reader)
+		return err
+	}, zipwalk.WithObjectStorage(client))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blocks := len(client.objects["bucket/many.zip"])/(256<<10) + 1; entries != 501 || client.requests > blocks {
+		t.Errorf("Expected 501 paths from at most %d requests, got %d paths from %d requests", blocks, entries, client.requests)
+	}
+
+	err = zipwalk.Walk("s3://bucket", func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		t.Errorf("Expected walkFn not to be called for an invalid URL, got %s", path)
+		return err
+	}, zipwalk.WithObjectStorage(client))
+	if err == nil {
+		t.Errorf("Expected an error for an invalid URL")
+	}
+}
+
+type volumeList [][]byte
+
+func (v *volumeList) NextVolume() (io.ReaderAt, int64, error) {
+	if len(*v) == 0 {
+		return nil, 0, io.EOF
+	}
+	vol := (*v)[0]
+	*v = (*v)[1:]
+	return bytes.NewReader(vol), int64(len(vol)), nil
+}
+
+// spannedZip returns the two volumes of an archive of a.txt and b.txt, both holding content,
+// that has been split in front of b.txt.
+func spannedZip(t *testing.T, content string) (first, last []byte) {
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	for _, name := range []string{"a.txt", "b.txt"} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// split the archive in front of b.txt, making the offsets in the central directory
+	// relative to the volume each entry starts on like zip -s does
+	data := buf.Bytes()
+	eocd := data[len(data)-22:]
+	dirOffset := binary.LittleEndian.Uint32(eocd[16:])
+	dir := append([]byte(nil), data[dirOffset:len(data)-22]...)
+	secondRecord := 46 + int(binary.LittleEndian.Uint16(dir[28:]))
+	split := binary.LittleEndian.Uint32(dir[secondRecord+42:])
+	binary.LittleEndian.PutUint16(dir[secondRecord+34:], 1)
+	binary.LittleEndian.PutUint32(dir[secondRecord+42:], 0)
+	last = append([]byte(nil), data[split:dirOffset]...)
+	last = append(last, dir...)
+	end := append([]byte(nil), eocd...)
+	binary.LittleEndian.PutUint16(end[4:], 1)
+	binary.LittleEndian.PutUint16(end[6:], 1)
+	binary.LittleEndian.PutUint32(end[16:], dirOffset-split)
+	last = append(last, end...)
+	return data[:split], last
+}
+
+func TestWithSpanningSupportComment(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create("a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("hi there"))
+	// the end of central directory record of a last volume inside the comment, which does not
+	// end the data
+	if err := zw.SetComment("PK\x05\x06\x01" + strings.Repeat("\x00", 17) + " trailing"); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zipPath := filepath.Join(t.TempDir(), "comment.zip")
+	if err := ioutil.WriteFile(zipPath, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err = zipwalk.Walk(zipPath, func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		return err
+	}, zipwalk.WithSpanningSupport(func(path string) zipwalk.SpanReader {
+		t.Errorf("Expected %s not to be read as a spanned archive", path)
+		return &volumeList{}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWithSpanningSupport(t *testing.T) {
+	// two spanned archives in one tree, each of which needs its own earlier volume
+	dir := t.TempDir()
+	firsts := map[string][]byte{}
+	for _, name := range []string{"one", "two"} {
+		first, last := spannedZip(t, name)
+		zipPath := filepath.Join(dir, name+".zip")
+		if err := ioutil.WriteFile(zipPath, last, 0644); err != nil {
+			t.Fatal(err)
+		}
+		firsts[zipPath] = first
+	}
+	var mu sync.Mutex
+	var got []string
+	err := zipwalk.Walk(dir, func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || strings.HasSuffix(path, ".zip") {
+			return nil
+		}
+		content, err := ioutil.ReadAll(reader)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		got = append(got, strings.TrimPrefix(filepath.ToSlash(path), filepath.ToSlash(dir)+"/")+"="+string(content))
+		mu.Unlock()
+		return nil
+	}, zipwalk.WithSpanningSupport(func(path string) zipwalk.SpanReader {
+		return &volumeList{firsts[path]}
+	}))
+	if err != nil {
+		t.Fatalf("Error walking spanned zips - %v", err)
+	}
+	sort.Strings(got)
+	if expected := "one.zip/a.txt=one,one.zip/b.txt=one,two.zip/a.txt=two,two.zip/b.txt=two"; strings.Join(got, ",") != expected {
+		t.Errorf("Expected %s, got %v", expected, got)
+	}
+}
+
+func TestNewMultiVolumeReaderAt(t *testing.T) {
+	first, last := spannedZip(t, "hi there")
+	dir := t.TempDir()
+	volumes := []string{filepath.Join(dir, "spanned.z01"), filepath.Join(dir, "spanned.zip")}
+	for i, data := range [][]byte{first, last} {
+		if err := ioutil.WriteFile(volumes[i], data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ra, size, err := zipwalk.NewMultiVolumeReaderAt(volumes)
+	if err != nil {
+		t.Fatalf("Error opening volumes - %v", err)
+	}
+	defer ra.(io.Closer).Close()
+	// read across the boundary between the volumes
+	boundary := make([]byte, 8)
+	if _, err := ra.ReadAt(boundary, int64(len(first)-4)); err != nil {
+		t.Fatalf("Error reading across volumes - %v", err)
+	}
+	if want := append(append([]byte(nil), first[len(first)-4:]...), last[:4]...); !bytes.Equal(boundary, want) {
+		t.Errorf("Expected %x, got %x", want, boundary)
+	}
+	var got []string
+	err = zipwalk.WalkAt(ra, size, volumes[1], func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != volumes[1] {
+			content, err := ioutil.ReadAll(reader)
+			if err != nil || string(content) != "hi there" {
+				t.Errorf("Unexpected content for %s - %q %v", path, content, err)
+			}
+			got = append(got, filepath.Base(path))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error walking volumes - %v", err)
+	}
+	if strings.Join(got, ",") != "a.txt,b.txt" {
+		t.Errorf("Expected a.txt,b.txt, got %v", got)
+	}
+}
+
+func TestWithTee(t *testing.T) {
+	raw := map[string][]byte{}
+	err := zipwalk.Walk("testdata/dir2.zip", func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		return err
+	}, zipwalk.WithTee(func(path string, compressedData []byte) {
+		raw[filepath.ToSlash(path)] = compressedData
+	}))
+	if err != nil {
+		t.Fatalf("Error walking - %v", err)
+	}
+	md, err := zipwalk.GetMetadata("testdata/dir2.zip/dir1/dir1.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := raw["testdata/dir2.zip/dir1/dir1.txt"]; uint64(len(got)) != md.CompressedSize {
+		t.Errorf("Expected %d compressed bytes, got %d", md.CompressedSize, len(got))
+	}
+
+	streamed := map[string]*bytes.Buffer{}
+	err = zipwalk.Walk("testdata/dir2.zip", func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		return err
+	}, zipwalk.WithTeeWriter(func(path string) io.Writer {
+		streamed[filepath.ToSlash(path)] = new(bytes.Buffer)
+		return streamed[filepath.ToSlash(path)]
+	}))
+	if err != nil {
+		t.Fatalf("Error walking - %v", err)
+	}
+	if len(streamed) != len(raw) {
+		t.Errorf("Expected %d entries to be streamed, got %d", len(raw), len(streamed))
+	}
+	for path, buf := range streamed {
+		if !bytes.Equal(buf.Bytes(), raw[path]) {
+			t.Errorf("Expected the streamed bytes of %s to match WithTee", path)
+		}
+	}
+}
+
+func TestWithReverseOrder(t *testing.T) {
+	var forward, reverse []string
+	err := zipwalk.WalkN("testdata", 1000, 0, func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		forward = append(forward, filepath.ToSlash(path))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = zipwalk.Walk("testdata", func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		reverse = append(reverse, filepath.ToSlash(path))
+		return err
+	}, zipwalk.WithReverseOrder())
+	if err != nil {
+		t.Fatalf("Error walking in reverse - %v", err)
+	}
+	if len(forward) != len(reverse) {
+		t.Fatalf("Expected %d entries, got %d", len(forward), len(reverse))
+	}
+	if reverse[0] != "testdata" || reverse[1] != "testdata/zerobyte.zip" {
+		t.Errorf("Expected to start with testdata/zerobyte.zip, got %v", reverse[:2])
+	}
+	if i := indexOf(reverse, "testdata/a.zip/b.zip"); i == -1 || indexOf(reverse, "testdata/a.zip/a.txt") < i {
+		t.Errorf("Expected the entries of testdata/a.zip in reverse order - %v", reverse)
+	}
+}
+
+func indexOf(list []string, s string) int {
+	for i := range list {
+		if list[i] == s {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestStatAll(t *testing.T) {
+	infos, err := zipwalk.StatAll("testdata/a.zip")
+	if err != nil {
+		t.Fatalf("Error calling StatAll - %v", err)
+	}
+	var names []string
+	for _, info := range infos {
+		if _, ok := info.(zipwalk.ZipFileInfo); !ok {
+			t.Errorf("Expected a ZipFileInfo for %s, got %T", info.Name(), info)
+		}
+		names = append(names, info.Name())
+	}
+	for _, name := range []string{"a.txt", "b.zip", "dir1.zip", "dir1.txt"} {
+		if indexOf(names, name) == -1 {
+			t.Errorf("Expected %s in %v", name, names)
+		}
+	}
+}
+
+func TestWalkSelfExtractingZip(t *testing.T) {
+	archive, err := ioutil.ReadFile("testdata/dir2.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	zipPath := filepath.Join(t.TempDir(), "sfx.zip")
+	stub := bytes.Repeat([]byte("\x7fELF stub "), 100)
+	if err := ioutil.WriteFile(zipPath, append(stub, archive...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	err = zipwalk.Walk(zipPath, func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		if err != nil {
+			return err
+		}
+		if filepath.Base(path) == "dir1.txt" {
+			content, _ := ioutil.ReadAll(reader)
+			found = string(content) == "hi there"
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error walking self-extracting zip - %v", err)
+	}
+	if !found {
+		t.Errorf("Expected to read dir1.txt from the zip appended to the stub")
+	}
+}
+
+func TestWithReportInvalidZips(t *testing.T) {
+	archive, err := ioutil.ReadFile("testdata/dir2.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a central directory whose records have lost their signature
+	corrupt := bytes.ReplaceAll(archive, []byte("PK\x01\x02"), []byte("PK\x00\x00"))
+	zipPath := filepath.Join(t.TempDir(), "corrupt.zip")
+	if err := ioutil.WriteFile(zipPath, corrupt, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, report := range []bool{false, true} {
+		var opts []zipwalk.Option
+		if report {
+			opts = append(opts, zipwalk.WithReportInvalidZips())
+		}
+		var gotErr error
+		err := zipwalk.Walk(zipPath, func(path string, info os.FileInfo, reader io.Reader, err error) error {
+			if err != nil {
+				gotErr = err
+			}
+			return nil
+		}, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report != errors.Is(gotErr, zip.ErrFormat) {
+			t.Errorf("Expected zip.ErrFormat to be handed to walkFn only when reported, report %t got %v", report, gotErr)
+		}
+	}
+}
+
+type hashResolver struct{}
+
+func (hashResolver) Join(parts ...string) string {
+	return fmt.Sprintf("%s#%08x", parts[0], crc32.ChecksumIEEE([]byte(parts[len(parts)-1])))
+}
+
+func TestWithPathResolver(t *testing.T) {
+	var got []string
+	err := zipwalk.Walk("testdata/dir2.zip", func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		got = append(got, path)
+		return err
+	}, zipwalk.WithPathResolver(hashResolver{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := fmt.Sprintf("testdata/dir2.zip#%08x", crc32.ChecksumIEEE([]byte("dir1/dir1.txt")))
+	if indexOf(got, expected) == -1 {
+		t.Errorf("Expected %s in %v", expected, got)
+	}
+	if p := (zipwalk.ForwardSlashResolver{}).Join("a/b.zip", "c//d.txt"); p != "a/b.zip/c/d.txt" {
+		t.Errorf("Expected forward slashes, got %s", p)
+	}
+}
+
+func TestMirror(t *testing.T) {
+	dst := t.TempDir()
+	if err := zipwalk.Mirror("testdata/a.zip/b.zip", dst); err != nil {
+		t.Fatalf("Error mirroring - %v", err)
+	}
+	content, err := ioutil.ReadFile(filepath.Join(dst, "a.txt"))
+	if err != nil || string(content) != "hi there" {
+		t.Fatalf("Unexpected content of a.txt - %q %v", content, err)
+	}
+	md, err := zipwalk.GetMetadata("testdata/a.zip/b.zip/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(filepath.Join(dst, "a.txt")); err != nil || !info.ModTime().Equal(md.ModTime) {
+		t.Errorf("Expected modification time %v, got %v", md.ModTime, info.ModTime())
+	}
+	if _, err := os.Stat(filepath.Join(dst, "dir1.zip")); err != nil {
+		t.Errorf("Expected nested zip to be extracted as a file - %v", err)
+	}
+	var changes []string
+	report := zipwalk.WithDryRun(func(path string) {
+		changes = append(changes, path)
+	})
+	if err := zipwalk.Mirror("testdata/a.zip/b.zip", dst, report); err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("Expected no changes on an up to date mirror, got %v", changes)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dst, "a.txt"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := zipwalk.Mirror("testdata/a.zip/b.zip", dst, report); err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0] != filepath.Join(dst, "a.txt") {
+		t.Errorf("Expected a.txt to be reported, got %v", changes)
+	}
+	if content, _ := ioutil.ReadFile(filepath.Join(dst, "a.txt")); string(content) != "changed" {
+		t.Errorf("Expected a dry run to leave a.txt alone, got %q", content)
+	}
+}
+
+func TestWithOnZipOpenClose(t *testing.T) {
+	var events []string
+	err := zipwalk.Walk("testdata/a.zip", func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		return err
+	}, zipwalk.WithOnZipOpen(func(path string, entryCount int) {
+		events = append(events, fmt.Sprintf("open:%s:%d", filepath.ToSlash(path), entryCount))
+	}), zipwalk.WithOnZipClose(func(path string, duration time.Duration) {
+		events = append(events, "close:"+filepath.ToSlash(path))
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) == 0 || !strings.HasPrefix(events[0], "open:testdata/a.zip:") || events[len(events)-1] != "close:testdata/a.zip" {
+		t.Errorf("Expected the walk to be bracketed by testdata/a.zip events, got %v", events)
+	}
+	if indexOf(events, "close:testdata/a.zip/b.zip") == -1 {
+		t.Errorf("Expected events for nested zips, got %v", events)
+	}
+}
+
+func TestWalkLimits(t *testing.T) {
+	zipPath := filepath.Join(t.TempDir(), "limits.zip")
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	for _, entry := range []struct{ Name, Content string }{{"../evil.txt", "evil"}, {"big.txt", strings.Repeat("a", 1000)}, {"small.txt", "hi there"}} {
+		w, err := zw.Create(entry.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(entry.Content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(zipPath, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	errs := map[string]error{}
+	err := zipwalk.Walk(zipPath, func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		errs[filepath.Base(path)] = err
+		return nil
+	}, zipwalk.WithZipSlipProtection(), zipwalk.WithMaxFileSize(100))
+	if err != nil {
+		t.Fatalf("Error walking - %v", err)
+	}
+	if errs["evil.txt"] != zipwalk.ErrInsecurePath || errs["big.txt"] != zipwalk.ErrEntryTooLarge || errs["small.txt"] != nil {
+		t.Errorf("Unexpected errors - %v", errs)
+	}
+	err = zipwalk.Walk(zipPath, func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		if reader != nil {
+			_, err = ioutil.ReadAll(reader)
+		}
+		return err
+	}, zipwalk.WithMaxTotalBytes(500))
+	if !errors.Is(err, zipwalk.ErrTotalBytesExceeded) {
+		t.Errorf("Expected ErrTotalBytesExceeded, got %v", err)
+	}
+	var nested []string
+	err = zipwalk.Walk("testdata/a.zip", func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		nested = append(nested, filepath.ToSlash(path))
+		if filepath.Base(path) == "b.zip" && err != zipwalk.ErrMaxDepthExceeded {
+			t.Errorf("Expected ErrMaxDepthExceeded for %s, got %v", path, err)
+		}
+		return nil
+	}, zipwalk.WithMaxDepth(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if indexOf(nested, "testdata/a.zip/b.zip") == -1 || indexOf(nested, "testdata/a.zip/b.zip/a.txt") != -1 {
+		t.Errorf("Expected b.zip not to be descended into - %v", nested)
+	}
+	if err := zipwalk.SafeWalk("testdata", func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		return err
+	}); err != nil {
+		t.Errorf("Error walking testdata safely - %v", err)
+	}
+}
+
+func TestZipHeader(t *testing.T) {
+	var headers []*zip.FileHeader
+	err := zipwalk.Walk("testdata/dir2.zip", func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		if h, ok := info.(zipwalk.Headered); ok {
+			headers = append(headers, h.ZipHeader())
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(headers) == 0 {
+		t.Fatalf("Expected entries of dir2.zip to implement Headered")
+	}
+	last := headers[len(headers)-1]
+	if last == nil || last.Name != "dir1/dir1.txt" || last.CRC32 == 0 {
+		t.Errorf("Unexpected header - %+v", last)
+	}
+}
+
+func TestWithReportEmptyZips(t *testing.T) {
+	zipPath := filepath.Join(t.TempDir(), "empty.zip")
+	buf := new(bytes.Buffer)
+	if err := zip.NewWriter(buf).Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(zipPath, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var empty []string
+	report := zipwalk.WithReportEmptyZips(func(path string) {
+		empty = append(empty, path)
+	})
+	for _, root := range []string{zipPath, "testdata/a.zip"} {
+		err := zipwalk.Walk(root, func(path string, info os.FileInfo, reader io.Reader, err error) error {
+			return err
+		}, report)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(empty) != 1 || empty[0] != zipPath {
+		t.Errorf("Expected only %s to be reported, got %v", zipPath, empty)
+	}
+}
+
+func TestMirrorNameConflict(t *testing.T) {
+	zipPath := filepath.Join(t.TempDir(), "dup.zip")
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	for _, content := range []string{"first", "second"} {
+		w, err := zw.Create("a.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(zipPath, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		Action   zipwalk.ConflictAction
+		Expected map[string]string
+		Err      error
+	}{
+		{zipwalk.Overwrite, map[string]string{"a.txt": "second"}, nil},
+		{zipwalk.Skip, map[string]string{"a.txt": "first"}, nil},
+		{zipwalk.Rename, map[string]string{"a.txt": "first", "a (1).txt": "second"}, nil},
+		{zipwalk.Abort, map[string]string{"a.txt": "first"}, zipwalk.ErrNameConflict},
+	}
+	for _, test := range tests {
+		dst := t.TempDir()
+		err := zipwalk.Mirror(zipPath, dst, zipwalk.WithNameConflict(func(existing, incoming string) zipwalk.ConflictAction {
+			return test.Action
+		}))
+		if !errors.Is(err, test.Err) {
+			t.Errorf("Action %d - expected error %v, got %v", test.Action, test.Err, err)
+		}
+		for name, expected := range test.Expected {
+			if content, _ := ioutil.ReadFile(filepath.Join(dst, name)); string(content) != expected {
+				t.Errorf("Action %d - expected %s to hold %q, got %q", test.Action, name, expected, content)
+			}
+		}
+	}
+}
+
+func TestMirrorDirectories(t *testing.T) {
+	// writes a zip file of the given headers, each holding its name as content
+	write := func(headers ...*zip.FileHeader) string {
+		zipPath := filepath.Join(t.TempDir(), "mirror.zip")
+		buf := new(bytes.Buffer)
+		zw := zip.NewWriter(buf)
+		for _, fh := range headers {
+			w, err := zw.CreateHeader(fh)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasSuffix(fh.Name, "/") {
+				w.Write([]byte(fh.Name))
+			}
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(zipPath, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return zipPath
+	}
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	dirHeader := &zip.FileHeader{Name: "dir1/", Modified: modTime}
+	dirHeader.SetMode(os.ModeDir | 0750)
+	dst := t.TempDir()
+	if err := zipwalk.Mirror(write(dirHeader, &zip.FileHeader{Name: "dir1/a.txt"}), dst); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filepath.Join(dst, "dir1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0750 || !info.ModTime().Equal(modTime) {
+		t.Errorf("Expected dir1 to have mode 0750 and time %v, got %v and %v", modTime, info.Mode().Perm(), info.ModTime())
+	}
+
+	for _, names := range [][]string{{"a", "a/b.txt"}, {"a/b.txt", "a"}, {"a", "a/"}, {"a/", "a"}} {
+		var headers []*zip.FileHeader
+		for _, name := range names {
+			headers = append(headers, &zip.FileHeader{Name: name})
+		}
+		err := zipwalk.Mirror(write(headers...), t.TempDir(), zipwalk.WithNameConflict(func(existing, incoming string) zipwalk.ConflictAction {
+			return zipwalk.Overwrite
+		}))
+		if !errors.Is(err, zipwalk.ErrNameConflict) {
+			t.Errorf("Expected ErrNameConflict for %v, got %v", names, err)
+		}
+	}
+}
+
+func TestWalkFS(t *testing.T) {
+	var got []string
+	err := zipwalk.WalkFS(os.DirFS("testdata"), ".", func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		if err != nil {
+			return err
+		}
+		got = append(got, path)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error walking fs.FS - %v", err)
+	}
+	for _, expected := range []string{".", "a.txt", "a.zip/b.zip/dir1.zip/dir1/dir1.txt", "folder.zip/c.txt"} {
+		if indexOf(got, expected) == -1 {
+			t.Errorf("Expected %s in %v", expected, got)
+		}
+	}
+}
+
+func TestNewLineWalkFunc(t *testing.T) {
+	zipPath := filepath.Join(t.TempDir(), "logs.zip")
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	for _, entry := range []struct{ Name, Content string }{{"a.log", "one\ntwo\nstop\nnever\n"}, {"b.log", "three\n"}} {
+		w, err := zw.Create(entry.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(entry.Content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(zipPath, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	err := zipwalk.Walk(zipPath, zipwalk.NewLineWalkFunc(func(path, line string) error {
+		if line == "stop" {
+			return errors.New("stop")
+		}
+		lines = append(lines, filepath.Base(path)+":"+line)
+		return nil
+	}))
+	if err != nil {
+		t.Fatalf("Error walking lines - %v", err)
+	}
+	if strings.Join(lines, ",") != "a.log:one,a.log:two,b.log:three" {
+		t.Errorf("Unexpected lines - %v", lines)
+	}
+}
+
+func TestMatchesPath(t *testing.T) {
+	tests := []struct {
+		Candidate, Root string
+		Expected        bool
+	}{
+		{"a.zip/b/c.txt", "a.zip/b", true},
+		{"a.zip/b", "a.zip/b/", true},
+		{"a.zip/bar", "a.zip/b", false},
+		{"a.zip/b.zip/c.txt", "a.zip", true},
+		{"./a.zip//b/c.txt", "a.zip/b", true},
+		{"b.zip", "a.zip", false},
+		{"a.zip", ".", true},
+		{"../a.zip", ".", false},
+		{"/a.zip/b", "/", true},
+	}
+	for _, test := range tests {
+		if got := zipwalk.MatchesPath(test.Candidate, test.Root); got != test.Expected {
+			t.Errorf("MatchesPath(%q, %q) = %v, expected %v", test.Candidate, test.Root, got, test.Expected)
+		}
+	}
+}
+
+func TestWalkStreamsEntries(t *testing.T) {
+	zipPath := filepath.Join(t.TempDir(), "stream.zip")
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	for _, name := range []string{"a.txt", "b.txt"} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(bytes.Repeat([]byte(name), 100000))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(zipPath, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var previous io.Reader
+	err := zipwalk.Walk(zipPath, func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		if err != nil || path == zipPath {
+			return err
+		}
+		if _, ok := reader.(io.ReaderAt); ok {
+			t.Errorf("Expected %s to be streamed, got a buffered %T", path, reader)
+		}
+		if previous != nil {
+			if n, _ := previous.Read(make([]byte, 1)); n != 0 {
+				t.Errorf("Expected the reader of the previous entry to be exhausted before %s", path)
+			}
+		}
+		// read only part of the entry; the walk must still move on cleanly
+		if _, err := io.ReadFull(reader, make([]byte, 10)); err != nil {
+			return err
+		}
+		previous = reader
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error walking - %v", err)
+	}
+}
+
+func TestNoGoroutineLeak(t *testing.T) {
+	defer goleak.VerifyNone(t)
+	errStop := errors.New("stop")
+	scenarios := map[string]func() error{
+		"error on real file": func() error {
+			return zipwalk.Walk("testdata", func(path string, info os.FileInfo, reader io.Reader, err error) error {
+				if filepath.Base(path) == "a.txt" {
+					return errStop
+				}
+				return err
+			})
+		},
+		"error inside nested zip": func() error {
+			return zipwalk.Walk("testdata", func(path string, info os.FileInfo, reader io.Reader, err error) error {
+				if strings.HasSuffix(filepath.ToSlash(path), "b.zip/a.txt") {
+					return errStop
+				}
+				return err
+			})
+		},
+		"SkipDir inside zip": func() error {
+			return zipwalk.Walk("testdata", func(path string, info os.FileInfo, reader io.Reader, err error) error {
+				if strings.Contains(filepath.ToSlash(path), ".zip/") {
+					return zipwalk.SkipDir
+				}
+				return err
+			})
+		},
+		"WithConcurrentRead stopping early": func() error {
+			return zipwalk.Walk("testdata", func(path string, info os.FileInfo, reader io.Reader, err error) error {
+				if strings.HasSuffix(filepath.ToSlash(path), "a.zip/a.txt") {
+					return errStop
+				}
+				return err
+			}, zipwalk.WithConcurrentRead(4))
+		},
+		"WalkN stopping early": func() error {
+			return zipwalk.WalkN("testdata", 2, 1, func(path string, info os.FileInfo, reader io.Reader, err error) error {
+				return err
+			})
+		},
+		"WithContext cancelled mid-walk": func() error {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			return zipwalk.Walk("testdata", func(path string, info os.FileInfo, reader io.Reader, err error) error {
+				if strings.HasSuffix(filepath.ToSlash(path), "a.zip/a.txt") {
+					cancel()
+				}
+				return err
+			}, zipwalk.WithContext(ctx), zipwalk.WithConcurrentRead(4))
+		},
+		"WithTimeout expiring mid-walk": func() error {
+			return zipwalk.Walk("testdata", func(path string, info os.FileInfo, reader io.Reader, err error) error {
+				time.Sleep(5 * time.Millisecond)
+				return err
+			}, zipwalk.WithTimeout(10*time.Millisecond))
+		},
+		"Watcher stopped": func() error {
+			dir := t.TempDir()
+			if err := ioutil.WriteFile(filepath.Join(dir, "a.zip"), testutil.ZipBytes(t, map[string][]byte{"a.txt": []byte("a")}), 0644); err != nil {
+				return err
+			}
+			w, err := zipwalk.Watch(dir, func(path string, info os.FileInfo, reader io.Reader, err error) error {
+				return err
+			}, zipwalk.WithWatchInterval(time.Millisecond))
+			if err != nil {
+				return err
+			}
+			time.Sleep(10 * time.Millisecond)
+			return w.Stop()
+		},
+	}
+	for name, scenario := range scenarios {
+		err := scenario()
+		if err != nil && !errors.Is(err, errStop) && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("%s - unexpected error %v", name, err)
+		}
+	}
+}
+
+func TestEntryWriterTo(t *testing.T) {
+	for _, path := range []string{"testdata/a.txt", "testdata/a.zip/b.zip/dir1.zip/dir1/dir1.txt"} {
+		buf := new(bytes.Buffer)
+		n, err := zipwalk.EntryWriterTo{Path: path}.WriteTo(buf)
+		if err != nil {
+			t.Errorf("Error writing %s - %v", path, err)
+			continue
+		}
+		if n != int64(len("hi there")) || buf.String() != "hi there" {
+			t.Errorf("Unexpected content of %s - %d %q", path, n, buf.String())
+		}
+	}
+	if _, err := (zipwalk.EntryWriterTo{Path: "testdata/a.zip/nope.txt"}).WriteTo(ioutil.Discard); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected os.ErrNotExist, got %v", err)
+	}
+}
+
+func TestFileExists(t *testing.T) {
+	tests := []struct {
+		Name   string
+		Exists bool
+	}{
+		{"testdata/a.txt", true},
+		{"testdata/a.zip/a.txt", true},
+		{"testdata/a.zip/b.zip/dir1.zip/dir1/dir1.txt", true},
+		{"testdata", false},
+		{"testdata/folder.zip", false},
+		{"testdata/dir2.zip/dir1", false},
+		{"testdata/nope.txt", false},
+		{"testdata/a.zip/nope.txt", false},
+		{"testdata/a.zip/c.zip/a.txt", false},
+	}
+	for _, test := range tests {
+		exists, err := zipwalk.FileExists(test.Name)
+		if err != nil {
+			t.Errorf("Unexpected error for %s - %v", test.Name, err)
+		}
+		if exists != test.Exists {
+			t.Errorf("Expected %t for %s, got %t", test.Exists, test.Name, exists)
+		}
+	}
+}
+
+func TestIsDir(t *testing.T) {
+	implied := testutil.NewTempZip(t, map[string][]byte{"x/y/z.txt": []byte("z")})
+	tests := []struct {
+		Name   string
+		IsDir  bool
+		Exists bool
+	}{
+		{"testdata", true, true},
+		{"testdata/folder.zip", true, true},
+		{"testdata/dir2.zip/dir1", true, true},
+		{"testdata/a.zip/dir1.zip/dir1", true, true},
+		{"testdata/a.zip/b.zip/dir1.zip/dir1/", true, true},
+		{"testdata/a.txt", false, true},
+		{"testdata/a.zip", false, true},
+		{"testdata/a.zip/b.zip/dir1.zip/dir1/dir1.txt", false, true},
+		{"testdata/nope", false, false},
+		{"testdata/a.zip/nope", false, false},
+		{"testdata/a.zip/c.zip/dir1", false, false},
+		{implied + "/x", true, true},
+		{implied + "/x/y", true, true},
+		{implied + "/x/y/z.txt", false, true},
+		{implied + "/x/z", false, false},
+	}
+	for _, test := range tests {
+		isDir, err := zipwalk.IsDir(test.Name)
+		if err != nil {
+			t.Errorf("Unexpected error for %s - %v", test.Name, err)
+		}
+		if isDir != test.IsDir {
+			t.Errorf("Expected IsDir %t for %s, got %t", test.IsDir, test.Name, isDir)
+		}
+		exists, err := zipwalk.PathExists(test.Name)
+		if err != nil {
+			t.Errorf("Unexpected error for %s - %v", test.Name, err)
+		}
+		if exists != test.Exists {
+			t.Errorf("Expected PathExists %t for %s, got %t", test.Exists, test.Name, exists)
+		}
+	}
+}
+
+func TestWithAuditLog(t *testing.T) {
+	buf := new(bytes.Buffer)
+	var walked []string
+	m := sync.Mutex{}
+	err := zipwalk.Walk("testdata/a.zip", func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		m.Lock()
+		walked = append(walked, filepath.ToSlash(path))
+		m.Unlock()
+		if filepath.Base(path) == "dir1.zip" {
+			return errors.New("audited anyway")
+		}
+		return nil
+	}, zipwalk.WithAuditLog(buf))
+	if err == nil {
+		t.Fatalf("Expected the error from walkFn to be returned")
+	}
+	dec := json.NewDecoder(buf)
+	var logged []string
+	for {
+		var rec struct {
+			Path    string    `json:"path"`
+			Size    int64     `json:"size"`
+			ModTime time.Time `json:"mod_time"`
+			IsDir   bool      `json:"is_dir"`
+		}
+		if err := dec.Decode(&rec); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Error decoding audit log - %v", err)
+		}
+		if rec.Path == "testdata/a.zip/a.txt" && rec.Size != int64(len("hi there")) {
+			t.Errorf("Expected size %d for %s, got %d", len("hi there"), rec.Path, rec.Size)
+		}
+		logged = append(logged, filepath.ToSlash(rec.Path))
+	}
+	if strings.Join(logged, ",") != strings.Join(walked, ",") {
+		t.Errorf("Expected audit log of %v, got %v", walked, logged)
+	}
+}
+
+func TestNewTempZip(t *testing.T) {
+	zipPath := testutil.NewTempZip(t, map[string][]byte{
+		"a.txt": []byte("hi there"),
+		"b.zip": testutil.ZipBytes(t, map[string][]byte{"dir1/c.txt": []byte("hi there")}),
+	})
+	var got []string
+	err := zipwalk.Walk(zipPath, func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		if err != nil {
+			return err
+		}
+		rel := strings.TrimPrefix(filepath.ToSlash(path), filepath.ToSlash(zipPath))
+		if strings.HasSuffix(rel, ".txt") {
+			content, err := ioutil.ReadAll(reader)
+			if err != nil || string(content) != "hi there" {
+				t.Errorf("Unexpected content for %s - %q %v", path, content, err)
+			}
+		}
+		got = append(got, rel)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error walking temporary zip - %v", err)
+	}
+	if strings.Join(got, ",") != ",/a.txt,/b.zip,/b.zip/dir1/c.txt" {
+		t.Errorf("Unexpected paths - %v", got)
+	}
+}
+
+func TestWalkIO(t *testing.T) {
+	zipData, err := ioutil.ReadFile("testdata/a.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gzipped := new(bytes.Buffer)
+	gw := gzip.NewWriter(gzipped)
+	gw.Write(zipData)
+	gw.Close()
+	tests := []struct {
+		Name     string
+		Data     []byte
+		Expected []string
+	}{
+		{"download", zipData, []string{"download", "download/a.txt", "download/b.zip", "download/b.zip/a.txt"}},
+		{"download.gz", gzipped.Bytes(), []string{"download", "download/a.txt", "download/b.zip", "download/b.zip/a.txt"}},
+		{"plain.zip", []byte("hi there"), []string{"plain.zip"}},
+	}
+	for _, test := range tests {
+		var got []string
+		err := zipwalk.WalkIO(bytes.NewReader(test.Data), int64(len(test.Data)), test.Name, func(path string, info os.FileInfo, reader io.Reader, err error) error {
+			if err != nil {
+				return err
+			}
+			got = append(got, filepath.ToSlash(path))
+			return nil
+		})
+		if err != nil {
+			t.Errorf("Error walking %s - %v", test.Name, err)
+		}
+		for _, path := range test.Expected {
+			if indexOf(got, path) == -1 {
+				t.Errorf("Expected %s to be walked for %s, got %v", path, test.Name, got)
+			}
+		}
+	}
+}
+
+func TestCopyEntry(t *testing.T) {
+	dir := t.TempDir()
+	deflated := filepath.Join(dir, "deflated.zip")
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "b.txt", Method: zip.Deflate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("bye"))
+	zw.SetComment("keep me")
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(deflated, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(deflated, 0640); err != nil {
+		t.Fatal(err)
+	}
+	src := "testdata/a.zip/b.zip/a.txt"
+	srcMethod, err := zipwalk.GetMetadata(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		Dst    string
+		Method uint16
+	}{
+		{filepath.Join(dir, "new.zip", "dir1", "a.txt"), srcMethod.CompressionMethod},
+		{filepath.Join(deflated, "a.txt"), zip.Deflate},
+	}
+	for _, test := range tests {
+		if err := zipwalk.CopyEntry(src, test.Dst); err != nil {
+			t.Fatalf("Error copying to %s - %v", test.Dst, err)
+		}
+		md, err := zipwalk.GetMetadata(test.Dst)
+		if err != nil {
+			t.Fatalf("Error getting metadata of %s - %v", test.Dst, err)
+		}
+		if md.CompressionMethod != test.Method {
+			t.Errorf("Expected method %d for %s, got %d", test.Method, test.Dst, md.CompressionMethod)
+		}
+		content := new(bytes.Buffer)
+		if _, err := (zipwalk.EntryWriterTo{Path: test.Dst}).WriteTo(content); err != nil || content.String() != "hi there" {
+			t.Errorf("Unexpected content of %s - %q %v", test.Dst, content, err)
+		}
+	}
+	if exists, err := zipwalk.FileExists(filepath.Join(deflated, "b.txt")); !exists || err != nil {
+		t.Errorf("Expected the existing entry to be kept - %v", err)
+	}
+	if info, err := os.Stat(deflated); err != nil || info.Mode().Perm() != 0640 {
+		t.Errorf("Expected the permissions of the existing zip file to be kept, got %v - %v", info.Mode(), err)
+	}
+	// a new zip file gets the permissions of any new file, 0666 less the umask
+	probe, err := os.OpenFile(filepath.Join(dir, "probe"), os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	probe.Close()
+	want, err := os.Stat(probe.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(filepath.Join(dir, "new.zip")); err != nil || info.Mode().Perm() != want.Mode().Perm() {
+		t.Errorf("Expected a new zip file to have permissions %v, got %v - %v", want.Mode().Perm(), info.Mode(), err)
+	}
+	zr, err := zip.OpenReader(deflated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	if zr.Comment != "keep me" {
+		t.Errorf("Expected the comment of the existing zip file to be kept, got %q", zr.Comment)
+	}
+}
+
+// signedJar returns a jar holding a.txt, signed by a self-signed certificate, along with the
+// certificate.  tamper changes the content of a.txt after it has been signed.  noDigest signs
+// authenticated attributes that leave out the digest of the signature file, so that the
+// signature file is not signed at all.
+func signedJar(t *testing.T, tamper, noDigest bool) ([]byte, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "zipwalk test"}, NotBefore: time.Now(), NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := func(data []byte) string {
+		sum := sha256.Sum256(data)
+		return base64.StdEncoding.EncodeToString(sum[:])
+	}
+	content := []byte("hi there")
+	manifest := []byte("Manifest-Version: 1.0\r\n\r\nName: a.txt\r\nSHA-256-Digest: " + digest(content) + "\r\n\r\n")
+	sf := []byte("Signature-Version: 1.0\r\nSHA-256-Digest-Manifest: " + digest(manifest) + "\r\n\r\n")
+	sum := sha256.Sum256(sf)
+	var attrs asn1.RawValue
+	if noDigest {
+		signingTime, err := asn1.Marshal(time.Now().UTC())
+		if err != nil {
+			t.Fatal(err)
+		}
+		set, err := asn1.Marshal([]struct {
+			Type  asn1.ObjectIdentifier
+			Value asn1.RawValue
+		}{{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}, asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: signingTime}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		set[0] = 0x31 // SET OF rather than SEQUENCE OF, as the attributes are signed
+		sum = sha256.Sum256(set)
+		// and stored with the [0] tag
+		attrs = asn1.RawValue{FullBytes: append([]byte{0xa0}, set[1:]...)}
+	}
+	sig, err := ecdsa.SignASN1(rand.Reader, key, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sha256Alg := pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}}
+	type signerInfo struct {
+		Version int
+		Issuer  struct {
+			Name   asn1.RawValue
+			Serial *big.Int
+		}
+		DigestAlgorithm    pkix.AlgorithmIdentifier
+		Attributes         asn1.RawValue `asn1:"optional"`
+		SignatureAlgorithm pkix.AlgorithmIdentifier
+		Signature          []byte
+	}
+	si := signerInfo{Version: 1, DigestAlgorithm: sha256Alg, Attributes: attrs, SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}}, Signature: sig}
+	si.Issuer.Name = asn1.RawValue{FullBytes: cert.RawIssuer}
+	si.Issuer.Serial = cert.SerialNumber
+	signedData, err := asn1.Marshal(struct {
+		Version          int
+		DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+		ContentInfo      struct{ ContentType asn1.ObjectIdentifier }
+		Certificates     asn1.RawValue
+		SignerInfos      []signerInfo `asn1:"set"`
+	}{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{sha256Alg},
+		ContentInfo:      struct{ ContentType asn1.ObjectIdentifier }{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: cert.Raw},
+		SignerInfos:      []signerInfo{si},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := asn1.Marshal(struct {
+		ContentType asn1.ObjectIdentifier
+		Content     asn1.RawValue
+	}{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signedData}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tamper {
+		content = []byte("bye there")
+	}
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	for _, entry := range []struct {
+		Name    string
+		Content []byte
+	}{{"META-INF/MANIFEST.MF", manifest}, {"META-INF/TEST.SF", sf}, {"META-INF/TEST.EC", block}, {"a.txt", content}} {
+		w, err := zw.Create(entry.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(entry.Content)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes(), cert
+}
+
+// dsaSignatureBlock signs the signature file of signedJar with a DSA key, as older jarsigner
+// versions did by default, as made by
+//
+//	openssl cms -sign -binary -md sha256 -nosmimecap -outform DER -signer cert.pem -inkey key.pem
+//
+// for a 2048 bit key and a self-signed certificate for "zipwalk dsa test".
+const dsaSignatureBlock = "" +
+	"MIIFsQYJKoZIhvcNAQcCoIIFojCCBZ4CAQExDTALBglghkgBZQMEAgEwCwYJKoZIhvcNAQcBoIIEdjCCBHIwggQgoAMC" +
+	"AQICFCgsE+V9/q1Bo/zD/uDI3jcumT4sMAsGCWCGSAFlAwQDAjAbMRkwFwYDVQQDDBB6aXB3YWxrIGRzYSB0ZXN0MCAX" +
+	"DTI2MTAxNjExMjEzMloYDzIxMjYwOTIyMTEyMTMyWjAbMRkwFwYDVQQDDBB6aXB3YWxrIGRzYSB0ZXN0MIIDQzCCAjUG" +
+	"ByqGSM44BAEwggIoAoIBAQDu1lXFoh4jxEy8xAOFZwr7rhy4snSoDROrcyF/mGLcMkEFTWgXtDz6vX4RN7MRtMCxkAEy" +
+	"4pG4N/zwL86cjesIfUMZrLyTL1snX2fDmqKwOSSKGdHVR77+QTeDpscyxAiQfT79/c79FzYMcte2wrQio8/a1AmKAdTz" +
+	"hgbU/ucnB2s1hu/1KCjOaPsz5KUFsEaV0W3dT5fAUG5Ife0ZZ/+z0ic5ca6AXadtZ0QPomnwo25uc+HcHkKhnoqWcmDA" +
+	"8gozMGRuB/a1W8D1uQ7QXBigeEmLmhfWY8tz9nV+bRhfTESrFlQWqnK1UKLBL9xdoBSapoBdsu7w8UamoZ5E1khfAh0A" +
+	"0GN13vH0sqOpsq8+8vKi/NniWkNIZiRLOcMAZQKCAQBtjj14a+wCdR/yxDuTJkPZEt0DljE980ZeGXhtgmVuIPFpLii5" +
+	"MaAuCyBbzFSPpB8ud6aHAfUVEVFPBD9Y2AuH4D1XfrDckFcMshXQqSck0PABbI5STAYp3Xg1HaAZfGqQ1Xb6ClA8D3dr" +
+	"r9QSIL1sd7S3Hm0tGx1PsYT741Pq/U5Ocr17jKWRasBjNq908CZL7pagEeAeVd1kaTKIDlq6wCVXdqUP7HSkjYRXV/5w" +
+	"58oz+Dn8uVjM1+GObpx9hwYrDVj2OBO1ebgxOkBmK25OqwyBPm1kE+y+/b/0ZYf8TYMjW/kNq+kAWU8e+hi+PrLM6AAo" +
+	"8WZNArBGJXxpnzK2A4IBBgACggEBAIWYLDm+q2IoCJQnb/MNzZHpLqR0Xspzp5T+3e49MZf/MreHkzK8W7YmOP7FZLZK" +
+	"aV2oHupAAu6p85ggG97Ze56fvsrA54CMiaTg+kVFs6j81FHqzRIYkomA0y7pbhNRFhGmgFR3JjCez40B9ApguAVViwJd" +
+	"rBcSorI4AazBckzJVjg1UK4cCxQWwywhTgkqtKcdyrCrS0UfhBPY3HhXx8avJyrbGz5fEWkei1LdhXQWW1htBcJgf8Jy" +
+	"Ql0J2KPB/+MbETgmwy4Oao8YwdqrOeeZf7NreAZtnEWxvbNEZ1ac62J8xRD98Ap1DS9gOCKw7I2pWHG3x4OcWdH46B7p" +
+	"zqejUzBRMB0GA1UdDgQWBBQ089Ovf/t4Rcdo989LZgh7BRlrpjAfBgNVHSMEGDAWgBQ089Ovf/t4Rcdo989LZgh7BRlr" +
+	"pjAPBgNVHRMBAf8EBTADAQH/MAsGCWCGSAFlAwQDAgM/ADA8AhwQQXhgb/IRTiGJ1XITZRnt3Hk5gpxAYbJSj8rAAhxj" +
+	"3IzXIQ/XcFzkfHEMNIhixpHdt2g7SuA9O0qhMYIBATCB/gIBATAzMBsxGTAXBgNVBAMMEHppcHdhbGsgZHNhIHRlc3QC" +
+	"FCgsE+V9/q1Bo/zD/uDI3jcumT4sMAsGCWCGSAFlAwQCAaBpMBgGCSqGSIb3DQEJAzELBgkqhkiG9w0BBwEwHAYJKoZI" +
+	"hvcNAQkFMQ8XDTI2MTAxNjExMjEzMlowLwYJKoZIhvcNAQkEMSIEIMQKwrieHxWjlk5J+9ORCM4Jd9C21pPa4xH8sbUI" +
+	"zw33MAsGCWCGSAFlAwQDAgQ/MD0CHQDAQD7arlOcXWPZ7fLsUdnCcd3A7NQd12UGaVaJAhxyuT42nE2tkaQppMIzTsVQ" +
+	"qqRUAYe/ys2gohrP"
+
+func TestVerifySignature(t *testing.T) {
+	dir := t.TempDir()
+	for _, tamper := range []bool{false, true} {
+		jar, cert := signedJar(t, tamper, false)
+		jarPath := filepath.Join(dir, fmt.Sprintf("signed-%t.jar", tamper))
+		if err := ioutil.WriteFile(jarPath, jar, 0644); err != nil {
+			t.Fatal(err)
+		}
+		result, err := zipwalk.VerifySignature(jarPath)
+		if err != nil {
+			t.Fatalf("Error verifying %s - %v", jarPath, err)
+		}
+		if result.Valid == tamper {
+			t.Errorf("Expected valid to be %t for %s", !tamper, jarPath)
+		}
+		if result.SignerName != "zipwalk test" || len(result.CertChain) != 1 || !result.CertChain[0].Equal(cert) {
+			t.Errorf("Unexpected signer for %s - %+v", jarPath, result)
+		}
+	}
+	jarPath := filepath.Join(dir, "no-digest.jar")
+	jar, _ := signedJar(t, false, true)
+	if err := ioutil.WriteFile(jarPath, jar, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if result, err := zipwalk.VerifySignature(jarPath); err != nil || result.Valid {
+		t.Errorf("Expected a signature over attributes without a message digest to be invalid, got %+v - %v", result, err)
+	}
+	for _, tamper := range []bool{false, true} {
+		jar, _ := signedJar(t, tamper, false)
+		zr, err := zip.NewReader(bytes.NewReader(jar), int64(len(jar)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		block, err := base64.StdEncoding.DecodeString(dsaSignatureBlock)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files := map[string][]byte{"META-INF/TEST.DSA": block}
+		for _, f := range zr.File {
+			if f.Name == "META-INF/TEST.EC" {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			files[f.Name], err = ioutil.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		jarPath := filepath.Join(dir, fmt.Sprintf("signed-dsa-%t.jar", tamper))
+		if err := ioutil.WriteFile(jarPath, testutil.ZipBytes(t, files), 0644); err != nil {
+			t.Fatal(err)
+		}
+		result, err := zipwalk.VerifySignature(jarPath)
+		if err != nil || result.Valid == tamper || result.SignerName != "zipwalk dsa test" {
+			t.Errorf("Expected valid to be %t for the DSA signature of %s, got %+v - %v", !tamper, jarPath, result, err)
+		}
+	}
+	if _, err := zipwalk.VerifySignature("testdata/a.zip"); err != zipwalk.ErrNotSigned {
+		t.Errorf("Expected ErrNotSigned, got %v", err)
+	}
+}
+
+func TestWalkTar(t *testing.T) {
+	zipData, err := ioutil.ReadFile("testdata/a.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	for _, name := range []string{"archive.tar", "archive.tgz"} {
+		buf := new(bytes.Buffer)
+		var w io.Writer = buf
+		gw := gzip.NewWriter(buf)
+		if name == "archive.tgz" {
+			w = gw
+		}
+		tw := tar.NewWriter(w)
+		for _, entry := range []struct {
+			Name    string
+			Content []byte
+		}{{"lib/a.txt", []byte("hi there")}, {"lib/inner.zip", zipData}} {
+			if err := tw.WriteHeader(&tar.Header{Name: entry.Name, Mode: 0644, Size: int64(len(entry.Content)), Typeflag: tar.TypeReg}); err != nil {
+				t.Fatal(err)
+			}
+			tw.Write(entry.Content)
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		gw.Close()
+		if err := ioutil.WriteFile(filepath.Join(dir, name), buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var got []string
+	m := sync.Mutex{}
+	err = zipwalk.Walk(dir, func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		if err != nil {
+			return err
+		}
+		rel := strings.TrimPrefix(filepath.ToSlash(path), filepath.ToSlash(dir)+"/")
+		if rel == "archive.tar/lib/a.txt" || rel == "archive.tgz/lib/inner.zip/b.zip/a.txt" {
+			content, err := ioutil.ReadAll(reader)
+			if err != nil || string(content) != "hi there" {
+				t.Errorf("Unexpected content for %s - %q %v", path, content, err)
+			}
+		}
+		m.Lock()
+		got = append(got, rel)
+		m.Unlock()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error walking tar archives - %v", err)
+	}
+	for _, archive := range []string{"archive.tar", "archive.tgz"} {
+		for _, path := range []string{"", "/lib/a.txt", "/lib/inner.zip", "/lib/inner.zip/a.txt", "/lib/inner.zip/b.zip/a.txt"} {
+			if indexOf(got, archive+path) == -1 {
+				t.Errorf("Expected %s%s to be walked, got %v", archive, path, got)
+			}
+		}
+	}
+}
+
+func TestRepack(t *testing.T) {
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "src.zip"), filepath.Join(dir, "dst.zip")
+	random := make([]byte, 1000)
+	rand.Read(random)
+	entries := []struct {
+		Name     string
+		Method   uint16
+		Content  []byte
+		Repacked uint16
+	}{
+		{"stored.txt", zip.Store, bytes.Repeat([]byte("hi there "), 100), zip.Deflate},
+		{"random.bin", zip.Store, random, zip.Store},
+		{"deflated.txt", zip.Deflate, []byte(strings.Repeat("abcdefghij", 500)), zip.Deflate},
+	}
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	zw.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(w, flate.HuffmanOnly)
+	})
+	for _, entry := range entries {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: entry.Name, Method: entry.Method, Comment: "kept"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(entry.Content)
+	}
+	zw.SetComment("archive comment")
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(src, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := zipwalk.Repack(src, dst)
+	if err != nil {
+		t.Fatalf("Error repacking - %v", err)
+	}
+	if stats.Recompressed != 2 || stats.BytesSaved() <= 0 {
+		t.Errorf("Unexpected stats - %+v", stats)
+	}
+	zr, err := zip.OpenReader(dst)
+	if err != nil {
+		t.Fatalf("Error opening repacked zip - %v", err)
+	}
+	defer zr.Close()
+	if zr.Comment != "archive comment" || len(zr.File) != len(entries) {
+		t.Fatalf("Unexpected repacked zip - %q with %d entries", zr.Comment, len(zr.File))
+	}
+	for i, f := range zr.File {
+		if f.Name != entries[i].Name || f.Method != entries[i].Repacked || f.Comment != "kept" {
+			t.Errorf("Unexpected entry %d - %s method %d comment %q", i, f.Name, f.Method, f.Comment)
+		}
+		rdr, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := ioutil.ReadAll(rdr)
+		rdr.Close()
+		if err != nil || !bytes.Equal(content, entries[i].Content) {
+			t.Errorf("Unexpected content for %s - %v", f.Name, err)
+		}
+	}
+}
+
+func TestWithTimeout(t *testing.T) {
+	slow := func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		time.Sleep(20 * time.Millisecond)
+		return err
+	}
+	if err := zipwalk.Walk("testdata", slow, zipwalk.WithTimeout(10*time.Millisecond)); err != context.DeadlineExceeded {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := zipwalk.Walk("testdata", slow, zipwalk.WithContext(ctx), zipwalk.WithTimeout(time.Hour)); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	if err := zipwalk.Walk("testdata/a.zip", slow, zipwalk.WithContext(ctx), zipwalk.WithTimeout(time.Hour)); err != nil {
+		t.Errorf("Unexpected error - %v", err)
+	}
+}
+
+func TestContentHash(t *testing.T) {
+	writeTree := func(method uint16, dirEntries bool, content string) string {
+		dir := t.TempDir()
+		if err := ioutil.WriteFile(filepath.Join(dir, "a.txt"), []byte("hi there"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		buf := new(bytes.Buffer)
+		zw := zip.NewWriter(buf)
+		if dirEntries {
+			zw.Create("dir1/")
+		}
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: "dir1/b.txt", Method: method})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, "b.zip"), buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return dir
+	}
+	hashOf := func(root string) string {
+		h := sha256.New()
+		if err := zipwalk.ContentHash(root, h); err != nil {
+			t.Fatalf("Error hashing %s - %v", root, err)
+		}
+		return fmt.Sprintf("%x", h.Sum(nil))
+	}
+	stored := hashOf(writeTree(zip.Store, true, "hi there"))
+	if deflated := hashOf(writeTree(zip.Deflate, false, "hi there")); deflated != stored {
+		t.Errorf("Expected the same hash for equal contents, got %s and %s", stored, deflated)
+	}
+	if changed := hashOf(writeTree(zip.Store, true, "bye there")); changed == stored {
+		t.Errorf("Expected the hash to change with the content of a zip entry")
+	}
+}
+
+func TestWithEntryValidator(t *testing.T) {
+	errRejected := errors.New("rejected")
+	var validated []string
+	errs := map[string]error{}
+	err := zipwalk.Walk("testdata/a.zip", func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		errs[filepath.ToSlash(path)] = err
+		return nil
+	}, zipwalk.WithEntryValidator(func(path string, header *zip.FileHeader) error {
+		validated = append(validated, header.Name)
+		if header.Name == "b.zip" {
+			return errRejected
+		}
+		return nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errs["testdata/a.zip/b.zip"] != errRejected || errs["testdata/a.zip/a.txt"] != nil {
+		t.Errorf("Unexpected errors - %v", errs)
+	}
+	if _, ok := errs["testdata/a.zip/b.zip/a.txt"]; ok {
+		t.Errorf("Expected b.zip not to be descended into")
+	}
+	if indexOf(validated, "a.txt") == -1 || indexOf(validated, "dir1/dir1.txt") == -1 {
+		t.Errorf("Expected every entry to be validated, got %v", validated)
+	}
+}
+
+func TestWalkParents(t *testing.T) {
+	tests := []struct {
+		Path     string
+		Expected []string
+	}{
+		{"a.zip/b.zip/c.txt", []string{"a.zip", "a.zip/b.zip", "a.zip/b.zip/c.txt"}},
+		{"testdata/a.zip/b.zip", []string{"testdata/a.zip", "testdata/a.zip/b.zip"}},
+		{"testdata/folder.zip/c.txt", []string{"testdata/folder.zip/c.txt"}},
+		{"testdata/a.txt", []string{"testdata/a.txt"}},
+	}
+	for _, test := range tests {
+		if got := zipwalk.WalkParents(test.Path); strings.Join(got, ",") != strings.Join(test.Expected, ",") {
+			t.Errorf("Expected %v for %s, got %v", test.Expected, test.Path, got)
+		}
+	}
+}
+
+func TestWalkBzip2(t *testing.T) {
+	// a.txt compressed with bzip2 (method 12) by Python's zipfile, since Go cannot write bzip2
+	data, err := base64.StdEncoding.DecodeString("UEsDBC4AAAAMAPFQUF3sdqPjLQAAAAgAAAAFAAAAYS50eHRCWmg5MUFZJlNZ7lxi8gAAAhGAQAACYBQAIAAwwAhhpLQaMLuSKcKEh3LjF5BQSwECLgMuAAAADADxUFBd7Haj4y0AAAAIAAAABQAAAAAAAAAAAAAAgAEAAAAAYS50eHRQSwUGAAAAAAEAAQAzAAAAUAAAAAAA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	zipPath := filepath.Join(t.TempDir(), "bzip2.zip")
+	if err := ioutil.WriteFile(zipPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	var content []byte
+	err = zipwalk.Walk(zipPath, func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		if err == nil && filepath.Base(path) == "a.txt" {
+			content, err = ioutil.ReadAll(reader)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Error walking bzip2 zip - %v", err)
+	}
+	if string(content) != "hi there" {
+		t.Errorf("Expected hi there, got %q", content)
+	}
+}
+
+func TestWithReportSymlinks(t *testing.T) {
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	for _, entry := range []struct {
+		Name    string
+		Mode    os.FileMode
+		Content string
+	}{{"a.txt", 0644, "hi there"}, {"link.txt", os.ModeSymlink | 0777, "a.txt"}} {
+		fh := &zip.FileHeader{Name: entry.Name}
+		fh.SetMode(entry.Mode)
+		w, err := zw.CreateHeader(fh)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(entry.Content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zipPath := filepath.Join(t.TempDir(), "links.zip")
+	if err := ioutil.WriteFile(zipPath, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	targets := map[string]string{}
+	err := zipwalk.Walk(zipPath, func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		if err != nil {
+			return err
+		}
+		if target, ok := zipwalk.SymlinkTarget(info); ok {
+			if reader != nil || info.Mode()&os.ModeSymlink == 0 {
+				t.Errorf("Expected %s to be reported as a link without a reader", path)
+			}
+			targets[filepath.Base(path)] = target
+		}
+		return nil
+	}, zipwalk.WithReportSymlinks())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(targets) != 1 || targets["link.txt"] != "a.txt" {
+		t.Errorf("Expected link.txt to point at a.txt, got %v", targets)
+	}
+}
+
+func TestWalkDataDescriptors(t *testing.T) {
+	// zip.Writer writes the sizes of entries in data descriptors after their data
+	inner := testutil.ZipBytes(t, map[string][]byte{"a.txt": []byte("hi there")})
+	zipPath := testutil.NewTempZip(t, map[string][]byte{"inner.zip": inner})
+	zr, err := zip.OpenReader(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flags := zr.File[0].Flags
+	zr.Close()
+	if flags&0x8 == 0 {
+		t.Fatalf("Expected inner.zip to be followed by a data descriptor")
+	}
+	var content []byte
+	err = zipwalk.Walk(zipPath, func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		if err != nil {
+			return err
+		}
+		switch filepath.ToSlash(strings.TrimPrefix(path, zipPath)) {
+		case "/inner.zip":
+			if info.Size() != int64(len(inner)) {
+				t.Errorf("Expected size %d for %s, got %d", len(inner), path, info.Size())
+			}
+		case "/inner.zip/a.txt":
+			content, err = ioutil.ReadAll(reader)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "hi there" {
+		t.Errorf("Expected hi there, got %q", content)
+	}
+}
+
+func TestWithOnZipComplete(t *testing.T) {
+	stats := map[string]zipwalk.ZipStats{}
+	m := sync.Mutex{}
+	err := zipwalk.Walk("testdata/a.zip", func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		return err
+	}, zipwalk.WithOnZipComplete(func(path string, zs zipwalk.ZipStats) {
+		m.Lock()
+		stats[filepath.ToSlash(path)] = zs
+		m.Unlock()
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if zs := stats["testdata/a.zip"]; zs.Entries != 3 || zs.Bytes != 832 {
+		t.Errorf("Unexpected stats for testdata/a.zip - %+v", zs)
+	}
+	if _, ok := stats["testdata/a.zip/b.zip/dir1.zip"]; !ok {
+		t.Errorf("Expected stats for nested zips, got %v", stats)
+	}
+}
+
+func TestWithPassword(t *testing.T) {
+	// a.txt (stored) and b.txt (deflated) encrypted by zip -P secret
+	data, err := base64.StdEncoding.DecodeString("UEsDBAoACQAAADRRUF3sdqPjFAAAAAgAAAAFAAAAYS50eHQA/PlUY2GoKbLyFkB9RJfkdnamqVBLBwjsdqPjFAAAAAgAAABQSwMEFAAJAAgANFFQXQLzi48cAAAAwgEAAAUAAABiLnR4dDMuGBLBgWJI+w2wwjqz1+cfXcvurPAjUSXGqm5QSwcIAvOLjxwAAADCAQAAUEsBAh4DCgAJAAAANFFQXex2o+MUAAAACAAAAAUAAAAAAAAAAQAAAKSBAAAAAGEudHh0UEsBAh4DFAAJAAgANFFQXQLzi48cAAAAwgEAAAUAAAAAAAAAAQAAAKSBRwAAAGIudHh0UEsFBgAAAAACAAIAZgAAAJYAAAAAAA==")
+	if err != nil {
+		t.Fatal(err)
+	}
+	zipPath := filepath.Join(t.TempDir(), "encrypted.zip")
+	if err := ioutil.WriteFile(zipPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	walk := func(opts ...zipwalk.Option) (map[string]string, map[string]error) {
+		contents, errs := map[string]string{}, map[string]error{}
+		err := zipwalk.Walk(zipPath, func(path string, info os.FileInfo, reader io.Reader, err error) error {
+			name := filepath.Base(path)
+			if err == nil && reader != nil && name != "encrypted.zip" {
+				var content []byte
+				content, err = ioutil.ReadAll(reader)
+				contents[name] = string(content)
+			}
+			errs[name] = err
+			return nil
+		}, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return contents, errs
+	}
+	contents, errs := walk(zipwalk.WithPassword("secret"))
+	if contents["a.txt"] != "hi there" || contents["b.txt"] != strings.Repeat("hi there ", 50) || errs["a.txt"] != nil || errs["b.txt"] != nil {
+		t.Errorf("Unexpected contents - %q %v", contents, errs)
+	}
+	_, errs = walk()
+	if errs["a.txt"] != zipwalk.ErrEncrypted || errs["b.txt"] != zipwalk.ErrEncrypted {
+		t.Errorf("Expected ErrEncrypted without a password, got %v", errs)
+	}
+	_, errs = walk(zipwalk.WithPasswordFunc(func(path string) string {
+		if filepath.Base(path) == "a.txt" {
+			return "secret"
+		}
+		return "wrong"
+	}))
+	if errs["a.txt"] != nil || errs["b.txt"] != zipwalk.ErrWrongPassword {
+		t.Errorf("Expected ErrWrongPassword for b.txt only, got %v", errs)
+	}
+}
+
+func TestWalkDir(t *testing.T) {
+	zipPath := testutil.NewTempZip(t, map[string][]byte{
+		"b.txt":            []byte("hi there"),
+		"sub/a.txt":        []byte("hi there"),
+		"sub/deeper/c.txt": []byte("hi there"),
+		"sub/z/":           nil,
+		"subway.txt":       []byte("hi there"),
+	})
+	tests := []struct {
+		Dir      string
+		Expected string
+	}{
+		{"", "b.txt,sub/,subway.txt"},
+		{"sub", "a.txt,deeper/,z/"},
+		{"sub/deeper/", "c.txt"},
+	}
+	for _, test := range tests {
+		infos, err := zipwalk.WalkDir(zipPath, test.Dir)
+		if err != nil {
+			t.Fatalf("Error listing %q - %v", test.Dir, err)
+		}
+		var got []string
+		for _, info := range infos {
+			name := info.Name()
+			if info.IsDir() {
+				name += "/"
+			}
+			got = append(got, name)
+		}
+		if strings.Join(got, ",") != test.Expected {
+			t.Errorf("Expected %s in %q, got %v", test.Expected, test.Dir, got)
+		}
+	}
+	if _, err := zipwalk.WalkDir(zipPath, "nope"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected os.ErrNotExist, got %v", err)
+	}
+}
+
+func TestWithSkipMacOSArtifacts(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{".hidden/a.txt", "visible/.DS_Store", "visible/a.txt"} {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("hi there"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	zipData := testutil.ZipBytes(t, map[string][]byte{
+		"__MACOSX/._a.txt": []byte("fork"),
+		"a.txt":            []byte("hi there"),
+		"._b.txt":          []byte("fork"),
+		".config/c.txt":    []byte("hi there"),
+	})
+	if err := ioutil.WriteFile(filepath.Join(dir, "visible", "mac.zip"), zipData, 0644); err != nil {
+		t.Fatal(err)
+	}
+	walk := func(opts ...zipwalk.Option) string {
+		var got []string
+		m := sync.Mutex{}
+		err := zipwalk.Walk(dir, func(path string, info os.FileInfo, reader io.Reader, err error) error {
+			m.Lock()
+			got = append(got, strings.TrimPrefix(filepath.ToSlash(path), filepath.ToSlash(dir)))
+			m.Unlock()
+			return err
+		}, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(got)
+		return strings.Join(got, ",")
+	}
+	if got := walk(zipwalk.WithSkipMacOSArtifacts()); got != ",/.hidden,/.hidden/a.txt,/visible,/visible/a.txt,/visible/mac.zip,/visible/mac.zip/.config/c.txt,/visible/mac.zip/a.txt" {
+		t.Errorf("Unexpected paths skipping macOS artifacts - %s", got)
+	}
+	if got := walk(zipwalk.WithSkipHiddenFiles()); got != ",/visible,/visible/a.txt,/visible/mac.zip,/visible/mac.zip/a.txt" {
+		t.Errorf("Unexpected paths skipping hidden files - %s", got)
+	}
+}
+
+func TestWalkCompat(t *testing.T) {
+	var got []string
+	err := zipwalk.WalkCompat("testdata/a.zip", func(path string, info os.FileInfo, err error) error {
+		got = append(got, filepath.ToSlash(path))
+		if filepath.Base(path) == "dir1.zip" {
+			return zipwalk.SkipZip
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[0] != "testdata/a.zip" || indexOf(got, "testdata/a.zip/b.zip/a.txt") == -1 || indexOf(got, "testdata/a.zip/dir1.zip/dir1/dir1.txt") != -1 {
+		t.Errorf("Unexpected paths - %v", got)
+	}
+	var real []string
+	if err := filepath.Walk("testdata", func(path string, info os.FileInfo, err error) error {
+		real = append(real, path)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	var compat []string
+	if err := zipwalk.WalkCompat("testdata", func(path string, info os.FileInfo, err error) error {
+		if _, ok := info.(zipwalk.ZipFileInfo); !ok {
+			compat = append(compat, path)
+		}
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(compat, ",") != strings.Join(real, ",") {
+		t.Errorf("Expected the real files in the order of filepath.Walk\n%v\n%v", real, compat)
+	}
+}
+
+func TestWithNormalizeTimesToUTC(t *testing.T) {
+	var times []time.Time
+	err := zipwalk.Walk("testdata/a.zip", func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		if _, ok := info.(zipwalk.ZipFileInfo); ok {
+			times = append(times, info.ModTime())
+		}
+		return err
+	}, zipwalk.WithNormalizeTimesToUTC())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(times) == 0 {
+		t.Fatalf("Expected zip entries to be walked")
+	}
+	for _, mt := range times {
+		if mt.Location() != time.UTC {
+			t.Errorf("Expected a UTC modification time, got %v", mt)
+		}
+	}
+}
+
+func TestWalkGlob(t *testing.T) {
+	tests := []struct {
+		Pattern  string
+		Expected string
+	}{
+		{"testdata/**/*.zip/**/*.txt", "testdata/a.zip/a.txt,testdata/a.zip/b.zip/a.txt,testdata/a.zip/b.zip/dir1.zip/dir1/dir1.txt,testdata/a.zip/dir1.zip/dir1/dir1.txt,testdata/dir2.zip/dir1/dir1.txt,testdata/folder.zip/c.txt,testdata/lzma.zip/empty.txt,testdata/lzma.zip/lorem.txt"},
+		{"testdata/a.zip/*.txt", "testdata/a.zip/a.txt"},
+		{"testdata/*.zip/b.zip/*.txt", "testdata/a.zip/b.zip/a.txt"},
+		{"testdata/*.txt", "testdata/a.txt"},
+	}
+	for _, test := range tests {
+		var got []string
+		m := sync.Mutex{}
+		var walkedDir1 bool
+		err := zipwalk.WalkGlob(test.Pattern, func(path string, info os.FileInfo, reader io.Reader, err error) error {
+			m.Lock()
+			defer m.Unlock()
+			got = append(got, filepath.ToSlash(path))
+			return err
+		}, zipwalk.WithOnZipOpen(func(path string, entryCount int) {
+			m.Lock()
+			defer m.Unlock()
+			walkedDir1 = walkedDir1 || strings.HasSuffix(filepath.ToSlash(path), "a.zip/dir1.zip")
+		}))
+		if err != nil {
+			t.Fatalf("Error walking %s - %v", test.Pattern, err)
+		}
+		sort.Strings(got)
+		if strings.Join(got, ",") != test.Expected {
+			t.Errorf("Expected %s for %s, got %v", test.Expected, test.Pattern, got)
+		}
+		if walkedDir1 && !strings.Contains(test.Expected, "a.zip/dir1.zip") {
+			t.Errorf("Expected a.zip/dir1.zip not to be opened for %s", test.Pattern)
+		}
+	}
+
+	dir := filepath.ToSlash(t.TempDir())
+	if !strings.HasPrefix(dir, "/") {
+		t.Skip("absolute paths do not start with a slash on this platform")
+	}
+	zipPath := testutil.NewTempZip(t, map[string][]byte{"a.txt": []byte("a"), "b.json": []byte("b")})
+	if err := os.Rename(zipPath, dir+"/x.zip"); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	err := zipwalk.WalkGlob(dir+"/*.zip/*.txt", func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		got = append(got, filepath.ToSlash(path))
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Error walking an absolute pattern - %v", err)
+	}
+	if expected := []string{dir + "/x.zip/a.txt"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v for an absolute pattern, got %v", expected, got)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	for _, encoding := range []*base64.Encoding{base64.StdEncoding, base64.RawURLEncoding} {
+		got, err := zipwalk.Encode("testdata/a.zip/b.zip/a.txt", encoding)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := encoding.EncodeToString([]byte("hi there")); got != want {
+			t.Errorf("Expected %s, got %s", want, got)
+		}
+	}
+	if _, err := zipwalk.Encode("testdata/a.zip/nope.txt", base64.StdEncoding); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected os.ErrNotExist, got %v", err)
+	}
+}
+
+func TestWalkUnicodePathExtraField(t *testing.T) {
+	legacy := "caf\x82.txt" // café.txt in code page 437
+	field := []byte{1, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint32(field[1:], crc32.ChecksumIEEE([]byte(legacy)))
+	field = append(field, "café.txt"...)
+	extra := []byte{0x75, 0x70, 0, 0}
+	binary.LittleEndian.PutUint16(extra[2:], uint16(len(field)))
+	extra = append(extra, field...)
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: legacy, NonUTF8: true, Extra: extra})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("hi there"))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zipPath := filepath.Join(t.TempDir(), "unicode.zip")
+	if err := ioutil.WriteFile(zipPath, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	err = zipwalk.Walk(zipPath, func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		if path != zipPath {
+			got = append(got, filepath.Base(path))
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0] != "café.txt" {
+		t.Errorf("Expected café.txt, got %q", got)
+	}
+	if exists, err := zipwalk.FileExists(filepath.Join(zipPath, "café.txt")); !exists || err != nil {
+		t.Errorf("Expected café.txt to be found by its Unicode name - %v", err)
+	}
+}
+
+func TestWalkDiff(t *testing.T) {
+	a := testutil.NewTempZip(t, map[string][]byte{"same.txt": []byte("hi there"), "changed.txt": []byte("a"), "removed.txt": []byte("bye"), "dir1/c.txt": []byte("c")})
+	b := testutil.NewTempZip(t, map[string][]byte{"same.txt": []byte("hi there"), "changed.txt": []byte("b"), "added.txt": []byte("hello"), "dir1/c.txt": []byte("cc")})
+	diff := func(opts ...zipwalk.Option) string {
+		var got []string
+		err := zipwalk.WalkDiff(a, b, func(change zipwalk.ChangeType, path string, infoA, infoB os.FileInfo) error {
+			got = append(got, fmt.Sprintf("%s:%s", change, path))
+			return nil
+		}, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.Join(got, ",")
+	}
+	if got := diff(); got != "Added:added.txt,Unchanged:changed.txt,Modified:dir1/c.txt,Removed:removed.txt,Unchanged:same.txt" {
+		t.Errorf("Unexpected differences by metadata - %s", got)
+	}
+	if got := diff(zipwalk.WithDiffContent()); got != "Added:added.txt,Modified:changed.txt,Modified:dir1/c.txt,Removed:removed.txt,Unchanged:same.txt" {
+		t.Errorf("Unexpected differences by content - %s", got)
+	}
+}
+
+// countingCDCache records the lookups made in the ZipCDCache it wraps.
+type countingCDCache struct {
+	zipwalk.ZipCDCache
+	hits, misses int
+}
+
+func (c *countingCDCache) Get(path string, modTime time.Time, size int64) ([]byte, bool) {
+	dir, ok := c.ZipCDCache.Get(path, modTime, size)
+	if ok {
+		c.hits++
+	} else {
+		c.misses++
+	}
+	return dir, ok
+}
+
+func TestWithCentralDirectoryCache(t *testing.T) {
+	zipPath := testutil.NewTempZip(t, map[string][]byte{"a.txt": []byte("a"), "b.txt": []byte("bb")})
+	cache := &countingCDCache{ZipCDCache: zipwalk.NewMemoryCDCache()}
+	walk := func() string {
+		var got []string
+		err := zipwalk.Walk(zipPath, func(path string, info os.FileInfo, reader io.Reader, err error) error {
+			if err != nil {
+				return err
+			}
+			if filepath.Ext(path) == ".txt" {
+				buf, err := ioutil.ReadAll(reader)
+				if err != nil {
+					return err
+				}
+				got = append(got, info.Name()+"="+string(buf))
+			}
+			return nil
+		}, zipwalk.WithCentralDirectoryCache(cache))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(got)
+		return strings.Join(got, ",")
+	}
+	for i := 0; i < 2; i++ {
+		if got := walk(); got != "a.txt=a,b.txt=bb" {
+			t.Errorf("Walk %d saw %s", i, got)
+		}
+	}
+	if cache.hits != 1 || cache.misses != 1 {
+		t.Errorf("Expected 1 hit and 1 miss, got %d hits and %d misses", cache.hits, cache.misses)
+	}
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(zipPath, later, later); err != nil {
+		t.Fatal(err)
+	}
+	walk()
+	if cache.misses != 2 {
+		t.Errorf("Expected a miss after the zip file was modified, got %d misses", cache.misses)
+	}
+
+	// rewriting the zip file with the same modification time misses the cache by its size
+	if err := ioutil.WriteFile(zipPath, testutil.ZipBytes(t, map[string][]byte{"a.txt": []byte("a"), "b.txt": []byte("bb"), "c.txt": []byte("ccc")}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(zipPath, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if got := walk(); got != "a.txt=a,b.txt=bb,c.txt=ccc" || cache.misses != 3 {
+		t.Errorf("Expected a miss after the zip file was rewritten, saw %s with %d misses", got, cache.misses)
+	}
+}
+
+func TestWithCentralDirectoryCacheZip64(t *testing.T) {
+	// more entries than the end of central directory record can count makes a Zip64 file
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	for i := 0; i < 0x10000; i++ {
+		if _, err := zw.CreateHeader(&zip.FileHeader{Name: fmt.Sprintf("%05d", i), Method: zip.Store}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zipPath := filepath.Join(t.TempDir(), "zip64.zip")
+	if err := ioutil.WriteFile(zipPath, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cache := &countingCDCache{ZipCDCache: zipwalk.NewMemoryCDCache()}
+	for i := 0; i < 2; i++ {
+		entries := 0
+		err := zipwalk.Walk(zipPath, func(path string, info os.FileInfo, reader io.Reader, err error) error {
+			entries++
+			return err
+		}, zipwalk.WithCentralDirectoryCache(cache))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entries != 0x10001 {
+			t.Errorf("Walk %d expected the zip file and its %d entries, got %d", i, 0x10000, entries)
+		}
+	}
+	if cache.hits != 1 || cache.misses != 1 {
+		t.Errorf("Expected 1 hit and 1 miss, got %d hits and %d misses", cache.hits, cache.misses)
+	}
+}
+
+func TestWithSkipEmpty(t *testing.T) {
+	zipPath := testutil.NewTempZip(t, map[string][]byte{"a.txt": []byte("a"), "empty.txt": nil, "dir1/": nil, "dir1/empty.txt": nil})
+	var got []string
+	var stats zipwalk.ZipStats
+	err := zipwalk.Walk(zipPath, func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		if err != nil {
+			return err
+		}
+		got = append(got, filepath.ToSlash(strings.TrimPrefix(path, zipPath)))
+		return nil
+	}, zipwalk.WithSkipEmpty(), zipwalk.WithOnZipComplete(func(path string, s zipwalk.ZipStats) {
+		stats = s
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(got)
+	if strings.Join(got, ",") != ",/a.txt,/dir1" {
+		t.Errorf("Unexpected paths walked - %v", got)
+	}
+	if stats.Entries != 2 || stats.Empty != 2 {
+		t.Errorf("Unexpected stats - %+v", stats)
+	}
+}
+
+func TestSetEntryComment(t *testing.T) {
+	zipPath := testutil.NewTempZip(t, map[string][]byte{"a.txt": []byte("a"), "b.txt": []byte("bb")})
+	comments := func() map[string]string {
+		zr, err := zip.OpenReader(zipPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zr.Close()
+		got := map[string]string{}
+		for _, f := range zr.File {
+			rdr, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := ioutil.ReadAll(rdr); err != nil {
+				t.Fatalf("Error reading %s after setting comment - %v", f.Name, err)
+			}
+			rdr.Close()
+			got[f.Name] = f.Comment
+		}
+		return got
+	}
+	if err := zipwalk.SetEntryComment(zipPath, "a.txt", "tag:reviewed"); err != nil {
+		t.Fatal(err)
+	}
+	if got := comments(); got["a.txt"] != "tag:reviewed" || got["b.txt"] != "" {
+		t.Errorf("Unexpected comments after setting - %v", got)
+	}
+	if err := zipwalk.SetEntryComment(zipPath, "a.txt", ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := comments(); got["a.txt"] != "" || len(got) != 2 {
+		t.Errorf("Unexpected comments after clearing - %v", got)
+	}
+	if err := zipwalk.SetEntryComment(zipPath, "missing.txt", "x"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected os.ErrNotExist for a missing entry, got %v", err)
+	}
+}
+
+func TestChain(t *testing.T) {
+	zipPath := testutil.NewTempZip(t, map[string][]byte{"small.txt": []byte("a"), "large.txt": []byte("0123456789")})
+	var logged bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logged, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	var timed, got []string
+	walkFn := zipwalk.Chain(func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		if err != nil {
+			return err
+		}
+		got = append(got, info.Name())
+		return nil
+	}, zipwalk.LoggingMiddleware(logger), zipwalk.TimingMiddleware(func(path string, d time.Duration) {
+		timed = append(timed, filepath.Base(path))
+	}), zipwalk.MinSizeMiddleware(5))
+	if err := zipwalk.Walk(zipPath, walkFn); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(got)
+	if want := []string{"large.txt", filepath.Base(zipPath)}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if len(timed) != 3 {
+		t.Errorf("Expected every path to be timed, got %v", timed)
+	}
+	if !strings.Contains(logged.String(), "small.txt") {
+		t.Errorf("Expected every path to be logged, got %s", logged.String())
+	}
+}
+
+func TestWithConcurrentRead(t *testing.T) {
+	defer goleak.VerifyNone(t)
+	files := map[string][]byte{"inner.zip": testutil.ZipBytes(t, map[string][]byte{"x.txt": []byte("x")})}
+	var want []string
+	for i := 0; i < 50; i++ {
+		name := fmt.Sprintf("f%02d.txt", i)
+		files[name] = bytes.Repeat([]byte{byte('a' + i%26)}, 1000+i)
+		want = append(want, fmt.Sprintf("%s=%d", name, 1000+i))
+	}
+	want = append(want, "x.txt=1")
+	zipPath := testutil.NewTempZip(t, files)
+	walk := func(opts ...zipwalk.Option) []string {
+		var got []string
+		inWalkFn := int32(0)
+		err := zipwalk.Walk(zipPath, func(path string, info os.FileInfo, reader io.Reader, err error) error {
+			if err != nil {
+				return err
+			}
+			if atomic.AddInt32(&inWalkFn, 1) != 1 {
+				t.Error("walkFn called concurrently")
+			}
+			defer atomic.AddInt32(&inWalkFn, -1)
+			if filepath.Ext(path) == ".txt" {
+				buf, err := ioutil.ReadAll(reader)
+				if err != nil {
+					return err
+				}
+				got = append(got, fmt.Sprintf("%s=%d", info.Name(), len(buf)))
+			}
+			return nil
+		}, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+	serial := walk()
+	if concurrent := walk(zipwalk.WithConcurrentRead(4)); !reflect.DeepEqual(serial, concurrent) {
+		t.Errorf("Expected the same entries in the same order\nserial: %v\nconcurrent: %v", serial, concurrent)
+	}
+	sort.Strings(serial)
+	if !reflect.DeepEqual(serial, want) {
+		t.Errorf("Unexpected entries - %v", serial)
+	}
+}
+
+func TestDetectZipBomb(t *testing.T) {
+	dir := t.TempDir()
+	bomb := filepath.Join(dir, "bomb.zip")
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create("zeros.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(make([]byte, 10<<20)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(bomb, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// recorded sizes whose sum wraps a uint64 around to 0
+	overflow := filepath.Join(dir, "overflow.zip")
+	buf.Reset()
+	zw = zip.NewWriter(&buf)
+	for _, name := range []string{"a.bin", "b.bin"} {
+		w, err := zw.CreateRaw(&zip.FileHeader{Name: name, Method: zip.Store, CompressedSize64: 1, UncompressedSize64: 1 << 63})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte{0}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(overflow, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		path string
+		want bool
+	}{
+		{bomb, true},
+		{overflow, true},
+		{"testdata/a.zip", false},
+	} {
+		got, err := zipwalk.DetectZipBomb(test.path, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Errorf("DetectZipBomb(%s) = %v, expected %v", test.path, got, test.want)
+		}
+	}
+}
+
+func TestWalk2(t *testing.T) {
+	inner := testutil.ZipBytes(t, map[string][]byte{"b.txt": []byte("bb")})
+	zipPath := testutil.NewTempZip(t, map[string][]byte{"a.txt": []byte("a"), "dir1/inner.zip": inner})
+	root := filepath.ToSlash(zipPath)
+	got := map[string]zipwalk.Entry{}
+	err := zipwalk.Walk2(zipPath, func(entry zipwalk.Entry) error {
+		if entry.Err != nil {
+			return entry.Err
+		}
+		got[strings.TrimPrefix(filepath.ToSlash(entry.Path), root)] = entry
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]struct {
+		depth   int
+		chain   []string
+		content string
+	}{
+		"":                      {0, nil, ""},
+		"/a.txt":                {1, []string{root}, "a"},
+		"/dir1/inner.zip":       {1, []string{root}, string(inner)},
+		"/dir1/inner.zip/b.txt": {2, []string{root, root + "/dir1/inner.zip"}, "bb"},
+	} {
+		entry, ok := got[path]
+		if !ok {
+			t.Errorf("Missing entry %s", path)
+			continue
+		}
+		if entry.Depth != want.depth || !reflect.DeepEqual(entry.ZipChain, want.chain) {
+			t.Errorf("Entry %s has depth %d and chain %v, expected %d and %v", path, entry.Depth, entry.ZipChain, want.depth, want.chain)
+		}
+		if path == "" {
+			continue
+		}
+		buf := make([]byte, entry.Info.Size())
+		if _, err := entry.Reader.ReadAt(buf, 0); err != nil && err != io.EOF {
+			t.Fatal(err)
+		}
+		if string(buf) != want.content {
+			t.Errorf("Entry %s has content %q, expected %q", path, buf, want.content)
+		}
+	}
+}
+
+func TestWalkLZMA(t *testing.T) {
+	var want strings.Builder
+	for i := 0; i < 3000; i++ {
+		fmt.Fprintf(&want, "%d %d lorem ipsum dolor %s\n", i, i*i%997, strings.Repeat("x", i%7))
+	}
+	got := map[string]string{}
+	err := zipwalk.Walk("testdata/lzma.zip", func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		if err != nil {
+			return err
+		}
+		if filepath.Ext(path) == ".txt" {
+			buf, err := ioutil.ReadAll(reader)
+			if err != nil {
+				return err
+			}
+			got[info.Name()] = string(buf)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got["lorem.txt"] != want.String() || got["empty.txt"] != "" || len(got) != 2 {
+		t.Errorf("Unexpected content of LZMA-compressed entries - %d bytes of lorem.txt, %d entries", len(got["lorem.txt"]), len(got))
+	}
+	var buf bytes.Buffer
+	if _, err := (zipwalk.EntryWriterTo{Path: "testdata/lzma.zip/lorem.txt"}).WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != want.String() {
+		t.Errorf("Unexpected content read through the registered decompressor - %d bytes", buf.Len())
+	}
+}
+
+func TestWithPathHasher(t *testing.T) {
+	zipPath := testutil.NewTempZip(t, map[string][]byte{"a.txt": []byte("a")})
+	hash := func(path string) string {
+		sum := sha256.Sum256([]byte(filepath.ToSlash(path)))
+		return hex.EncodeToString(sum[:8])
+	}
+	var got []string
+	err := zipwalk.Walk(zipPath, func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		if err != nil {
+			return err
+		}
+		got = append(got, path)
+		return nil
+	}, zipwalk.WithPathHasher(hash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(got)
+	want := []string{hash(zipPath), hash(zipPath + "/a.txt")}
+	sort.Strings(want)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected hashed paths %v, got %v", want, got)
+	}
+	err = zipwalk.Walk2(zipPath, func(entry zipwalk.Entry) error {
+		if entry.Err != nil {
+			return entry.Err
+		}
+		if entry.Path != hash(entry.OriginalPath) {
+			t.Errorf("Entry %s has path %s, expected its hash", entry.OriginalPath, entry.Path)
+		}
+		return nil
+	}, zipwalk.WithPathHasher(hash))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	defer goleak.VerifyNone(t)
+	dir := t.TempDir()
+	zipPath := filepath.Join(dir, "watched.zip")
+	write := func(files map[string][]byte, modTime time.Time) {
+		if err := ioutil.WriteFile(zipPath, testutil.ZipBytes(t, files), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(zipPath, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(map[string][]byte{"same.txt": []byte("same"), "changed.txt": []byte("old")}, time.Now().Add(-time.Hour))
+	changes := make(chan string, 10)
+	w, err := zipwalk.Watch(dir, func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		if err != nil {
+			return err
+		}
+		buf, err := ioutil.ReadAll(reader)
+		if err != nil {
+			return err
+		}
+		changes <- info.Name() + "=" + string(buf)
+		return nil
+	}, zipwalk.WithWatchInterval(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(map[string][]byte{"same.txt": []byte("same"), "changed.txt": []byte("new"), "added.txt": []byte("added")}, time.Now())
+	var got []string
+	timeout := time.After(5 * time.Second)
+	for len(got) < 2 {
+		select {
+		case change := <-changes:
+			got = append(got, change)
+		case <-timeout:
+			t.Fatalf("Timed out waiting for changes, got %v", got)
+		}
+	}
+	if err := w.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(got)
+	if want := []string{"added.txt=added", "changed.txt=new"}; !reflect.DeepEqual(got, want) || len(changes) != 0 {
+		t.Errorf("Expected changes %v, got %v and %d more", want, got, len(changes))
+	}
+}
+
+func TestWatchSkipsAndErrors(t *testing.T) {
+	defer goleak.VerifyNone(t)
+	tmp := t.TempDir()
+	dir := filepath.Join(tmp, "watched")
+	zipPath := filepath.Join(dir, "watched.zip")
+	// written next to dir and renamed into it, so that no scan sees a partly written zip file
+	write := func(files map[string][]byte, modTime time.Time) {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		written := filepath.Join(tmp, "watched.zip")
+		if err := ioutil.WriteFile(written, testutil.ZipBytes(t, files), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(written, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(written, zipPath); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(map[string][]byte{"a.txt": []byte("a")}, time.Now().Add(-time.Hour))
+	changes := make(chan string, 10)
+	w, err := zipwalk.Watch(dir, func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		if err != nil {
+			changes <- "error " + path
+			return nil
+		}
+		buf, err := ioutil.ReadAll(reader)
+		if err != nil {
+			return err
+		}
+		changes <- string(buf)
+		if string(buf) == "skip" {
+			return zipwalk.SkipZip
+		}
+		return nil
+	}, zipwalk.WithWatchInterval(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := func() string {
+		select {
+		case change := <-changes:
+			return change
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for a change")
+			return ""
+		}
+	}
+	expectNone := func() {
+		select {
+		case change := <-changes:
+			t.Errorf("Expected no change, got %s", change)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	// SkipZip hands on only one of the changed entries
+	write(map[string][]byte{"a.txt": []byte("skip"), "b.txt": []byte("skip")}, time.Now().Add(-time.Minute))
+	if got := next(); got != "skip" {
+		t.Errorf("Expected a skipped change, got %s", got)
+	}
+	expectNone()
+	// and records the other, so it is not handed on when the zip file is touched
+	write(map[string][]byte{"a.txt": []byte("skip"), "b.txt": []byte("skip")}, time.Now())
+	expectNone()
+
+	// the missing root is reported to walkFn without stopping the Watcher
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	if got := next(); got != "error "+dir {
+		t.Errorf("Expected the error of %s, got %s", dir, got)
+	}
+	write(map[string][]byte{"a.txt": []byte("back")}, time.Now())
+	for got := next(); got != "back"; got = next() {
+		if got != "error "+dir {
+			t.Errorf("Expected the zip file to be walked again, got %s", got)
+		}
+	}
+	if err := w.Stop(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCompressionRatio(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, method := range []uint16{zip.Store, zip.Deflate} {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: fmt.Sprintf("method%d.txt", method), Method: method})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(bytes.Repeat([]byte("a"), 10000)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := zipwalk.NewZipFileInfo(time.Now(), zr.File[0].FileInfo())
+	if ratio := stored.CompressionRatio(); ratio != 0 {
+		t.Errorf("Expected a ratio of 0 for a stored entry, got %f", ratio)
+	}
+	deflated := zipwalk.NewZipFileInfo(time.Now(), zr.File[1].FileInfo())
+	if ratio := deflated.CompressionRatio(); ratio < 100 {
+		t.Errorf("Expected a high ratio for a repetitive deflated entry, got %f", ratio)
+	}
+}
+
+func TestCloneZip(t *testing.T) {
+	src := testutil.ZipBytes(t, map[string][]byte{"keep.txt": []byte("keep"), "rename.txt": []byte("renamed"), "drop.txt": []byte("drop"), "trim.txt": []byte("trim\n\n")})
+	var dst bytes.Buffer
+	err := zipwalk.CloneZip(bytes.NewReader(src), int64(len(src)), &dst, func(path string, header *zip.FileHeader, r io.Reader) (io.Reader, error) {
+		switch path {
+		case "rename.txt":
+			header.Name = "renamed.txt"
+		case "drop.txt":
+			return nil, nil
+		case "trim.txt":
+			buf, err := ioutil.ReadAll(r)
+			if err != nil {
+				return nil, err
+			}
+			return bytes.NewReader(bytes.TrimRight(buf, "\n")), nil
+		}
+		return r, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(dst.Bytes()), int64(dst.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	for _, f := range zr.File {
+		rdr, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf, err := ioutil.ReadAll(rdr)
+		rdr.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got[f.Name] = string(buf)
+	}
+	if want := map[string]string{"keep.txt": "keep", "renamed.txt": "renamed", "trim.txt": "trim"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestBuilder(t *testing.T) {
+	dir1 := new(testutil.Builder).AddFile("dir1/", "").AddFile("dir1/dir1.txt", "hi there")
+	b := new(testutil.Builder).AddFile("a.txt", "hi there").AddZip("dir1.zip", dir1)
+	a := new(testutil.Builder).AddFile("a.txt", "hi there").AddZip("dir1.zip", dir1).AddZip("b.zip", b)
+	built := filepath.Join(t.TempDir(), "a.zip")
+	if err := ioutil.WriteFile(built, a.Build(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	walkedPaths := func(root string) []string {
+		var paths []string
+		err := zipwalk.Walk(root, func(path string, info os.FileInfo, reader io.Reader, err error) error {
+			if err != nil {
+				return err
+			}
+			paths = append(paths, strings.TrimPrefix(filepath.ToSlash(path), filepath.ToSlash(root)))
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(paths)
+		return paths
+	}
+	if got, want := walkedPaths(built), walkedPaths("testdata/a.zip"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected the built zip file to match testdata/a.zip\n%v\nvs\n%v", got, want)
+	}
+}
+
+func TestWalkFuncAdaptor(t *testing.T) {
+	var got []string
+	err := zipwalk.Walk("testdata/a.zip", zipwalk.WalkFuncAdaptor(func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		path = filepath.ToSlash(path)
+		got = append(got, fmt.Sprintf("%s:%t", path, d.IsDir()))
+		if d.Name() == "b.zip" {
+			return fs.SkipDir
+		}
+		return nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(got)
+	want := []string{"testdata/a.zip/a.txt:false", "testdata/a.zip/b.zip:false", "testdata/a.zip/dir1.zip/dir1:true", "testdata/a.zip/dir1.zip/dir1/dir1.txt:false", "testdata/a.zip/dir1.zip:false", "testdata/a.zip:false"}
+	sort.Strings(want)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+// aesZip returns the path of a zip file holding name, deflated and encrypted with WinZip AES
+// encryption under password, with a key of keyLen bytes, as an AE-1 or AE-2 entry.  The
+// authentication code is flipped when corrupt is set.
+func aesZip(t *testing.T, name, content, password string, keyLen int, version uint16, corrupt bool) string {
+	var compressed bytes.Buffer
+	fw, err := flate.NewWriter(&compressed, flate.DefaultCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write([]byte(content))
+	fw.Close()
+	salt := bytes.Repeat([]byte{0x5a}, keyLen/2)
+	keys, err := pbkdf2.Key(sha1.New, password, salt, 1000, 2*keyLen+2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := aes.NewCipher(keys[:keyLen])
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted := compressed.Bytes()
+	var counter, keystream [aes.BlockSize]byte
+	for i := range encrypted {
+		if i%aes.BlockSize == 0 {
+			binary.LittleEndian.PutUint64(counter[:], uint64(i/aes.BlockSize+1))
+			block.Encrypt(keystream[:], counter[:])
+		}
+		encrypted[i] ^= keystream[i%aes.BlockSize]
+	}
+	mac := hmac.New(sha1.New, keys[keyLen:2*keyLen])
+	mac.Write(encrypted)
+	auth := mac.Sum(nil)[:10]
+	if corrupt {
+		auth[0] ^= 0xff
+	}
+	data := append(append(append(append([]byte{}, salt...), keys[2*keyLen:]...), encrypted...), auth...)
+	extra := []byte{0x01, 0x99, 7, 0, byte(version), 0, 'A', 'E', byte(keyLen/8 - 1), byte(zip.Deflate), 0}
+	fh := &zip.FileHeader{Name: name, Method: 99, Flags: 0x1, Extra: extra, CompressedSize64: uint64(len(data)), UncompressedSize64: uint64(len(content))}
+	if version == 1 {
+		fh.CRC32 = crc32.ChecksumIEEE([]byte(content))
+	}
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.CreateRaw(fh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(data)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zipPath := filepath.Join(t.TempDir(), "aes.zip")
+	if err := ioutil.WriteFile(zipPath, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return zipPath
+}
+
+func TestWalkAES(t *testing.T) {
+	content := strings.Repeat("hi there ", 50)
+	walk := func(zipPath string, opts ...zipwalk.Option) (string, error) {
+		var got string
+		var gotErr error
+		err := zipwalk.Walk(zipPath, func(path string, info os.FileInfo, reader io.Reader, err error) error {
+			if filepath.Base(path) != "a.txt" {
+				return err
+			}
+			if err == nil {
+				var buf []byte
+				buf, err = ioutil.ReadAll(reader)
+				got = string(buf)
+			}
+			gotErr = err
+			return nil
+		}, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got, gotErr
+	}
+	for _, test := range []struct {
+		keyLen  int
+		version uint16
+	}{{16, 1}, {24, 2}, {32, 2}} {
+		zipPath := aesZip(t, "a.txt", content, "secret", test.keyLen, test.version, false)
+		if got, err := walk(zipPath, zipwalk.WithPassword("secret")); got != content || err != nil {
+			t.Errorf("AE-%d with a %d bit key read %q - %v", test.version, test.keyLen*8, got, err)
+		}
+		if _, err := walk(zipPath); err != zipwalk.ErrEncrypted {
+			t.Errorf("Expected ErrEncrypted without a password, got %v", err)
+		}
+		if _, err := walk(zipPath, zipwalk.WithPassword("wrong")); err != zipwalk.ErrWrongPassword {
+			t.Errorf("Expected ErrWrongPassword, got %v", err)
+		}
+	}
+	zipPath := aesZip(t, "a.txt", content, "secret", 32, 2, true)
+	if _, err := walk(zipPath, zipwalk.WithPassword("secret")); !errors.Is(err, zip.ErrChecksum) {
+		t.Errorf("Expected zip.ErrChecksum for a wrong authentication code, got %v", err)
+	}
+}
+
+func TestWithNormalizeDOSEpochTimestamps(t *testing.T) {
+	valid := time.Date(2020, time.March, 4, 5, 6, 8, 0, time.UTC)
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, fh := range []*zip.FileHeader{
+		{Name: "zeroed.txt"},
+		{Name: "unix.txt", Modified: time.Unix(0, 0).UTC()},
+		{Name: "valid.txt", Modified: valid},
+		{Name: "ntfs.txt", Extra: ntfsExtra(time.Unix(0, 0))},
+		{Name: "ntfs-valid.txt", Extra: ntfsExtra(valid)},
+	} {
+		if _, err := zw.CreateHeader(fh); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zipPath := filepath.Join(t.TempDir(), "times.zip")
+	if err := ioutil.WriteFile(zipPath, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// entries without an NTFS time report the time of the zip file
+	old := time.Date(1975, time.June, 1, 0, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(zipPath, old, old); err != nil {
+		t.Fatal(err)
+	}
+	sentinel := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	for _, test := range []struct {
+		sentinel time.Time
+		want     time.Time
+	}{
+		{time.Time{}, time.Date(1980, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{sentinel, sentinel},
+	} {
+		got, modTimes := map[string]time.Time{}, map[string]time.Time{}
+		err := zipwalk.Walk(zipPath, func(path string, info os.FileInfo, reader io.Reader, err error) error {
+			if err != nil {
+				return err
+			}
+			if h, ok := info.(zipwalk.Headered); ok {
+				got[info.Name()] = h.ZipHeader().Modified
+				modTimes[info.Name()] = info.ModTime()
+			}
+			return nil
+		}, zipwalk.WithNormalizeDOSEpochTimestamps(test.sentinel))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got["zeroed.txt"].Equal(test.want) || !got["unix.txt"].Equal(test.want) || !got["valid.txt"].Equal(valid) {
+			t.Errorf("Expected times before 1980 to become %v, got %v", test.want, got)
+		}
+		for name, want := range map[string]time.Time{"zeroed.txt": test.want, "valid.txt": test.want, "ntfs.txt": test.want, "ntfs-valid.txt": valid} {
+			if !modTimes[name].Equal(want) {
+				t.Errorf("Expected ModTime %v for %s, got %v", want, name, modTimes[name])
+			}
+		}
+	}
+}
+
+func TestFindZips(t *testing.T) {
+	zips, err := zipwalk.FindZips("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range zips {
+		zips[i] = filepath.ToSlash(zips[i])
+	}
+	want := []string{
+		"testdata/a.zip",
+		"testdata/a.zip/dir1.zip",
+		"testdata/a.zip/b.zip",
+		"testdata/a.zip/b.zip/dir1.zip",
+		"testdata/dir2.zip",
+		"testdata/lzma.zip",
+	}
+	if !reflect.DeepEqual(zips, want) {
+		t.Errorf("Expected %v, got %v", want, zips)
+	}
+}
+
+func TestWithMIMEFilter(t *testing.T) {
+	png := append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 600)...)
+	text := strings.Repeat("hi there ", 100)
+	zipPath := testutil.NewTempZip(t, map[string][]byte{"a.txt": []byte(text), "b.png": png, "c.xml": []byte("<?xml version=\"1.0\"?><a/>")})
+	got := map[string]string{}
+	err := zipwalk.Walk(zipPath, func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		if err != nil {
+			return err
+		}
+		buf, err := ioutil.ReadAll(reader)
+		if err != nil {
+			return err
+		}
+		got[info.Name()] = string(buf)
+		return nil
+	}, zipwalk.WithMIMEFilter("text/plain", "text/xml; charset=utf-8"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got["a.txt"] != text || !strings.HasPrefix(got["c.xml"], "<?xml") {
+		t.Errorf("Expected the full content of a.txt and c.xml only, got %q", got)
+	}
+}
+
+func TestWithReadBufferSize(t *testing.T) {
+	for _, size := range []int{0, 16, 1 << 20} {
+		got := map[string]int{}
+		err := zipwalk.Walk("testdata/a.zip", func(path string, info os.FileInfo, reader io.Reader, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() || reader == nil {
+				return nil
+			}
+			buf, err := ioutil.ReadAll(reader)
+			if err != nil {
+				return err
+			}
+			got[path] = len(buf)
+			return nil
+		}, zipwalk.WithReadBufferSize(size))
+		if err != nil {
+			t.Fatalf("Walk with a buffer of %d bytes - %v", size, err)
+		}
+		for path, n := range got {
+			info, err := zipwalk.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !info.IsDir() && int64(n) != info.Size() && !strings.HasSuffix(path, ".zip") {
+				t.Errorf("Expected %d bytes from %s with a buffer of %d bytes, got %d", info.Size(), path, size, n)
+			}
+		}
+		if len(got) == 0 {
+			t.Errorf("Expected files to be walked with a buffer of %d bytes", size)
+		}
+	}
+}
+
+func TestLookupPath(t *testing.T) {
+	content := []byte(strings.Repeat("stored bytes ", 50))
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "dir/a.txt", Method: zip.Store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(content)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zipPath := filepath.Join(t.TempDir(), "lookup.zip")
+	if err := ioutil.WriteFile(zipPath, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file, offset, size, header, err := zipwalk.LookupPath(zipPath + "/dir/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if header.Name != "dir/a.txt" || size != int64(len(content)) {
+		t.Errorf("Expected dir/a.txt of %d bytes, got %s of %d bytes", len(content), header.Name, size)
+	}
+	got := make([]byte, size)
+	if _, err := file.ReadAt(got, offset); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Errorf("Expected the stored bytes at offset %d, got %q", offset, got)
+	}
+	if _, _, _, _, err := zipwalk.LookupPath("testdata/a.zip/b.zip/c.txt"); !errors.Is(err, zipwalk.ErrNestedEntry) {
+		t.Errorf("Expected ErrNestedEntry for a nested entry, got %v", err)
+	}
+}
+
+func TestCacheWarmup(t *testing.T) {
+	memory := zipwalk.NewMemoryCDCache()
+	stats, err := zipwalk.CacheWarmup("testdata", memory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Zips == 0 || stats.Entries < stats.Zips {
+		t.Errorf("Expected zip files and their entries to be cached, got %+v", stats)
+	}
+	cache := &countingCDCache{ZipCDCache: memory}
+	err = zipwalk.WalkCompat("testdata", func(path string, info os.FileInfo, err error) error {
+		return nil
+	}, zipwalk.WithCentralDirectoryCache(cache))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cache.hits != stats.Zips {
+		t.Errorf("Expected the walk to find all %d warmed up central directories in the cache, found %d", stats.Zips, cache.hits)
+	}
+}
+
+func TestWalkPathsRoundTripStat(t *testing.T) {
+	nested := testutil.ZipBytes(t, map[string][]byte{"./x.txt": []byte("x"), "y//z.txt": []byte("z")})
+	zipPath := testutil.NewTempZip(t, map[string][]byte{
+		"./a.txt":    []byte("a"),
+		"dir//b.txt": []byte("b"),
+		"./dir/c/":   nil,
+		"./n.zip":    nested,
+	})
+	var paths []string
+	err := zipwalk.WalkCompat(zipPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		paths = append(paths, path)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 7 {
+		t.Errorf("Expected 7 paths, got %q", paths)
+	}
+	for _, p := range paths {
+		if strings.Contains(p, "//") || strings.Contains(p, "/./") || strings.HasSuffix(p, "/") {
+			t.Errorf("Expected a clean path, got %s", p)
+		}
+		info, err := zipwalk.Stat(p)
+		if err != nil {
+			t.Errorf("Expected to Stat %s, got %v", p, err)
+			continue
+		}
+		if info.Name() != filepath.Base(p) {
+			t.Errorf("Expected Stat of %s to name %s, got %s", p, filepath.Base(p), info.Name())
+		}
+	}
+}
+
+func TestWalkToJSON(t *testing.T) {
+	zipPath := testutil.NewTempZip(t, map[string][]byte{"dir/a.txt": []byte("hi"), "empty.txt": nil})
+	type record struct {
+		Path    string    `json:"path"`
+		Size    int64     `json:"size"`
+		ModTime time.Time `json:"mod_time"`
+		CRC32   uint32    `json:"crc32"`
+		Content *string   `json:"content_base64"`
+	}
+	for _, withoutContent := range []bool{false, true} {
+		var opts []zipwalk.Option
+		if withoutContent {
+			opts = append(opts, zipwalk.WithoutContent())
+		}
+		var buf bytes.Buffer
+		if err := zipwalk.WalkToJSON(zipPath, &buf, opts...); err != nil {
+			t.Fatal(err)
+		}
+		got := map[string]record{}
+		dec := json.NewDecoder(&buf)
+		for dec.More() {
+			var rec record
+			if err := dec.Decode(&rec); err != nil {
+				t.Fatal(err)
+			}
+			got[rec.Path] = rec
+		}
+		if len(got) != 3 {
+			t.Fatalf("Expected lines for the zip file and its 2 files, got %+v", got)
+		}
+		a := got[zipPath+"/dir/a.txt"]
+		if a.Size != 2 || a.CRC32 != crc32.ChecksumIEEE([]byte("hi")) || a.ModTime.IsZero() {
+			t.Errorf("Expected the size, CRC-32 and time of dir/a.txt, got %+v", a)
+		}
+		if withoutContent && a.Content != nil {
+			t.Errorf("Expected no content WithoutContent, got %q", *a.Content)
+		}
+		if !withoutContent && (a.Content == nil || *a.Content != "aGk=") {
+			t.Errorf("Expected the content of dir/a.txt, got %v", a.Content)
+		}
+		if empty := got[zipPath+"/empty.txt"]; !withoutContent && (empty.Content == nil || *empty.Content != "") {
+			t.Errorf("Expected the empty content of empty.txt, got %v", empty.Content)
+		}
+		if z := got[zipPath]; z.Content != nil || z.CRC32 != 0 {
+			t.Errorf("Expected neither the CRC-32 nor the content of the zip file, got %+v", z)
+		}
+	}
+
+	// files outside zip files are read for their CRC-32, even without their content
+	var buf bytes.Buffer
+	if err := zipwalk.WalkToJSON("testdata/a.txt", &buf, zipwalk.WithoutContent()); err != nil {
+		t.Fatal(err)
+	}
+	var rec record
+	if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec.CRC32 != crc32.ChecksumIEEE([]byte("hi there")) || rec.Content != nil {
+		t.Errorf("Expected the CRC-32 of testdata/a.txt without its content, got %+v", rec)
+	}
+}
+
+func TestWithMaxNameLength(t *testing.T) {
+	long := strings.Repeat("é", 200) + ".txt"
+	zipPath := testutil.NewTempZip(t, map[string][]byte{"dir/" + long: []byte("long"), "short.txt": []byte("short")})
+	walkNames := func(opts ...zipwalk.Option) []string {
+		var names []string
+		err := zipwalk.WalkCompat(zipPath, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			names = append(names, strings.TrimPrefix(path, zipPath+"/"))
+			return nil
+		}, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return names
+	}
+	if got := walkNames(); !reflect.DeepEqual(got[1:], []string{"dir/" + long, "short.txt"}) {
+		t.Errorf("Expected the full names without WithMaxNameLength, got %q", got)
+	}
+	got := walkNames(zipwalk.WithMaxNameLength(0))
+	if len(got) != 3 || got[2] != "short.txt" {
+		t.Fatalf("Expected 3 paths ending with short.txt, got %q", got)
+	}
+	short := strings.TrimPrefix(got[1], "dir/")
+	if len(short) > 255 || !utf8.ValidString(short) || !strings.HasSuffix(short, ".txt") || !strings.HasPrefix(long, short[:100]) {
+		t.Errorf("Expected %s to be shortened to at most 255 bytes of valid UTF-8 keeping its extension, got %s", long, short)
+	}
+	dst := t.TempDir()
+	if err := zipwalk.Mirror(zipPath, dst, zipwalk.WithMaxNameLength(0)); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := ioutil.ReadFile(filepath.Join(dst, "dir", short)); err != nil || string(content) != "long" {
+		t.Errorf("Expected Mirror to write the entry as %s, got %q - %v", short, content, err)
+	}
+}
+
+func TestCompressDir(t *testing.T) {
+	src := t.TempDir()
+	modTime := time.Date(2020, 5, 6, 7, 8, 10, 0, time.UTC)
+	files := map[string][]byte{
+		"a.txt":         []byte(strings.Repeat("a", 1000)),
+		"sub/b.txt":     []byte("b"),
+		"sub/c.tmp":     []byte("c"),
+		"sub/d.json":    []byte("{}"),
+		"sub/n.zip":     testutil.ZipBytes(t, map[string][]byte{"inner.txt": []byte("inner"), "skip.tmp": nil}),
+		"tmp.tmp/e.txt": []byte("e"),
+	}
+	for name, content := range files {
+		p := filepath.Join(src, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(p, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dst := filepath.Join(src, "out.zip")
+	err := zipwalk.CompressDir(src, dst, zipwalk.WithExcludePattern("*.tmp"), zipwalk.WithExtensions(".txt", ".zip"), zipwalk.WithCompressionLevel(flate.BestCompression))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]time.Time{}
+	err = zipwalk.WalkCompat(dst, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && path != dst {
+			got[strings.TrimPrefix(path, dst+"/")] = info.(zipwalk.ZipFileInfo).ZipHeader().Modified
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"a.txt", "sub/b.txt", "sub/n.zip", "sub/n.zip/inner.txt", "sub/n.zip/skip.tmp"}
+	var names []string
+	for name := range got {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("Expected %q, got %q", want, names)
+	}
+	if !got["a.txt"].Equal(modTime) {
+		t.Errorf("Expected the modification time of a.txt to be kept as %v, got %v", modTime, got["a.txt"])
+	}
+}
+
+func TestStreamZip(t *testing.T) {
+	nested := testutil.ZipBytes(t, map[string][]byte{"keep.txt": []byte("keep"), "drop.bin": []byte("drop")})
+	src := testutil.NewTempZip(t, map[string][]byte{"a.txt": []byte("a"), "b.bin": []byte("b"), "dir/n.zip": nested, "whole.zip": nested})
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(zipwalk.StreamZip(src, pw, func(path string, info os.FileInfo) bool {
+			return strings.HasSuffix(path, ".txt") || strings.HasSuffix(path, "whole.zip")
+		}))
+	}()
+	buf, err := ioutil.ReadAll(pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf), int64(len(buf)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	want := []string{"a.txt", "dir/n.zip/keep.txt", "whole.zip"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("Expected %q, got %q", want, names)
+	}
+}
+
+func TestWithZipBoundaryEvents(t *testing.T) {
+	nested := testutil.ZipBytes(t, map[string][]byte{"c.txt": []byte("c")})
+	zipPath := testutil.NewTempZip(t, map[string][]byte{"a.txt": []byte("a"), "b.zip": nested, "skipped.zip": nested})
+	var got []string
+	err := zipwalk.WalkCompat(zipPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		event := strings.TrimPrefix(path, zipPath)
+		if zfi, ok := info.(zipwalk.ZipFileInfo); ok && zfi.IsEntering {
+			event = "enter " + event
+			if strings.HasSuffix(path, "skipped.zip") {
+				got = append(got, event)
+				return zipwalk.SkipZip
+			}
+		} else if ok && zfi.IsLeaving {
+			event = "leave " + event
+		}
+		got = append(got, event)
+		return nil
+	}, zipwalk.WithZipBoundaryEvents(), zipwalk.WithExtensions(".txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"enter ", "/a.txt", "enter /b.zip", "/b.zip/c.txt", "leave /b.zip", "enter /skipped.zip", "leave "}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+func TestRepair(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	contents := map[string]string{
+		"deflated.txt": strings.Repeat("deflated ", 100),
+		"stored.txt":   "stored",
+		"cut.txt":      strings.Repeat("cut short ", 100),
+	}
+	for _, name := range []string{"deflated.txt", "stored.txt", "cut.txt"} {
+		method := zip.Deflate
+		if name == "stored.txt" {
+			method = zip.Store
+		}
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: method})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(contents[name]))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cutAt, err := zr.File[2].DataOffset()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "truncated.zip"), filepath.Join(dir, "repaired.zip")
+	if err := ioutil.WriteFile(src, buf.Bytes()[:cutAt+10], 0644); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := zipwalk.Repair(src, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats != (zipwalk.RepairStats{Recovered: 2, Lost: 1}) {
+		t.Errorf("Expected 2 entries recovered and 1 lost, got %+v", stats)
+	}
+	got := map[string]string{}
+	err = zipwalk.Walk(dst, func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == dst {
+			return nil
+		}
+		content, err := ioutil.ReadAll(reader)
+		got[info.Name()] = string(content)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got["deflated.txt"] != contents["deflated.txt"] || got["stored.txt"] != contents["stored.txt"] {
+		t.Errorf("Expected deflated.txt and stored.txt to be recovered, got %q", got)
+	}
+}
+
+// ntfsExtra returns an NTFS Extra Field holding t as the modification, access and creation
+// times.
+func ntfsExtra(t time.Time) []byte {
+	ticks := uint64((t.Unix()-time.Date(1601, 1, 1, 0, 0, 0, 0, time.UTC).Unix())*1e7 + int64(t.Nanosecond()/100))
+	extra := make([]byte, 36)
+	binary.LittleEndian.PutUint16(extra[0:], 0x000a)
+	binary.LittleEndian.PutUint16(extra[2:], 32)
+	binary.LittleEndian.PutUint16(extra[8:], 1)
+	binary.LittleEndian.PutUint16(extra[10:], 24)
+	for i := 12; i < 36; i += 8 {
+		binary.LittleEndian.PutUint64(extra[i:], ticks)
+	}
+	return extra
+}
+
+func TestNTFSModTime(t *testing.T) {
+	modTime := time.Date(2021, 3, 4, 5, 6, 7, 123456700, time.UTC)
+	extra := ntfsExtra(modTime)
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	// Modified makes zip.Writer add an extended timestamp field after the NTFS one, holding
+	// whole seconds only
+	for _, fh := range []*zip.FileHeader{{Name: "ntfs.txt", Extra: extra, Modified: modTime}, {Name: "plain.txt", Modified: modTime}} {
+		if _, err := zw.CreateHeader(fh); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zipPath := filepath.Join(t.TempDir(), "ntfs.zip")
+	if err := ioutil.WriteFile(zipPath, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	zipInfo, err := os.Stat(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]time.Time{}
+	err = zipwalk.Walk(zipPath, func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		if err != nil {
+			return err
+		}
+		got[info.Name()] = info.ModTime()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got["ntfs.txt"].Equal(modTime) {
+		t.Errorf("Expected the NTFS time %v of ntfs.txt, got %v", modTime, got["ntfs.txt"])
+	}
+	if !got["plain.txt"].Equal(zipInfo.ModTime()) {
+		t.Errorf("Expected the time of the zip file %v for plain.txt, got %v", zipInfo.ModTime(), got["plain.txt"])
+	}
+}
+
+func TestWithRetry(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("permissions are not enforced for root")
+	}
+	dir := t.TempDir()
+	locked := filepath.Join(dir, "locked.txt")
+	if err := ioutil.WriteFile(locked, []byte("locked"), 0); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	var gotErr error
+	err := zipwalk.Walk(dir, func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		if path == locked {
+			gotErr = err
+		}
+		return nil
+	}, zipwalk.WithRetry(5, time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(gotErr, os.ErrPermission) {
+		t.Errorf("Expected the permission error of %s, got %v", locked, gotErr)
+	}
+	if time.Since(start) > 30*time.Second {
+		t.Errorf("Expected permission errors not to be retried, took %v", time.Since(start))
+	}
+}
+
+func TestWithRetryTransient(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "a.txt")
+	if err := ioutil.WriteFile(name, []byte("hi there"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	const backoff = 20 * time.Millisecond
+	for _, errno := range []syscall.Errno{syscall.ESTALE, syscall.EIO, syscall.EAGAIN} {
+		for _, failures := range []int{0, 2, 3, 4} {
+			var attempts []time.Time
+			openFile := func(path string) (*os.File, error) {
+				attempts = append(attempts, time.Now())
+				if len(attempts) <= failures {
+					return nil, &os.PathError{Op: "open", Path: path, Err: errno}
+				}
+				return os.Open(path)
+			}
+			var content string
+			var gotErr error
+			err := zipwalk.Walk(dir, func(path string, info os.FileInfo, reader io.Reader, err error) error {
+				if path != name {
+					return err
+				}
+				gotErr = err
+				if err == nil {
+					buf, err := ioutil.ReadAll(reader)
+					content = string(buf)
+					return err
+				}
+				return nil
+			}, zipwalk.WithRetry(4, backoff), zipwalk.WithOpenFile(openFile))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if failures < 4 {
+				// succeeding on attempt failures+1 hands the content to walkFn
+				if gotErr != nil || content != "hi there" || len(attempts) != failures+1 {
+					t.Errorf("%v after %d failures - expected the content after %d attempts, got %q - %v after %d", errno, failures, failures+1, content, gotErr, len(attempts))
+				}
+			} else if !errors.Is(gotErr, errno) || len(attempts) != 4 {
+				t.Errorf("%v - expected the error after 4 attempts, got %v after %d", errno, gotErr, len(attempts))
+			}
+			for i := 1; i < len(attempts); i++ {
+				if wait, min := attempts[i].Sub(attempts[i-1]), backoff<<(i-1); wait < min {
+					t.Errorf("%v - expected attempt %d to wait at least %v, waited %v", errno, i+1, min, wait)
+				}
+			}
+		}
+	}
+}
+
+// countingBytePool records the buffers taken from and given back to the BytePool it wraps.
+type countingBytePool struct {
+	zipwalk.BytePool
+	gets, puts int32
+}
+
+func (p *countingBytePool) Get() []byte {
+	atomic.AddInt32(&p.gets, 1)
+	return p.BytePool.Get()
+}
+
+func (p *countingBytePool) Put(buf []byte) {
+	atomic.AddInt32(&p.puts, 1)
+	p.BytePool.Put(buf)
+}
+
+func TestWithBytePool(t *testing.T) {
+	nested := testutil.ZipBytes(t, map[string][]byte{"c.txt": []byte(strings.Repeat("c", 5000))})
+	zipPath := testutil.NewTempZip(t, map[string][]byte{"a.txt": []byte("a"), "b.zip": nested, "d.zip": nested})
+	walk := func(opts ...zipwalk.Option) map[string]string {
+		got := map[string]string{}
+		err := zipwalk.Walk(zipPath, func(path string, info os.FileInfo, reader io.Reader, err error) error {
+			if err != nil {
+				return err
+			}
+			buf, err := ioutil.ReadAll(reader)
+			got[path] = string(buf)
+			return err
+		}, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+	want := walk()
+	for _, concurrent := range []int{0, 4} {
+		pool := &countingBytePool{BytePool: zipwalk.NewBytePool(16)}
+		if got := walk(zipwalk.WithBytePool(pool), zipwalk.WithConcurrentRead(concurrent)); !reflect.DeepEqual(got, want) {
+			t.Errorf("Expected the same content with a pool and %d concurrent reads", concurrent)
+		}
+		if pool.gets == 0 || pool.gets != pool.puts {
+			t.Errorf("Expected every buffer taken to be given back with %d concurrent reads, got %d taken and %d given back", concurrent, pool.gets, pool.puts)
+		}
+	}
+}
+
+func TestWithConcurrentReadDirectory(t *testing.T) {
+	dir := t.TempDir()
+	for i := 0; i < 5; i++ {
+		files := map[string][]byte{}
+		for j := 0; j < 10; j++ {
+			files[fmt.Sprintf("f%d.txt", j)] = bytes.Repeat([]byte("x"), 1000*(j+1))
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("z%d.zip", i)), testutil.ZipBytes(t, files), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// neither guarded nor atomic, so the race detector catches concurrent calls of walkFn
+	var got []string
+	inWalkFn := int32(0)
+	err := zipwalk.Walk(dir, func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		if err != nil {
+			return err
+		}
+		if atomic.AddInt32(&inWalkFn, 1) != 1 {
+			t.Error("walkFn called concurrently")
+		}
+		defer atomic.AddInt32(&inWalkFn, -1)
+		if filepath.Ext(path) == ".txt" {
+			got = append(got, strings.TrimPrefix(filepath.ToSlash(path), filepath.ToSlash(dir)+"/"))
+		}
+		return nil
+	}, zipwalk.WithConcurrentRead(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 50 || got[0] != "z0.zip/f0.txt" || got[49] != "z4.zip/f9.txt" {
+		t.Errorf("Expected the entries of the 5 zip files in order, got %v", got)
+	}
+}
+
+func TestWithConcurrentReadChecksEntries(t *testing.T) {
+	zipPath := testutil.NewTempZip(t, map[string][]byte{"a.txt": []byte("a"), "b.txt": []byte("b"), "c.bin": []byte("c"), "d.bin": []byte("d")})
+	errBinary := errors.New("binary file")
+	pool := &countingBytePool{BytePool: zipwalk.NewBytePool(16)}
+	snap := zipwalk.NewSnapshot(map[string]zipwalk.EntrySnapshot{
+		zipPath + "/a.txt": {Size: 1, CRC32: crc32.ChecksumIEEE([]byte("a"))},
+	}, nil)
+	var walked []string
+	err := zipwalk.Walk(zipPath, func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		if errors.Is(err, errBinary) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if path != zipPath {
+			walked = append(walked, strings.TrimPrefix(path, zipPath+"/"))
+		}
+		return nil
+	}, zipwalk.WithBytePool(pool), zipwalk.WithConcurrentRead(4), zipwalk.WithSnapshot(snap), zipwalk.WithEntryValidator(func(path string, header *zip.FileHeader) error {
+		if strings.HasSuffix(header.Name, ".bin") {
+			return errBinary
+		}
+		return nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"b.txt"}; !reflect.DeepEqual(walked, expected) {
+		t.Errorf("Expected %v walked, got %v", expected, walked)
+	}
+	if pool.gets != 1 {
+		t.Errorf("Expected only b.txt to be read ahead, got %d entries read", pool.gets)
+	}
+}
+
+func TestWithContentDedup(t *testing.T) {
+	nested := testutil.ZipBytes(t, map[string][]byte{"copy.txt": []byte("same"), "other.txt": []byte("other")})
+	zipPath := testutil.NewTempZip(t, map[string][]byte{"a.txt": []byte("same"), "b.txt": []byte("same"), "n.zip": nested})
+	var walked []string
+	dups := map[string]string{}
+	err := zipwalk.WalkCompat(zipPath, func(path string, info os.FileInfo, err error) error {
+		walked = append(walked, strings.TrimPrefix(path, zipPath))
+		return err
+	}, zipwalk.WithContentDedup(func(newPath, originalPath string, info os.FileInfo) {
+		dups[strings.TrimPrefix(newPath, zipPath)] = strings.TrimPrefix(originalPath, zipPath)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantWalked := []string{"", "/a.txt", "/n.zip", "/n.zip/other.txt"}
+	if !reflect.DeepEqual(walked, wantWalked) {
+		t.Errorf("Expected %q to be walked, got %q", wantWalked, walked)
+	}
+	wantDups := map[string]string{"/b.txt": "/a.txt", "/n.zip/copy.txt": "/a.txt"}
+	if !reflect.DeepEqual(dups, wantDups) {
+		t.Errorf("Expected duplicates %q, got %q", wantDups, dups)
+	}
+}
+
+func TestWithPathMapper(t *testing.T) {
+	nested := testutil.ZipBytes(t, map[string][]byte{"lib-1.2.3/c.txt": []byte("c")})
+	zipPath := testutil.NewTempZip(t, map[string][]byte{"app-1.0/a.txt": []byte("a"), "app-1.0/n-2.0.zip": nested})
+	version := regexp.MustCompile(`-[0-9.]+[0-9]`)
+	mapper := zipwalk.WithPathMapper(func(path string, info os.FileInfo) string {
+		return version.ReplaceAllString(strings.TrimPrefix(path, zipPath), "")
+	})
+	var got []string
+	err := zipwalk.WalkCompat(zipPath, func(path string, info os.FileInfo, err error) error {
+		got = append(got, path)
+		return err
+	}, mapper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"", "/app/a.txt", "/app/n.zip", "/app/n.zip/lib/c.txt"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	original := zipPath + "/app-1.0/n-2.0.zip/lib-1.2.3/c.txt"
+	var entry zipwalk.Entry
+	err = zipwalk.Walk2(zipPath, func(e zipwalk.Entry) error {
+		if e.OriginalPath == original {
+			entry = e
+		}
+		return e.Err
+	}, mapper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Path != "/app/n.zip/lib/c.txt" || len(entry.ZipChain) != 2 {
+		t.Errorf("Expected Walk2 to map %s to /app/n.zip/lib/c.txt within 2 zip files, got %+v", original, entry)
+	}
+}
+
+func TestNewZipTree(t *testing.T) {
+	nested := testutil.ZipBytes(t, map[string][]byte{"c.txt": []byte("c")})
+	zipPath := testutil.NewTempZip(t, map[string][]byte{"a.txt": []byte("a"), "dir/sub/b.txt": []byte("b"), "dir/n.zip": nested})
+	tree, err := zipwalk.NewZipTree(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tree.IsZip || tree.Name != filepath.Base(zipPath) || len(tree.Children) != 2 {
+		t.Fatalf("Expected the zip file with a.txt and dir, got %+v", tree)
+	}
+	for p, isZip := range map[string]bool{"a.txt": false, "dir": false, "dir/sub/b.txt": false, "dir/n.zip": true, "dir/n.zip/c.txt": false} {
+		node, ok := tree.Find(p)
+		if !ok {
+			t.Errorf("Expected to find %s", p)
+			continue
+		}
+		if node.Name != path.Base(p) || node.IsZip != isZip || node.Info == nil {
+			t.Errorf("Expected %s with IsZip %v, got %+v", p, isZip, node)
+		}
+	}
+	if dir, _ := tree.Find("dir"); !dir.Info.IsDir() {
+		t.Errorf("Expected the implied directory dir to be a directory")
+	}
+	if _, ok := tree.Find("dir/missing.txt"); ok {
+		t.Errorf("Expected dir/missing.txt not to be found")
+	}
+	if nested, err := zipwalk.NewZipTree(zipPath + "/dir/n.zip"); err != nil || len(nested.Children) != 1 {
+		t.Errorf("Expected the tree of the nested zip file to hold c.txt, got %+v - %v", nested, err)
+	}
+
+	tree, err = zipwalk.NewZipTree(encryptedEntryZip(t))
+	if err != nil {
+		t.Fatalf("Error building the tree of a zip file with an encrypted entry - %v", err)
+	}
+	if node, ok := tree.Find("dir/secret.zip"); !ok || !errors.Is(node.Err, zipwalk.ErrEncrypted) || node.Info == nil {
+		t.Errorf("Expected the encrypted zip file to be in the tree with ErrEncrypted, got %+v", node)
+	}
+	if _, ok := tree.Find("n.zip/c.txt"); !ok {
+		t.Errorf("Expected the entries after the encrypted one to be in the tree")
+	}
+}
+
+func TestWithRateLimit(t *testing.T) {
+	zipPath := testutil.NewTempZip(t, map[string][]byte{"a.txt": bytes.Repeat([]byte("a"), 30000), "b.txt": bytes.Repeat([]byte("b"), 30000)})
+	start := time.Now()
+	var read int
+	err := zipwalk.Walk(zipPath, func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		if err != nil || path == zipPath {
+			return err
+		}
+		buf, err := ioutil.ReadAll(reader)
+		read += len(buf)
+		return err
+	}, zipwalk.WithRateLimit(20000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a second's worth of bytes comes at once, the remaining 40000 bytes take 2 seconds
+	if elapsed := time.Since(start); read != 60000 || elapsed < 1500*time.Millisecond {
+		t.Errorf("Expected 60000 bytes to take 2 seconds at 20000 bytes per second, read %d in %v", read, elapsed)
+	}
+}
+
+func TestWithCanonicalOrder(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range []string{"b.txt", "C.txt", "a.txt", "A.txt", "Été.txt"} {
+		if _, err := zw.Create(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zipPath := filepath.Join(t.TempDir(), "order.zip")
+	if err := ioutil.WriteFile(zipPath, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	walk := func(opts ...zipwalk.Option) []string {
+		var got []string
+		err := zipwalk.Walk(zipPath, func(path string, info os.FileInfo, reader io.Reader, err error) error {
+			if path != zipPath {
+				got = append(got, info.Name())
+			}
+			return err
+		}, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+	if got, want := walk(zipwalk.WithCanonicalOrder()), []string{"A.txt", "a.txt", "b.txt", "C.txt", "Été.txt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	if got, want := walk(zipwalk.WithCanonicalOrder(), zipwalk.WithReverseOrder()), []string{"Été.txt", "C.txt", "b.txt", "a.txt", "A.txt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %q in reverse, got %q", want, got)
+	}
+}
+
+// seekCounter counts the seeks made on the io.ReadSeeker it wraps.
+type seekCounter struct {
+	io.ReadSeeker
+	seeks int
+}
+
+func (sc *seekCounter) Seek(offset int64, whence int) (int64, error) {
+	sc.seeks++
+	return sc.ReadSeeker.Seek(offset, whence)
+}
+
+func TestNewReaderAt(t *testing.T) {
+	zipBytes := testutil.ZipBytes(t, map[string][]byte{"a.txt": []byte("a"), "b.txt": []byte("b")})
+	rs := &seekCounter{ReadSeeker: bytes.NewReader(zipBytes)}
+	ra := zipwalk.NewReaderAt(rs)
+	var got []string
+	err := zipwalk.WalkAt(ra, int64(len(zipBytes)), "seekable.zip", func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		got = append(got, path)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"seekable.zip", "seekable.zip/a.txt", "seekable.zip/b.txt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	buf := make([]byte, 4)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p := make([]byte, 4)
+			if _, err := ra.ReadAt(p, 0); err != nil || !bytes.Equal(p, zipBytes[:4]) {
+				t.Errorf("Expected the first 4 bytes from concurrent reads, got %q - %v", p, err)
+			}
+		}()
+	}
+	wg.Wait()
+	seeks := rs.seeks
+	ra.ReadAt(buf, 0)
+	ra.ReadAt(buf, 4)
+	if rs.seeks != seeks+1 {
+		t.Errorf("Expected a read following the previous one not to seek, got %d seeks for 2 reads", rs.seeks-seeks)
+	}
+	if n, err := ra.ReadAt(make([]byte, 10), int64(len(zipBytes)-4)); n != 4 || err != io.EOF {
+		t.Errorf("Expected 4 bytes and io.EOF reading past the end, got %d - %v", n, err)
+	}
+}
+
+func TestNestedZipReaderRace(t *testing.T) {
+	inner := testutil.ZipBytes(t, map[string][]byte{"c.txt": []byte(strings.Repeat("c", 10000)), "d.txt": []byte("d")})
+	middle := testutil.ZipBytes(t, map[string][]byte{"inner.zip": inner, "b.txt": []byte("b")})
+	zipPath := testutil.NewTempZip(t, map[string][]byte{"middle.zip": middle})
+	var wg sync.WaitGroup
+	var files int32
+	err := zipwalk.Walk(zipPath, func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == zipPath || !strings.HasSuffix(path, ".zip") {
+			atomic.AddInt32(&files, 1)
+			return nil
+		}
+		// keep reading the nested zip file, both ways, while its entries are walked
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			io.Copy(ioutil.Discard, reader)
+			if ra, ok := reader.(io.ReaderAt); ok {
+				ra.ReadAt(make([]byte, 100), 0)
+			}
+			if s, ok := reader.(io.Seeker); ok {
+				s.Seek(0, io.SeekStart)
+			}
+		}()
+		return nil
+	})
+	wg.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files != 4 {
+		t.Errorf("Expected the zip file and the 3 files inside the nested zip files, got %d", files)
+	}
+}
+
+func TestExplicitDirectoryEntries(t *testing.T) {
+	zipBytes := new(testutil.Builder).
+		AddFile("subdir/", "").
+		AddFile("subdir/file.txt", "hi there").
+		Build()
+	zipPath := filepath.Join(t.TempDir(), "dirs.zip")
+	if err := ioutil.WriteFile(zipPath, zipBytes, 0644); err != nil {
+		t.Fatal(err)
+	}
+	isDir := map[string]bool{}
+	err := zipwalk.Walk(zipPath, func(path string, info os.FileInfo, reader io.Reader, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != zipPath {
+			isDir[strings.TrimPrefix(path, zipPath+"/")] = info.IsDir()
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]bool{"subdir": true, "subdir/file.txt": false}
+	if !reflect.DeepEqual(isDir, expected) {
+		t.Errorf("Expected IsDir of %v, got %v", expected, isDir)
+	}
+}
+
+func TestWithSnapshot(t *testing.T) {
+	nested := testutil.ZipBytes(t, map[string][]byte{"c.txt": []byte("c")})
+	zipPath := testutil.NewTempZip(t, map[string][]byte{"a.txt": []byte("a"), "b.txt": []byte("b"), "n.zip": nested})
+	walk := func(prev map[string]zipwalk.EntrySnapshot) (walked, skipped []string) {
+		var mu sync.Mutex
+		snap := zipwalk.NewSnapshot(prev, func(path string, info os.FileInfo) {
+			mu.Lock()
+			skipped = append(skipped, strings.TrimPrefix(path, zipPath+"/"))
+			mu.Unlock()
+		})
+		err := zipwalk.Walk(zipPath, func(path string, info os.FileInfo, reader io.Reader, err error) error {
+			if err != nil {
+				return err
+			}
+			if path != zipPath {
+				mu.Lock()
+				walked = append(walked, strings.TrimPrefix(path, zipPath+"/"))
+				mu.Unlock()
+			}
+			return nil
+		}, zipwalk.WithSnapshot(snap))
+		if err != nil {
+			t.Fatal(err)
+		}
+		snap.UpdateSnapshot(prev)
+		sort.Strings(walked)
+		sort.Strings(skipped)
+		return walked, skipped
+	}
+	prev := map[string]zipwalk.EntrySnapshot{}
+	walked, skipped := walk(prev)
+	if expected := []string{"a.txt", "b.txt", "n.zip", "n.zip/c.txt"}; !reflect.DeepEqual(walked, expected) || len(skipped) != 0 {
+		t.Fatalf("Expected %v walked and nothing skipped on the first walk, got %v and %v", expected, walked, skipped)
+	}
+	if entry := prev[zipPath+"/n.zip"]; entry.Size != uint64(len(nested)) || entry.CRC32 != crc32.ChecksumIEEE(nested) {
+		t.Errorf("Expected n.zip to be recorded with its size and CRC32, got %+v", entry)
+	}
+
+	// rewrite the zip file with b.txt changed
+	if err := ioutil.WriteFile(zipPath, testutil.ZipBytes(t, map[string][]byte{"a.txt": []byte("a"), "b.txt": []byte("bb"), "n.zip": nested}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	walked, skipped = walk(prev)
+	if expected := []string{"b.txt"}; !reflect.DeepEqual(walked, expected) {
+		t.Errorf("Expected only %v walked on the second walk, got %v", expected, walked)
+	}
+	if expected := []string{"a.txt", "n.zip"}; !reflect.DeepEqual(skipped, expected) {
+		t.Errorf("Expected %v skipped on the second walk, got %v", expected, skipped)
+	}
+	if prev[zipPath+"/b.txt"].Size != 2 || len(prev) != 4 {
+		t.Errorf("Expected the snapshot to be updated with the changed b.txt, got %+v", prev)
+	}
+}